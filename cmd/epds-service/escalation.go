@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"example.com/epds-service/internal/deadletter"
+	"example.com/epds-service/internal/metrics"
+)
+
+var (
+	selfHarmEscalationsTotal = metrics.NewCounter(
+		"epds_self_harm_escalations_total",
+		"Undeliverable self-harm alert Communications escalated to SELF_HARM_ESCALATION_URL.",
+	)
+	selfHarmEscalationFailuresTotal = metrics.NewCounter(
+		"epds_self_harm_escalation_failures_total",
+		"Self-harm escalations that SELF_HARM_ESCALATION_URL did not accept.",
+	)
+)
+
+// escalationEvent is the body POSTed to SELF_HARM_ESCALATION_URL.
+type escalationEvent struct {
+	Event       string    `json:"event"` // Always "self-harm-communication-failed"
+	Target      string    `json:"target"`
+	PatientID   string    `json:"patientId"`
+	Instrument  string    `json:"instrument"`
+	TotalScore  int       `json:"totalScore"`
+	Q10Score    int       `json:"q10Score"` // -1 when the self-harm item was unanswered
+	Attempts    int       `json:"attempts"` // Creates tried, including the submission's own
+	FailedSince time.Time `json:"failedSince"`
+	Error       string    `json:"error"`
+}
+
+// escalateIfSelfHarm escalates a Communication that will not be delivered (abandoned by the
+// deadletter worker, or failed with nowhere to queue it) when the self-harm item triggered it.
+// Elevated-total alerts are not escalated; their Flag and logs remain.
+func (h *ApiHandler) escalateIfSelfHarm(e deadletter.Entry) {
+	if e.Kind != deadletter.KindCommunication || h.Config.SelfHarmEscalationURL == "" {
+		return
+	}
+	cfg := h.Config
+	if target, ok := h.Targets[e.Target]; ok {
+		cfg = target.Config
+	}
+	inst, ok := cfg.Instrument(e.Instrument)
+	if !ok || inst.Unscored || !inst.Rules.SelfHarmPositive(e.Q10Score) {
+		return
+	}
+
+	event := escalationEvent{
+		Event:       "self-harm-communication-failed",
+		Target:      e.Target,
+		PatientID:   e.PatientID,
+		Instrument:  inst.Name,
+		TotalScore:  e.TotalScore,
+		Q10Score:    e.Q10Score,
+		Attempts:    e.Attempts + 1,
+		FailedSince: e.CreatedAt.UTC(),
+		Error:       e.LastError,
+	}
+	selfHarmEscalationsTotal.Inc()
+	if err := postEscalation(cfg.SelfHarmEscalationURL, cfg.SelfHarmEscalationTimeout, event); err != nil {
+		selfHarmEscalationFailuresTotal.Inc()
+		log.Printf("ERROR: *** Self-harm alert for patient %s could not be delivered AND escalation failed; notify the provider manually: %v ***", e.PatientID, err)
+		return
+	}
+	log.Printf("Escalated undeliverable self-harm alert for patient %s to SELF_HARM_ESCALATION_URL", e.PatientID)
+}
+
+// postEscalation POSTs event as JSON, trying up to three times, and succeeds on any 2xx.
+func postEscalation(url string, timeout time.Duration, event escalationEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal escalation: %w", err)
+	}
+	client := &http.Client{Timeout: timeout}
+	for attempt := 1; ; attempt++ {
+		err = func() error {
+			resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+			if err != nil {
+				return fmt.Errorf("escalation request failed: %w", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+				return fmt.Errorf("escalation webhook returned status %d: %s", resp.StatusCode, msg)
+			}
+			return nil
+		}()
+		if err == nil || attempt == 3 {
+			return err
+		}
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
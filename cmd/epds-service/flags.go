@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"example.com/epds-service/internal/config"
+	"example.com/epds-service/internal/fhir"
+	"example.com/epds-service/internal/transport"
+)
+
+// defaultFlagPageSize is the GET /api/v1/flags/active page size when the client sets none
+// (capped at FLAGS_MAX_PAGE_SIZE).
+const defaultFlagPageSize = 50
+
+// maxFlagOffset is the largest offset accepted; clients page further with the next cursor.
+// Severity orders search their way past skipped Flags, so this bounds the searches a request makes.
+const maxFlagOffset = 1000
+
+// flagSorts maps the accepted _sort values to the FHIR _sort used within each search.
+// Severity orders also walk the severity levels, one search per level.
+var flagSorts = map[string]string{
+	"-date":     "-_lastUpdated",
+	"date":      "_lastUpdated",
+	"-severity": "-_lastUpdated",
+	"severity":  "-_lastUpdated",
+}
+
+// ActiveFlagsResponse is the body of GET /api/v1/flags/active.
+type ActiveFlagsResponse struct {
+	Flags []fhir.ActiveFlag `json:"flags"`
+	Next  string            `json:"next,omitempty"` // Path of the next page; absent on the last page
+}
+
+// flagCursor is the state behind the opaque next-page cursor. Date orders follow the FHIR
+// server's own next link; severity orders record how far into which level they are.
+type flagCursor struct {
+	Page   string `json:"page,omitempty"`   // FHIR next-page URL (date orders)
+	Sort   string `json:"sort"`             // Client _sort
+	Count  int    `json:"count"`            // Page size
+	Level  int    `json:"level,omitempty"`  // Index into severityLevels(Sort)
+	Offset int    `json:"offset,omitempty"` // Flags of that level already returned
+}
+
+// severityLevels is the order severity sorts walk: highest first for -severity, lowest
+// first for severity. Flags without a severity tag always come last.
+func severityLevels(sort string) []string {
+	levels := slices.Clone(config.FlagSeverityLevels)
+	if sort == "-severity" {
+		slices.Reverse(levels)
+	}
+	return append(levels, fhir.SeverityUnrated)
+}
+
+// handleActiveFlags lists the active high-risk Flags this service created, for
+// care-coordination boards: newest first by default, or ordered by _sort. Pages are sized
+// by _count (or limit), may skip offset Flags, and continue with the opaque cursor from the
+// previous response's next link.
+func (h *ApiHandler) handleActiveFlags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h, err := h.forTarget(r)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	var cur flagCursor
+	offset := 0
+	if cursor := query.Get("cursor"); cursor != "" {
+		// The cursor carries the sort, page size and position of the listing it continues
+		decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || json.Unmarshal(decoded, &cur) != nil || flagSorts[cur.Sort] == "" || cur.Count < 1 || cur.Count > h.Config.FlagsMaxPageSize {
+			sendJSONError(w, "Invalid input: malformed cursor", http.StatusBadRequest)
+			return
+		}
+	} else {
+		cur.Sort = query.Get("_sort")
+		if cur.Sort == "" {
+			cur.Sort = "-date"
+		} else if flagSorts[cur.Sort] == "" {
+			sendJSONError(w, "Invalid input: _sort must be one of -date, date, -severity, severity", http.StatusBadRequest)
+			return
+		}
+		cur.Count = min(defaultFlagPageSize, h.Config.FlagsMaxPageSize)
+		v := query.Get("_count")
+		if v == "" {
+			v = query.Get("limit")
+		}
+		if v != "" {
+			if cur.Count, err = strconv.Atoi(v); err != nil || cur.Count < 1 || cur.Count > h.Config.FlagsMaxPageSize {
+				sendJSONError(w, "Invalid input: _count must be an integer from 1 to "+strconv.Itoa(h.Config.FlagsMaxPageSize), http.StatusBadRequest)
+				return
+			}
+		}
+		if v := query.Get("offset"); v != "" {
+			if offset, err = strconv.Atoi(v); err != nil || offset < 0 || offset > maxFlagOffset {
+				sendJSONError(w, "Invalid input: offset must be an integer from 0 to "+strconv.Itoa(maxFlagOffset)+"; page further with the next cursor", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	token, err := h.Authenticator.GetAuthToken()
+	if err != nil {
+		log.Printf("ERROR: Failed to get Oystehr token: %v", err)
+		h.sendUpstreamError(w, err, "Internal server error - authentication failed", http.StatusInternalServerError)
+		return
+	}
+	client := transport.WithTokenRefresh(h.HTTPClient, h.Authenticator)
+	var flags []fhir.ActiveFlag
+	var next *flagCursor
+	if cur.Sort == "-severity" || cur.Sort == "severity" {
+		flags, next, err = h.activeFlagsBySeverity(client, token, cur, offset)
+	} else {
+		var page fhir.FlagPage
+		page, err = fhir.FindActiveHighRiskFlags(client, h.Config, token, fhir.FlagQuery{Count: cur.Count, Offset: offset, Sort: flagSorts[cur.Sort]}, cur.Page)
+		flags = page.Flags
+		if page.Next != "" {
+			next = &flagCursor{Page: page.Next, Sort: cur.Sort, Count: cur.Count}
+		}
+	}
+	if errors.Is(err, fhir.ErrInvalidPageURL) {
+		sendJSONError(w, "Invalid input: cursor does not belong to this environment", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to search active Flags: %v", err)
+		h.sendUpstreamError(w, err, "Failed to search FHIR Flags", http.StatusInternalServerError)
+		return
+	}
+
+	resp := ActiveFlagsResponse{Flags: flags}
+	if next != nil {
+		encoded, _ := json.Marshal(next)
+		resp.Next = r.URL.Path + "?" + url.Values{"cursor": {base64.RawURLEncoding.EncodeToString(encoded)}}.Encode()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// activeFlagsBySeverity fills one page of a severity order from cur, searching each level
+// in turn (newest first within a level) until the page is full. skip Flags are passed over
+// first. No search asks for more than FLAGS_MAX_PAGE_SIZE Flags. It returns the cursor for
+// the following page, nil after the last level.
+func (h *ApiHandler) activeFlagsBySeverity(client *http.Client, token string, cur flagCursor, skip int) ([]fhir.ActiveFlag, *flagCursor, error) {
+	levels := severityLevels(cur.Sort)
+	flags := []fhir.ActiveFlag{}
+	for cur.Level < len(levels) && len(flags) < cur.Count {
+		want := cur.Count - len(flags)
+		page, err := fhir.FindActiveHighRiskFlags(client, h.Config, token, fhir.FlagQuery{
+			Count:    min(skip+want, h.Config.FlagsMaxPageSize),
+			Offset:   cur.Offset,
+			Sort:     flagSorts[cur.Sort],
+			Severity: levels[cur.Level],
+		}, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		skipped := min(skip, len(page.Flags))
+		taken := min(want, len(page.Flags)-skipped)
+		flags = append(flags, page.Flags[skipped:skipped+taken]...)
+		skip -= skipped
+		cur.Offset += skipped + taken
+		// A level is done once the server has no more and every returned Flag was used
+		if len(page.Flags) == 0 || (page.Next == "" && skipped+taken == len(page.Flags)) {
+			cur.Level, cur.Offset = cur.Level+1, 0
+		}
+	}
+	if cur.Level >= len(levels) {
+		return flags, nil, nil
+	}
+	return flags, &cur, nil
+}
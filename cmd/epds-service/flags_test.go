@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"example.com/epds-service/internal/config"
+	"example.com/epds-service/internal/fhir"
+)
+
+// flagServer is a FHIR server holding Flags f0, f1, ... (newest first) with the given
+// severities ("" for none). It filters by the severity _tag, honors _offset and _count, and
+// records the largest _count asked for.
+func flagServer(t *testing.T, severities []string, largestCount *int) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		count, _ := strconv.Atoi(q.Get("_count"))
+		offset, _ := strconv.Atoi(q.Get("_offset"))
+		*largestCount = max(*largestCount, count)
+
+		var entries []map[string]any
+		for i, severity := range severities {
+			tag := fhir.SeverityTagSystem + "|" + severity
+			if slices.ContainsFunc(q["_tag"], func(t string) bool { return strings.HasPrefix(t, fhir.SeverityTagSystem+"|") && t != tag }) ||
+				slices.Contains(q["_tag:not"], tag) {
+				continue
+			}
+			flag := map[string]any{"resourceType": "Flag", "id": fmt.Sprintf("f%d", i), "subject": map[string]string{"reference": "Patient/p1"}}
+			if severity != "" {
+				flag["meta"] = map[string]any{"tag": []map[string]string{{"system": fhir.SeverityTagSystem, "code": severity}}}
+			}
+			entries = append(entries, map[string]any{"resource": flag})
+		}
+		end := min(offset+count, len(entries))
+		page := map[string]any{"resourceType": "Bundle", "entry": entries[min(offset, end):end]}
+		if end < len(entries) {
+			q.Set("_offset", strconv.Itoa(end))
+			page["link"] = []map[string]string{{"relation": "next", "url": "http://" + r.Host + "/Flag?" + q.Encode()}}
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestActiveFlagsBySeverity(t *testing.T) {
+	// Ordered by -severity: f0 f3 f5 f8 (high), f1 f6 (moderate), f4 f9 (low), f2 f7 (none)
+	severities := []string{"high", "moderate", "", "high", "low", "high", "moderate", "", "high", "low"}
+	bySeverity := []string{"f0", "f3", "f5", "f8", "f1", "f6", "f4", "f9", "f2", "f7"}
+
+	tests := []struct {
+		name  string
+		count int
+		skip  int
+	}{
+		{"first page", 3, 0},
+		{"page within a level", 2, 1},
+		{"page across levels", 4, 3},
+		{"offset past several levels", 3, 7},
+		{"offset larger than the page size limit", 2, 8},
+		{"offset past the end", 3, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var largestCount int
+			server := flagServer(t, severities, &largestCount)
+			h := &ApiHandler{Config: &config.Config{OystehrFHIRBaseURL: server.URL, OystehrProjectID: "project", FlagsMaxPageSize: 3}}
+
+			flags, _, err := h.activeFlagsBySeverity(server.Client(), "token", flagCursor{Sort: "-severity", Count: tt.count}, tt.skip)
+			if err != nil {
+				t.Fatalf("activeFlagsBySeverity error: %v", err)
+			}
+			var got []string
+			for _, f := range flags {
+				got = append(got, f.FlagID)
+			}
+			want := bySeverity[min(tt.skip, len(bySeverity)):min(tt.skip+tt.count, len(bySeverity))]
+			if !slices.Equal(got, want) {
+				t.Errorf("flags = %v, want %v", got, want)
+			}
+			if largestCount > h.Config.FlagsMaxPageSize {
+				t.Errorf("searched with _count=%d, above FLAGS_MAX_PAGE_SIZE %d", largestCount, h.Config.FlagsMaxPageSize)
+			}
+		})
+	}
+}
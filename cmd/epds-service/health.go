@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"example.com/epds-service/internal/fhir"
+	"example.com/epds-service/internal/transport"
+)
+
+// providerCheckTTL is how long a successful alert-provider check is reused, so frequent
+// readiness probes don't hammer FHIR. Failures are never cached.
+const providerCheckTTL = time.Minute
+
+// HealthResponse is the body of GET /healthz.
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"` // Deep mode: per-target alert provider result
+}
+
+// HealthHandler serves /healthz. The shallow check only confirms the process is serving;
+// ?deep=true also verifies that each target's ALERT_PROVIDER_FHIR_ID still exists and is active.
+type HealthHandler struct {
+	Targets    map[string]Target
+	HTTPClient *http.Client
+	Now        func() time.Time
+
+	mutex   sync.Mutex
+	okUntil map[string]time.Time // Target name -> expiry of its cached successful check
+}
+
+// ServeHTTP implements http.Handler.
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	resp := HealthResponse{Status: "ok"}
+	code := http.StatusOK
+
+	if r.URL.Query().Get("deep") == "true" {
+		resp.Checks = make(map[string]string, len(h.Targets))
+		for name, target := range h.Targets {
+			if err := h.checkProvider(name, target); err != nil {
+				log.Printf("ERROR: Deep health check failed for target %s: %v", name, err)
+				resp.Checks[name] = err.Error()
+				resp.Status = "unavailable"
+				code = http.StatusServiceUnavailable
+			} else {
+				resp.Checks[name] = "ok"
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// checkProvider verifies the target's alert provider, reusing a recent success.
+func (h *HealthHandler) checkProvider(name string, target Target) error {
+	h.mutex.Lock()
+	cached := h.Now().Before(h.okUntil[name])
+	h.mutex.Unlock()
+	if cached {
+		return nil
+	}
+
+	token, err := target.Authenticator.GetAuthToken()
+	if err != nil {
+		return err
+	}
+	client := transport.WithTokenRefresh(h.HTTPClient, target.Authenticator)
+	if err := fhir.CheckAlertProvider(client, target.Config, token); err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.okUntil == nil {
+		h.okUntil = make(map[string]time.Time)
+	}
+	h.okUntil[name] = h.Now().Add(providerCheckTTL)
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"maps"
+	"slices"
+	"time"
+
+	"example.com/epds-service/internal/fhir"
+	"example.com/epds-service/internal/metrics"
+	"example.com/epds-service/internal/transport"
+)
+
+var flagsResolvedTotal = metrics.NewCounter(
+	"epds_flags_resolved_total",
+	"High-risk Flags set inactive by the janitor because a later screen was below threshold.",
+)
+
+// runFlagJanitor resolves stale high-risk Flags in every FHIR target each interval until
+// ctx is cancelled (FLAG_JANITOR_INTERVAL_SECONDS).
+func (h *ApiHandler) runFlagJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, name := range slices.Sorted(maps.Keys(h.Targets)) {
+				h.resolveStaleFlags(h.Targets[name])
+			}
+		}
+	}
+}
+
+// resolveStaleFlags sets inactive the active high-risk Flags not updated for
+// FLAG_JANITOR_MIN_AGE_DAYS whose patient has since been screened again below threshold.
+// Each Flag is judged on the latest screen of the instrument it was raised for, by that
+// instrument's rules; Flags without an instrument tag predate it and are EPDS. A Flag is
+// only resolved by a newer screen, never by the one that raised it, and is left alone
+// whenever the latest screen cannot be read.
+func (h *ApiHandler) resolveStaleFlags(target Target) {
+	cfg := target.Config
+	token, err := target.Authenticator.GetAuthToken()
+	if err != nil {
+		log.Printf("ERROR: Flag janitor could not get an Oystehr token for %s: %v", cfg.TargetName, err)
+		return
+	}
+	client := transport.WithTokenRefresh(h.HTTPClient, target.Authenticator)
+
+	flags, err := fhir.FindStaleHighRiskFlags(client, cfg, token, cfg.Now().Add(-cfg.FlagJanitorMinAge))
+	if err != nil {
+		log.Printf("ERROR: Flag janitor search failed for %s: %v", cfg.TargetName, err)
+		return
+	}
+	resolved := 0
+	for _, flag := range flags {
+		flagged, err := time.Parse(time.RFC3339, flag.CreatedDate)
+		if err != nil || flag.PatientID == "" {
+			continue
+		}
+		inst, ok := cfg.Instrument(flag.Instrument)
+		if !ok || inst.Unscored {
+			continue
+		}
+		raw, err := fhir.FindLatestObservation(client, cfg, token, flag.PatientID, inst.TotalCode, fhir.SearchOptions{Elements: []string{"effectiveDateTime", "valueInteger", "interpretation"}})
+		if err != nil {
+			log.Printf("Warning: Flag janitor skipped Flag/%s: latest Observation unavailable: %v", flag.FlagID, err)
+			continue
+		}
+		if raw == nil {
+			continue
+		}
+		var obs struct {
+			EffectiveDateTime string `json:"effectiveDateTime"`
+			ValueInteger      *int   `json:"valueInteger"`
+			Interpretation    []struct {
+				Coding []struct {
+					Code string `json:"code"`
+				} `json:"coding"`
+			} `json:"interpretation"`
+		}
+		if err := json.Unmarshal(raw, &obs); err != nil || obs.ValueInteger == nil {
+			continue
+		}
+		effective, err := time.Parse(time.RFC3339, obs.EffectiveDateTime)
+		if err != nil || !effective.After(flagged) {
+			continue // No screen since the Flag was raised
+		}
+		// The interpretation reflects the full determination (including the self-harm item);
+		// Observations recorded before it was added are judged on the total
+		highRisk := inst.Rules.TotalHighRisk(*obs.ValueInteger)
+		if len(obs.Interpretation) > 0 && len(obs.Interpretation[0].Coding) > 0 {
+			highRisk = obs.Interpretation[0].Coding[0].Code == "H"
+		}
+		if highRisk {
+			continue
+		}
+
+		if err := fhir.ResolveFlag(client, cfg, token, flag.FlagID); err != nil {
+			log.Printf("ERROR: Flag janitor failed to resolve Flag/%s: %v", flag.FlagID, err)
+			continue
+		}
+		resolved++
+		flagsResolvedTotal.Inc()
+		log.Printf("Resolved Flag/%s for patient %s: latest %s total %d on %s is below threshold", flag.FlagID, flag.PatientID, inst.Display, *obs.ValueInteger, obs.EffectiveDateTime)
+	}
+	log.Printf("Flag janitor for %s: %d stale Flags checked, %d resolved", cfg.TargetName, len(flags), resolved)
+}
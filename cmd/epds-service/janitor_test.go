@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/epds-service/internal/auth"
+	"example.com/epds-service/internal/config"
+	"example.com/epds-service/internal/fhir"
+	"example.com/epds-service/internal/scoring"
+)
+
+func TestResolveStaleFlagsUsesTheFlagsInstrument(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	flagged := now.AddDate(0, 0, -30).Format(time.RFC3339)
+	rescreened := now.AddDate(0, 0, -1).Format(time.RFC3339)
+
+	tests := []struct {
+		name         string
+		instrument   string // Flag's instrument tag; "" for a Flag raised before it was recorded
+		totalCode    string // LOINC code the latest screen is recorded under
+		latestTotal  int
+		wantResolved bool
+	}{
+		{"EPDS below its threshold", "epds", "99046-5", 12, true},
+		{"EPDS at its threshold", "epds", "99046-5", 13, false},
+		{"untagged Flag is EPDS", "", "99046-5", 12, true},
+		{"PHQ-9 above its threshold though below EPDS's", "phq9", "44261-6", 12, false},
+		{"PHQ-9 below its threshold", "phq9", "44261-6", 9, true},
+		{"PHQ-9 Flag ignores a later EPDS screen", "phq9", "99046-5", 5, false},
+		{"unknown instrument left alone", "gad7", "99046-5", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var observationCodes []string
+			resolved := false
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				tags := []map[string]string{{"system": "urn:cornell:epds:tags", "code": "epds-high-risk"}}
+				if tt.instrument != "" {
+					tags = append(tags, map[string]string{"system": fhir.InstrumentTagSystem, "code": tt.instrument})
+				}
+				flag := map[string]any{"resourceType": "Flag", "id": "flag-1", "status": "active", "subject": map[string]string{"reference": "Patient/p1"}, "meta": map[string]any{"lastUpdated": flagged, "tag": tags}}
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/Flag":
+					json.NewEncoder(w).Encode(map[string]any{"resourceType": "Bundle", "entry": []any{map[string]any{"resource": flag}}})
+				case r.Method == http.MethodGet && r.URL.Path == "/Observation":
+					code := r.URL.Query().Get("code")
+					observationCodes = append(observationCodes, code)
+					entries := []any{}
+					if code == "http://loinc.org|"+tt.totalCode {
+						entries = append(entries, map[string]any{"resource": map[string]any{"resourceType": "Observation", "effectiveDateTime": rescreened, "valueInteger": tt.latestTotal}})
+					}
+					json.NewEncoder(w).Encode(map[string]any{"resourceType": "Bundle", "entry": entries})
+				case r.Method == http.MethodGet && r.URL.Path == "/Flag/flag-1":
+					json.NewEncoder(w).Encode(flag)
+				case r.Method == http.MethodPut && r.URL.Path == "/Flag/flag-1":
+					resolved = true
+					w.Write([]byte(`{"resourceType":"Flag","id":"flag-1","status":"inactive"}`))
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL)
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			cfg := &config.Config{
+				OystehrFHIRBaseURL: server.URL,
+				OystehrProjectID:   "project",
+				HighRiskThreshold:  13,
+				HighRiskOperator:   scoring.OperatorGTE,
+				Clock:              func() time.Time { return now },
+			}
+			h := &ApiHandler{HTTPClient: server.Client()}
+			h.resolveStaleFlags(Target{Config: cfg, Authenticator: &auth.StaticTokenProvider{Token: "token"}})
+
+			if resolved != tt.wantResolved {
+				t.Errorf("Flag resolved = %t, want %t", resolved, tt.wantResolved)
+			}
+			for _, code := range observationCodes {
+				if tt.instrument == "phq9" && !strings.HasSuffix(code, "|44261-6") {
+					t.Errorf("PHQ-9 Flag judged on Observation code %s", code)
+				}
+			}
+		})
+	}
+}
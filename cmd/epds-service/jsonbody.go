@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"example.com/epds-service/internal/schema"
+)
+
+// schemaError reports a JSON body that does not match the submission schema.
+type schemaError struct {
+	Errors []schema.FieldError
+}
+
+func (e *schemaError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Field + " " + fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// isJSONBody reports whether the request body is application/json.
+func isJSONBody(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json"
+}
+
+// parseJSONBody decodes a JSON submission, validates it against s (returning a
+// *schemaError listing every failing field), and copies it into r.Form so the rest of
+// the handler treats it like a form post (e.g. "q1": 2 -> q1=2). The optional "scores"
+// array is returned separately; it is nil when absent.
+func parseJSONBody(r *http.Request, s *schema.Schema) ([]int, error) {
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	var body map[string]any
+	if err := dec.Decode(&body); err != nil {
+		return nil, err
+	}
+
+	// Treat null like an absent field
+	for key, value := range body {
+		if value == nil {
+			delete(body, key)
+		}
+	}
+	if errs := s.Validate(body); len(errs) > 0 {
+		return nil, &schemaError{Errors: errs}
+	}
+
+	form := url.Values{}
+	var scores []int
+	for key, value := range body {
+		switch v := value.(type) {
+		case string:
+			form.Set(key, v)
+		case json.Number:
+			form.Set(key, v.String())
+		case []any:
+			if key != "scores" {
+				continue
+			}
+			scores = make([]int, len(v))
+			for i, item := range v {
+				n, _ := item.(json.Number).Int64() // Integer type guaranteed by the schema
+				scores[i] = int(n)
+			}
+		}
+	}
+
+	r.Form = form
+	r.PostForm = form
+	return scores, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"example.com/epds-service/internal/config"
+	"example.com/epds-service/internal/fhir"
+)
+
+// requestLocale picks the locale for the provider-facing Flag and Communication text:
+// the "language" field, then the Accept-Language header in q-value order, then
+// config.DefaultLocale. Only locales with a message table are chosen; a regional tag
+// such as "es-MX" matches "es".
+func requestLocale(r *http.Request, cfg *config.Config) string {
+	candidates := []string{strings.TrimSpace(r.FormValue("language"))}
+	return matchLocale(cfg, append(candidates, acceptLanguages(r.Header.Get("Accept-Language"))...))
+}
+
+// patientLocale picks the locale for patient-facing text (the receipt in the submission
+// response, and any patient-directed message): the patient's "patientLanguage" preference,
+// then the Accept-Language header of their portal, then config.DefaultLocale.
+func patientLocale(r *http.Request, cfg *config.Config) string {
+	candidates := []string{strings.TrimSpace(r.FormValue("patientLanguage"))}
+	return matchLocale(cfg, append(candidates, acceptLanguages(r.Header.Get("Accept-Language"))...))
+}
+
+// matchLocale returns the first of the candidate language tags with a message table.
+func matchLocale(cfg *config.Config, candidates []string) string {
+	for _, tag := range candidates {
+		tag = strings.ToLower(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		if _, ok := cfg.Messages[tag]; ok || tag == config.DefaultLocale {
+			return tag
+		}
+		base, _, _ := strings.Cut(tag, "-")
+		if _, ok := cfg.Messages[base]; ok || base == config.DefaultLocale {
+			return base
+		}
+	}
+	return config.DefaultLocale
+}
+
+// acceptLanguages returns the language tags of an Accept-Language header, most
+// preferred first. Tags with q=0 are dropped.
+func acceptLanguages(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var langs []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if tag = strings.TrimSpace(tag); tag != "" && q > 0 {
+			langs = append(langs, weighted{tag, q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	tags := make([]string, len(langs))
+	for i, l := range langs {
+		tags[i] = l.tag
+	}
+	return tags
+}
+
+// defaultReceipt is the English patient-facing confirmation of a submission; other
+// locales use the "receipt" entry of their message table.
+const defaultReceipt = "Thank you. Your %s questionnaire has been received and will be reviewed by your care team."
+
+// receiptMessage renders the patient-facing confirmation of a submission in locale.
+// It deliberately leaves out scores and identifiers unless a configured template adds them.
+func receiptMessage(cfg *config.Config, locale string, data fhir.AlertMessageData) string {
+	if tmpl := cfg.Messages[locale][config.MessageReceipt]; tmpl != nil {
+		var buf strings.Builder
+		err := tmpl.Execute(&buf, data)
+		if err == nil {
+			return buf.String()
+		}
+		log.Printf("Warning: failed to render LOCALIZED_MESSAGES_FILE %s, using default message: %v", tmpl.Name(), err)
+	}
+	return fmt.Sprintf(defaultReceipt, data.Instrument)
+}
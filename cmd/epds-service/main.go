@@ -1,31 +1,123 @@
 package main
 
 import (
+	"context"
 	"encoding/json" // Import for JSON error responses
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math/rand/v2"
 	"net/http"
+	"os"
+	"regexp"
+	"slices"
 	"strconv" // Import for string conversion
 	"strings" // Import for string manipulation (optional, could be useful)
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"example.com/epds-service/internal/audit"
 	"example.com/epds-service/internal/auth"   // Import the auth package
 	"example.com/epds-service/internal/config" // Import the config package
-	"example.com/epds-service/internal/fhir"   // Import the fhir package
+	"example.com/epds-service/internal/deadletter"
+	"example.com/epds-service/internal/dedup"
+	"example.com/epds-service/internal/fhir" // Import the fhir package
+	"example.com/epds-service/internal/metrics"
+	"example.com/epds-service/internal/middleware"
+	"example.com/epds-service/internal/schema"
+	"example.com/epds-service/internal/scoring"
+	"example.com/epds-service/internal/transport"
 )
 
 // ApiHandler holds dependencies for the API handlers.
 type ApiHandler struct {
 	Config        *config.Config
-	Authenticator *auth.Authenticator
-	// TODO: Consider adding a shared HTTP client here if needed for multiple FHIR calls
+	Authenticator auth.TokenProvider
+	HTTPClient    *http.Client                   // Shared outbound client (proxy-aware) for FHIR calls
+	Audit         audit.Writer                   // Optional audit sink; nil disables auditing
+	DeadLetter    deadletter.Queue               // Optional retry queue for failed high-risk side-effects
+	Dedup         *dedup.Cache[submissionResult] // Recent results by content hash; nil disables deduplication
+	PatientIDs    *dedup.Cache[string]           // Patient IDs by identifier (PATIENT_CACHE_TTL_SECONDS); nil disables caching
+	Scorer        *scoring.Webhook               // External scoring (SCORING_WEBHOOK_URL); nil scores locally
+	Targets       map[string]Target              // Oystehr projects selectable via X-EPDS-Env; nil uses Config/Authenticator
+	Maintenance   *atomic.Bool                   // Maintenance mode; shared by target-bound copies so /admin/maintenance affects all
+}
+
+// Target is one Oystehr project submissions can be routed to. Each target has its own
+// Authenticator so tokens for different projects are cached separately.
+type Target struct {
+	Config        *config.Config
+	Authenticator auth.TokenProvider
+}
+
+// EnvHeader selects the FHIR target for a request; absent means the first configured target.
+const EnvHeader = "X-EPDS-Env"
+
+// AttemptHeader optionally numbers a client's tries of one submission (1 for the first),
+// so retries can be told apart from new submissions in logs and metrics.
+const AttemptHeader = "X-Attempt-Number"
+
+// submissionResult is everything needed to answer a submission, kept so an identical
+// resubmission can be answered without recreating resources.
+type submissionResult struct {
+	Response  SuccessResponseV2 // Trimmed to SuccessResponse for v1 clients
+	Resources []fhir.Created    // Returned as a Bundle to FHIR clients
 }
 
 // ErrorResponse defines the structure for JSON error responses.
 type ErrorResponse struct {
-	Status  string `json:"status"`
-	Message string `json:"message"`
+	Status  string              `json:"status"`
+	Message string              `json:"message"`
+	Errors  []schema.FieldError `json:"errors,omitempty"` // Per-field failures for JSON bodies
+	Debug   *DebugEcho          `json:"debug,omitempty"`  // Failed FHIR create, only with DEBUG_ECHO
+}
+
+// DebugEcho is the exact FHIR exchange behind a failed create (DEBUG_ECHO=true only).
+// Request and Response are embedded as JSON when they are JSON, otherwise as strings.
+type DebugEcho struct {
+	ResourceType string `json:"resourceType"`
+	Status       int    `json:"status"`
+	Request      any    `json:"request"`
+	Response     any    `json:"response"`
+}
+
+// SuccessResponse defines the structure for a successful submission response.
+// Warnings lists non-fatal failures (e.g. Flag creation) that did not prevent the Observation.
+type SuccessResponse struct {
+	APIVersion      string          `json:"apiVersion"` // Shape of this response (see responseVersion)
+	Status          string          `json:"status"`
+	ObservationID   string          `json:"observationId"`
+	CalculatedScore int             `json:"calculatedScore"`
+	Interpretation  *Interpretation `json:"interpretation,omitempty"`
+	Receipt         *Receipt        `json:"receipt,omitempty"`
+	Unscored        bool            `json:"unscored,omitempty"` // No scoring rules for the instrument; calculatedScore is not meaningful
+	Warnings        []string        `json:"warnings,omitempty"`
 }
 
+// Interpretation is the severity band of the total score (SCORE_BANDS), so clients
+// don't have to re-implement the clinical banding.
+type Interpretation struct {
+	Code  string `json:"code"`
+	Label string `json:"label"`
+}
+
+// Receipt is the patient-facing confirmation of a submission in the patient's language
+// (patientLanguage, then Accept-Language), for portals to show as-is.
+type Receipt struct {
+	Language string `json:"language"`
+	Message  string `json:"message"`
+}
+
+// instrumentNamePattern matches the names accepted for unscored instruments (RECORD_UNSCORED_INSTRUMENTS).
+var instrumentNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// submittedByPattern matches the accepted submittedBy references (practitioner or device).
+var submittedByPattern = regexp.MustCompile(`^(Practitioner|Device)/` + strings.TrimPrefix(fhir.IDPattern, "^"))
+
 // Helper function to send JSON errors
 func sendJSONError(w http.ResponseWriter, message string, code int) {
 	w.Header().Set("Content-Type", "application/json")
@@ -33,31 +125,421 @@ func sendJSONError(w http.ResponseWriter, message string, code int) {
 	json.NewEncoder(w).Encode(ErrorResponse{Status: "error", Message: message})
 }
 
+// sendUpstreamError reports a failed Oystehr call. While the circuit breaker is open the
+// client gets a fast 503 with Retry-After instead of the generic message and code. With
+// DEBUG_ECHO a rejected create also returns the payload sent and the FHIR response.
+func (h *ApiHandler) sendUpstreamError(w http.ResponseWriter, err error, message string, code int) {
+	if errors.Is(err, transport.ErrCircuitOpen) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(h.Config.CircuitBreakerCooldown.Seconds())))
+		sendJSONError(w, "Upstream FHIR service temporarily unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var createErr *fhir.CreateError
+	if h.Config.DebugEcho && errors.As(err, &createErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(ErrorResponse{Status: "error", Message: message, Debug: &DebugEcho{
+			ResourceType: createErr.ResourceType,
+			Status:       createErr.StatusCode,
+			Request:      jsonOrString(createErr.Request),
+			Response:     jsonOrString([]byte(createErr.Response)),
+		}})
+		return
+	}
+	sendJSONError(w, message, code)
+}
+
+// jsonOrString returns b as raw JSON when it is valid JSON, otherwise as a string.
+func jsonOrString(b []byte) any {
+	if json.Valid(b) {
+		return json.RawMessage(b)
+	}
+	return string(b)
+}
+
+// recordAttempt logs the client's X-Attempt-Number and counts retries (attempts after the
+// first). The header is advisory: a missing or malformed value never rejects the submission.
+func recordAttempt(r *http.Request) {
+	v := strings.TrimSpace(r.Header.Get(AttemptHeader))
+	if v == "" {
+		return
+	}
+	attempt, err := strconv.Atoi(v)
+	if err != nil || attempt < 1 {
+		log.Printf("WARN: ignoring invalid %s %q from %s", AttemptHeader, v, r.RemoteAddr)
+		return
+	}
+	if attempt > 1 {
+		submissionRetriesTotal.Inc()
+		log.Printf("Submission attempt %d (retry) from %s", attempt, r.RemoteAddr)
+		return
+	}
+	log.Printf("Submission attempt %d from %s", attempt, r.RemoteAddr)
+}
+
+// forTarget returns a copy of the handler bound to the target named by the X-EPDS-Env
+// header, or the default target when the header is absent. Unknown names are rejected
+// rather than falling back, so a request meant for one project never lands in another.
+func (h *ApiHandler) forTarget(r *http.Request) (*ApiHandler, error) {
+	name := strings.ToLower(strings.TrimSpace(r.Header.Get(EnvHeader)))
+	if h.Targets == nil || name == "" {
+		return h, nil
+	}
+	target, ok := h.Targets[name]
+	if !ok {
+		return nil, fmt.Errorf("%s must be one of %s", EnvHeader, strings.Join(h.Config.TargetNames, ", "))
+	}
+	bound := *h
+	bound.Config = target.Config
+	bound.Authenticator = target.Authenticator
+	return &bound, nil
+}
+
+// writeSubmissionResult sends a successful result as a FHIR Bundle or, in the requested
+// version's shape, a SuccessResponse, depending on the Accept header.
+func writeSubmissionResult(w http.ResponseWriter, r *http.Request, res submissionResult, version string) {
+	if wantsFHIR(r) {
+		w.Header().Set("Content-Type", "application/fhir+json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(fhir.NewCollectionBundle(res.Resources...))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(versionedResponse(res.Response, version))
+}
+
+// wantsFHIR reports whether the client asked for a FHIR response via the Accept header.
+func wantsFHIR(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/fhir+json")
+}
+
+// sanitizeComments trims free text and drops control characters other than line breaks
+// and tabs, normalizing CRLF to LF, so it is safe to store and display as a note.
+func sanitizeComments(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, text)
+	return strings.TrimSpace(text)
+}
+
+// duplicateQFields returns the question fields (q1..qN) sent more than once, in order.
+// FormValue would silently score the first value, so a repeated field is rejected.
+func duplicateQFields(r *http.Request) []string {
+	var dups []string
+	for i := 1; i <= scoring.NumQuestions; i++ {
+		qKey := fmt.Sprintf("q%d", i)
+		if len(r.Form[qKey]) > 1 {
+			dups = append(dups, qKey)
+		}
+	}
+	return dups
+}
+
+// rateLimited wraps next in the submission rate limiter, or returns it unchanged when
+// RATE_LIMIT_PER_MINUTE is 0 (the default).
+func rateLimited(cfg *config.Config, next http.Handler) http.Handler {
+	if cfg.RateLimitPerMinute <= 0 {
+		return next
+	}
+	// Key by API key only when keys are verified; otherwise any header value is a new bucket
+	keyFunc := middleware.ClientIP
+	if len(cfg.APIKeys) > 0 {
+		keyFunc = middleware.APIKeyOrClientIP
+	}
+	limiter := middleware.NewRateLimiter(cfg.RateLimitPerMinute, cfg.RateLimitBurst, keyFunc)
+	log.Printf("Rate limiting submissions to %d/min per client (burst %d)", cfg.RateLimitPerMinute, cfg.RateLimitBurst)
+	return limiter.Middleware(next)
+}
+
+// webhookAnswers converts validated answers for the scoring webhook, with null for the
+// self-harm item when it was not answered.
+func webhookAnswers(answers []int, selfHarmMissing bool, rules scoring.Rules) []*int {
+	out := make([]*int, len(answers))
+	for i := range answers {
+		if selfHarmMissing && i == rules.SelfHarmIndex() {
+			continue
+		}
+		out[i] = &answers[i]
+	}
+	return out
+}
+
+// unscoredAnswers reads the answers of an unscored instrument: the JSON scores array, or
+// q1..qN up to the first question not sent. Answers must be non-negative integers, as
+// there is no answer range to check them against. It returns a message for the client
+// when the answers are unusable.
+func unscoredAnswers(r *http.Request, jsonScores []int) ([]int, string) {
+	if jsonScores != nil {
+		if _, ok := r.Form["q1"]; ok {
+			return nil, "provide either scores or q1..qN, not both"
+		}
+		for i, v := range jsonScores {
+			if v < 0 {
+				return nil, fmt.Sprintf("scores[%d] must be a non-negative integer", i)
+			}
+		}
+		return jsonScores, ""
+	}
+
+	var answers []int
+	for i := 1; i <= scoring.NumQuestions; i++ {
+		qKey := fmt.Sprintf("q%d", i)
+		if _, ok := r.Form[qKey]; !ok {
+			// Answers must be contiguous from q1
+			for j := i + 1; j <= scoring.NumQuestions; j++ {
+				if _, ok := r.Form[fmt.Sprintf("q%d", j)]; ok {
+					return nil, fmt.Sprintf("%s is required when q%d is provided", qKey, j)
+				}
+			}
+			break
+		}
+		value, err := strconv.Atoi(strings.TrimSpace(r.FormValue(qKey)))
+		if err != nil || value < 0 {
+			return nil, fmt.Sprintf("%s must be a non-negative integer", qKey)
+		}
+		answers = append(answers, value)
+	}
+	if len(answers) == 0 {
+		return nil, "at least one answer (q1) is required"
+	}
+	return answers, ""
+}
+
+// validatePatientFields checks the patient identification fields and returns a
+// field-specific error message, or "" if they are usable. A field that was sent but
+// is blank after trimming is reported differently from one that was never sent.
+// With fromToken the patient is already known, so the fields become optional.
+func validatePatientFields(r *http.Request, fromToken bool) string {
+	_, patientIDSent := r.Form["patientId"]
+	_, systemSent := r.Form["patientIdentifierSystem"]
+	_, valueSent := r.Form["patientIdentifierValue"]
+	patientID := strings.TrimSpace(r.FormValue("patientId"))
+	system := strings.TrimSpace(r.FormValue("patientIdentifierSystem"))
+	value := strings.TrimSpace(r.FormValue("patientIdentifierValue"))
+
+	if patientIDSent && patientID == "" {
+		return "patientId was provided but is empty"
+	}
+	if patientID != "" && !fhir.ValidID(patientID) {
+		return "patientId must be 1-64 characters of A-Z, a-z, 0-9, '-' or '.'"
+	}
+	// Once either identifier field is sent, both must be non-empty
+	if systemSent || valueSent {
+		switch {
+		case systemSent && system == "":
+			return "patientIdentifierSystem was provided but is empty"
+		case valueSent && value == "":
+			return "patientIdentifierValue was provided but is empty"
+		case !systemSent:
+			return "patientIdentifierSystem is required when patientIdentifierValue is provided"
+		case !valueSent:
+			return "patientIdentifierValue is required when patientIdentifierSystem is provided"
+		}
+	}
+	if patientID == "" && system == "" && !fromToken {
+		return "provide patientId OR patientIdentifierSystem+patientIdentifierValue"
+	}
+	return ""
+}
+
+// subjectKey identifies the screen's subject for dedup and audit: the bare ID for a
+// Patient, as before, and Group/{id} for a Group so the two never collide.
+func subjectKey(subjectType, id string) string {
+	if subjectType == fhir.SubjectPatient {
+		return id
+	}
+	return subjectType + "/" + id
+}
+
+// validateSubjectFields checks the optional subjectType and, for a Group screen, its groupId
+// and that no patient or appointment is also given. It returns the subject type (Patient by
+// default) and a client-facing message, or "" when the fields are valid.
+func validateSubjectFields(r *http.Request) (string, string) {
+	subjectType := strings.TrimSpace(r.FormValue("subjectType"))
+	groupID := strings.TrimSpace(r.FormValue("groupId"))
+	switch subjectType {
+	case "", fhir.SubjectPatient:
+		if _, sent := r.Form["groupId"]; sent {
+			return "", "groupId is only allowed with subjectType=Group"
+		}
+		return fhir.SubjectPatient, ""
+	case fhir.SubjectGroup:
+	default:
+		return "", "subjectType must be one of " + strings.Join(fhir.SubjectTypes, ", ")
+	}
+	if groupID == "" {
+		return "", "groupId is required when subjectType is Group"
+	}
+	if !fhir.ValidID(groupID) {
+		return "", "groupId must be 1-64 characters of A-Z, a-z, 0-9, '-' or '.'"
+	}
+	for _, field := range []string{"patientId", "patientIdentifierSystem", "patientIdentifierValue", "appointmentId"} {
+		if _, sent := r.Form[field]; sent {
+			return "", field + " is not allowed when subjectType is Group"
+		}
+	}
+	return fhir.SubjectGroup, ""
+}
+
 func main() {
+	scoreMode := flag.Bool("score", false, "print the EPDS score for a set of answers and exit (no server)")
+	answers := flag.String("answers", "", "10 comma-separated answers for -score (read from stdin if empty)")
+	instrument := flag.String("instrument", config.InstrumentEPDS, "instrument scored by -score: "+strings.Join(config.InstrumentNames, " or "))
+	reflagMode := flag.Bool("reflag", false, "create missing Flags/Communications for high-risk Observations between -from and -to, then exit")
+	from := flag.String("from", "", "first Observation date (YYYY-MM-DD) for -reflag")
+	to := flag.String("to", "", "last Observation date (YYYY-MM-DD, inclusive) for -reflag")
+	dryRun := flag.Bool("dry-run", false, "with -reflag, report what would be created without creating it")
+	flag.Parse()
+
+	if *scoreMode {
+		os.Exit(runScoreMode(*instrument, *answers, os.Stdin, os.Stdout, os.Stderr))
+	}
+	if *reflagMode {
+		os.Exit(runReflagMode(*from, *to, *dryRun, os.Stdout, os.Stderr))
+	}
+
 	// Load application configuration
 	cfg, err := config.LoadConfig()
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// Create Oystehr authenticator
-	authenticator := auth.NewAuthenticator(cfg, nil) // Using default HTTP client for now
+	// Build the shared outbound HTTP client used for both auth and FHIR
+	httpClient, err := transport.NewHTTPClient(cfg)
+	if err != nil {
+		log.Fatalf("Failed to build HTTP client: %v", err)
+	}
+
+	// Create one Oystehr authenticator (and token cache) per FHIR target
+	targets := make(map[string]Target, len(cfg.Targets))
+	for name, targetCfg := range cfg.Targets {
+		targets[name] = Target{Config: targetCfg, Authenticator: auth.NewAuthenticator(targetCfg, httpClient)}
+	}
+	defaultTarget := targets[cfg.TargetNames[0]]
+	if len(targets) > 1 {
+		log.Printf("Routing submissions to FHIR targets %s via %s (default %s)", strings.Join(cfg.TargetNames, ", "), EnvHeader, cfg.TargetNames[0])
+	}
 
 	// Create the API handler with dependencies
 	apiHandler := &ApiHandler{
-		Config:        cfg,
-		Authenticator: authenticator,
+		Config:        defaultTarget.Config,
+		Authenticator: defaultTarget.Authenticator,
+		HTTPClient:    httpClient,
+		Targets:       targets,
+		Maintenance:   new(atomic.Bool),
+	}
+	if cfg.MaintenanceMode {
+		apiHandler.Maintenance.Store(true)
+		log.Println("WARNING: Starting in maintenance mode (MAINTENANCE_MODE) - submissions are rejected with 503")
+	}
+
+	// Open the audit sink if configured
+	if cfg.AuditLogPath != "" {
+		auditWriter, err := audit.NewFileWriter(cfg.AuditLogPath)
+		if err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+		defer auditWriter.Close()
+		apiHandler.Audit = auditWriter
+		log.Printf("Writing audit records to %s", cfg.AuditLogPath)
+	}
+
+	// Open the deadletter queue and start retrying failed high-risk alerts
+	if cfg.DeadLetterPath != "" {
+		queue, err := deadletter.NewFileQueue(cfg.DeadLetterPath)
+		if err != nil {
+			log.Fatalf("Failed to open deadletter queue: %v", err)
+		}
+		apiHandler.DeadLetter = queue
+		worker := &deadletter.Worker{
+			Queue:    queue,
+			Process:  apiHandler.retryDeadLetter,
+			Interval: cfg.DeadLetterRetryInterval,
+			MaxAge:   cfg.DeadLetterMaxAge,
+			Now:      cfg.Now,
+			// A self-harm alert that is finally given up on must still reach a human
+			OnAbandon: apiHandler.escalateIfSelfHarm,
+		}
+		go worker.Run(context.Background())
+		log.Printf("Queueing failed high-risk alerts in %s (%d pending)", cfg.DeadLetterPath, queue.Len())
+	}
+
+	if cfg.TokenStatsLogInterval > 0 {
+		go auth.LogCacheStats(context.Background(), cfg.TokenStatsLogInterval)
+	}
+	if cfg.FlagJanitorInterval > 0 {
+		go apiHandler.runFlagJanitor(context.Background(), cfg.FlagJanitorInterval)
+		log.Printf("Resolving high-risk Flags older than %s every %s once a later screen is below threshold", cfg.FlagJanitorMinAge, cfg.FlagJanitorInterval)
+	}
+
+	if cfg.DedupWindow > 0 {
+		apiHandler.Dedup = dedup.New[submissionResult](cfg.DedupWindow, cfg.Now)
+		log.Printf("Deduplicating identical submissions within %s", cfg.DedupWindow)
+	}
+	if cfg.PatientCacheTTL > 0 {
+		apiHandler.PatientIDs = dedup.New[string](cfg.PatientCacheTTL, cfg.Now)
+		log.Printf("Caching patient identifier resolutions for %s", cfg.PatientCacheTTL)
+	}
+	if cfg.ScoringWebhookURL != "" {
+		apiHandler.Scorer = &scoring.Webhook{URL: cfg.ScoringWebhookURL, Client: &http.Client{Timeout: cfg.ScoringWebhookTimeout}}
+		log.Printf("Scoring submissions with the webhook at %s (local fallback: %t)", cfg.ScoringWebhookURL, cfg.ScoringWebhookFallback)
+	}
+
+	if cfg.PatientTokenSecret != nil {
+		log.Printf("Accepting patient-context tokens in %s (patient from the %q claim)", PatientTokenHeader, cfg.PatientTokenClaim)
+	}
+
+	if cfg.DebugEcho {
+		log.Println("WARNING: *** DEBUG_ECHO is enabled - failed creates return FHIR payloads (including PHI) to clients. NEVER use this in production. ***")
+	}
+	if cfg.DebugPayloadSampleRate > 0 {
+		log.Printf("WARNING: Logging FHIR payloads for %g of submissions (DEBUG_PAYLOAD_SAMPLE_RATE). Patient identifiers are redacted, but scores and free text are not; use in staging only.", cfg.DebugPayloadSampleRate)
 	}
 
-	// Setup HTTP routes
-	http.HandleFunc("/api/v1/submit-epds", apiHandler.handleSubmitEPDS)
+	// Setup HTTP routes. Middleware is applied inside-out: API-key auth runs first,
+	// so the rate limiter only ever sees (and keys by) valid API keys.
+	var submitHandler http.Handler = http.HandlerFunc(apiHandler.handleSubmitEPDS)
+	var flagsHandler http.Handler = http.HandlerFunc(apiHandler.handleActiveFlags)
+	submitHandler = rateLimited(cfg, submitHandler)
+	if len(cfg.APIKeys) > 0 {
+		apiKeys := middleware.NewAPIKeyAuth(cfg.APIKeys)
+		submitHandler = apiKeys.Middleware(submitHandler)
+		flagsHandler = apiKeys.Middleware(flagsHandler)
+		log.Printf("API-key authentication enabled for submissions and the flags feed (%d keys)", len(cfg.APIKeys))
+	} else {
+		log.Println("WARNING: API_KEYS is not set - the submit and flags endpoints are unauthenticated")
+	}
+	submitHandler = apiHandler.maintenanceGate(submitHandler)
+	// Read endpoints can return sizeable JSON; submission responses are too small to compress.
+	var schemaHandler http.Handler = http.HandlerFunc(apiHandler.handleSchema)
+	if cfg.GzipMinBytes > 0 {
+		compress := middleware.Gzip(cfg.GzipMinBytes)
+		flagsHandler = compress(flagsHandler)
+		schemaHandler = compress(schemaHandler)
+	}
+	http.Handle("/api/v1/submit-epds", submitHandler)
+	http.Handle("/api/v1/flags/active", flagsHandler)
+	http.HandleFunc("/api/v1/epds/preview", apiHandler.handlePreview)
+	http.Handle("/metrics", metrics.Handler())
+	http.Handle("/schema", schemaHandler)
+	http.Handle("/healthz", &HealthHandler{Targets: targets, HTTPClient: httpClient, Now: cfg.Now})
+	// Admin keys are separate from API_KEYS so submitting clients cannot take the service down
+	if len(cfg.AdminAPIKeys) > 0 {
+		http.Handle("/admin/maintenance", middleware.NewAPIKeyAuth(cfg.AdminAPIKeys).Middleware(http.HandlerFunc(apiHandler.handleMaintenance)))
+		log.Printf("Maintenance mode can be toggled at /admin/maintenance (%d admin keys)", len(cfg.AdminAPIKeys))
+	}
 
 	// Use port from loaded config
 	addr := fmt.Sprintf(":%s", cfg.Port)
 	log.Printf("Starting EPDS service on %s", addr)
 
-	// Start the HTTP server
-	err = http.ListenAndServe(addr, nil)
+	// Start the HTTP server; a panicking handler gets a 500 instead of a dropped connection
+	err = http.ListenAndServe(addr, middleware.Recover(http.DefaultServeMux))
 	if err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
@@ -76,141 +558,892 @@ func (h *ApiHandler) handleSubmitEPDS(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Rejected non-POST request for %s", r.URL.Path)
 		return
 	}
+	recordAttempt(r)
 
-	// --- 1. Parse request body (assuming application/x-www-form-urlencoded) ---
-	if err := r.ParseForm(); err != nil {
-		log.Printf("ERROR: Failed to parse form data: %v", err)
+	// Route to the requested Oystehr project (X-EPDS-Env)
+	h, err := h.forTarget(r)
+	if err != nil {
+		log.Printf("ERROR: Validation failed - %v", err)
+		sendJSONError(w, "Invalid input: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Clients may pin the response shape; reject an unknown version before creating anything
+	apiVersion, err := responseVersion(r, h.Config.APIVersion)
+	if err != nil {
+		log.Printf("ERROR: Validation failed - %v", err)
+		sendJSONError(w, "Invalid input: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// --- 1. Parse request body (form-encoded, multipart/form-data or JSON) ---
+	r.Body = http.MaxBytesReader(w, r.Body, h.Config.MaxUploadBytes)
+	isMultipart := strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data")
+	isJSON := isJSONBody(r)
+	var jsonScores []int // Optional "scores" array (JSON bodies only)
+	var parseErr error
+	switch {
+	case isMultipart:
+		parseErr = r.ParseMultipartForm(h.Config.MaxUploadBytes)
+	case isJSON:
+		jsonScores, parseErr = parseJSONBody(r, submissionSchema(h.Config))
+	default:
+		parseErr = r.ParseForm()
+	}
+	if parseErr != nil {
+		log.Printf("ERROR: Failed to parse request body: %v", parseErr)
+		var maxErr *http.MaxBytesError
+		if errors.As(parseErr, &maxErr) {
+			sendJSONError(w, fmt.Sprintf("Request body exceeds %d bytes", h.Config.MaxUploadBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		var schemaErr *schemaError
+		if errors.As(parseErr, &schemaErr) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Status: "error", Message: "Invalid input: request body does not match /schema", Errors: schemaErr.Errors})
+			return
+		}
+		if isJSON {
+			sendJSONError(w, "Invalid JSON body: "+parseErr.Error(), http.StatusBadRequest)
+			return
+		}
 		sendJSONError(w, "Failed to parse request body", http.StatusBadRequest)
 		return
 	}
+	if isMultipart {
+		defer r.MultipartForm.RemoveAll()
+	}
 
 	// --- 2. Extract and Validate Input ---
+	if dups := duplicateQFields(r); len(dups) > 0 {
+		log.Printf("ERROR: Validation failed - repeated question fields %v", dups)
+		sendJSONError(w, "Invalid input: each question may be answered once; repeated "+strings.Join(dups, ", "), http.StatusBadRequest)
+		return
+	}
 	patientID := strings.TrimSpace(r.FormValue("patientId"))
-	idSystem  := strings.TrimSpace(r.FormValue("patientIdentifierSystem"))
-	idValue   := strings.TrimSpace(r.FormValue("patientIdentifierValue"))
-	encID     := strings.TrimSpace(r.FormValue("encounterId"))
-	apptID    := strings.TrimSpace(r.FormValue("appointmentId"))
+	idSystem := strings.TrimSpace(r.FormValue("patientIdentifierSystem"))
+	idValue := strings.TrimSpace(r.FormValue("patientIdentifierValue"))
+	encID := strings.TrimSpace(r.FormValue("encounterId"))
+	apptID := strings.TrimSpace(r.FormValue("appointmentId"))
+	source := strings.TrimSpace(r.FormValue("source"))
 
-	epdsScores := make([]int, 10)
-	for i := 1; i <= 10; i++ {
-		qKey := fmt.Sprintf("q%d", i)
-		qValueStr := r.FormValue(qKey)
-		if qValueStr == "" {
-			log.Printf("ERROR: Validation failed - %s is missing", qKey)
-			sendJSONError(w, fmt.Sprintf("Invalid input: %s is required", qKey), http.StatusBadRequest)
+	if source == "" {
+		source = "unknown"
+	} else if !slices.Contains(h.Config.AllowedSources, source) {
+		log.Printf("ERROR: Validation failed - source %q not in allow-list", source)
+		sendJSONError(w, fmt.Sprintf("Invalid input: source must be one of %s", strings.Join(h.Config.AllowedSources, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	// How the screen was administered (Observation.method)
+	method := strings.TrimSpace(r.FormValue("method"))
+	if method == "" {
+		method = h.Config.ObservationMethods[0]
+	} else if !slices.Contains(h.Config.ObservationMethods, method) {
+		log.Printf("ERROR: Validation failed - method %q not in OBSERVATION_METHODS", method)
+		sendJSONError(w, fmt.Sprintf("Invalid input: method must be one of %s", strings.Join(h.Config.ObservationMethods, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	// Optional client-assigned Observation ID for idempotent imports
+	clientObsID := strings.TrimSpace(r.FormValue("clientResourceId"))
+	if clientObsID != "" && !fhir.ValidID(clientObsID) {
+		log.Printf("ERROR: Validation failed - clientResourceId %q is not a valid FHIR id", clientObsID)
+		sendJSONError(w, "Invalid input: clientResourceId must be 1-64 characters of A-Z, a-z, 0-9, '-' or '.'", http.StatusBadRequest)
+		return
+	}
+
+	// Optional submitter (practitioner or device) recorded as Provenance
+	submittedBy := strings.TrimSpace(r.FormValue("submittedBy"))
+	if submittedBy != "" && !submittedByPattern.MatchString(submittedBy) {
+		log.Printf("ERROR: Validation failed - submittedBy %q is not a Practitioner or Device reference", submittedBy)
+		sendJSONError(w, "Invalid input: submittedBy must be a reference of the form Practitioner/{id} or Device/{id}", http.StatusBadRequest)
+		return
+	}
+
+	// Optional free-text comments from the patient, recorded as an Observation note
+	comments := sanitizeComments(r.FormValue("comments"))
+	if n := utf8.RuneCountInString(comments); n > h.Config.MaxCommentLength {
+		log.Printf("ERROR: Validation failed - comments of %d characters", n)
+		sendJSONError(w, fmt.Sprintf("Invalid input: comments must be at most %d characters, got %d", h.Config.MaxCommentLength, n), http.StatusBadRequest)
+		return
+	}
+
+	// Language of the provider-facing Flag/Communication text, and of the patient-facing receipt
+	locale := requestLocale(r, h.Config)
+	receiptLocale := patientLocale(r, h.Config)
+
+	// Screens from group classes are recorded against a FHIR Group instead of a Patient
+	subjectType, msg := validateSubjectFields(r)
+	if msg != "" {
+		log.Printf("ERROR: Validation failed - %s", msg)
+		sendJSONError(w, "Invalid input: "+msg, http.StatusBadRequest)
+		return
+	}
+	groupScreen := subjectType == fhir.SubjectGroup
+
+	// Patient-portal self-screens identify the patient by a signed session token. Body fields
+	// are then optional, and any that are sent must name the same patient.
+	var tokenPatient string
+	if raw := strings.TrimSpace(r.Header.Get(PatientTokenHeader)); raw != "" {
+		switch {
+		case h.Config.PatientTokenSecret == nil:
+			sendJSONError(w, "Invalid input: "+PatientTokenHeader+" is not accepted; PATIENT_TOKEN_SECRET is not configured", http.StatusBadRequest)
+			return
+		case groupScreen:
+			sendJSONError(w, "Invalid input: "+PatientTokenHeader+" is not allowed when subjectType is Group", http.StatusBadRequest)
+			return
+		}
+		var err error
+		if tokenPatient, err = patientFromToken(h.Config, raw); err != nil {
+			log.Printf("ERROR: Rejected %s from %s: %v", PatientTokenHeader, r.RemoteAddr, err)
+			sendJSONError(w, "Invalid patient token", http.StatusUnauthorized)
 			return
 		}
+		if patientID != "" && patientID != tokenPatient {
+			log.Printf("ERROR: patientId %s does not match the patient token (%s)", patientID, tokenPatient)
+			sendJSONError(w, "patientId does not match the authenticated patient", http.StatusForbidden)
+			return
+		}
+		patientID = tokenPatient
+	}
 
-		qValueInt, err := strconv.Atoi(qValueStr)
-		if err != nil {
-			log.Printf("ERROR: Validation failed - %s is not a valid integer ('%s'): %v", qKey, qValueStr, err)
-			sendJSONError(w, fmt.Sprintf("Invalid input: %s must be an integer", qKey), http.StatusBadRequest)
+	// Patient identification: distinguish absent fields from ones that are blank after trimming
+	if groupScreen {
+		patientID = strings.TrimSpace(r.FormValue("groupId")) // The Observation's subject
+	} else if msg := validatePatientFields(r, tokenPatient != ""); msg != "" {
+		log.Printf("ERROR: Validation failed - %s", msg)
+		sendJSONError(w, "Invalid input: "+msg, http.StatusBadRequest)
+		return
+	}
+	if idSystem != "" && len(h.Config.AllowedIdentifierSystems) > 0 && !slices.Contains(h.Config.AllowedIdentifierSystems, idSystem) {
+		log.Printf("ERROR: Validation failed - identifier system %q not in ALLOWED_IDENTIFIER_SYSTEMS", idSystem)
+		sendJSONError(w, fmt.Sprintf("Invalid input: patientIdentifierSystem must be one of %s", strings.Join(h.Config.AllowedIdentifierSystems, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	// Screening instrument: the question count, answer range and high-risk rule (EPDS by default)
+	// Without scoring rules the answers can still be recorded, unscored, when configured
+	instName := strings.ToLower(strings.TrimSpace(r.FormValue("instrument")))
+	inst, ok := h.Config.Instrument(instName)
+	if !ok && h.Config.RecordUnscoredInstruments && instrumentNamePattern.MatchString(instName) {
+		log.Printf("WARN: no scoring rules for instrument %q; recording the answers unscored", instName)
+		inst, ok = config.UnscoredInstrument(instName), true
+	}
+	if !ok {
+		log.Printf("ERROR: Validation failed - unknown instrument %q", r.FormValue("instrument"))
+		sendJSONError(w, fmt.Sprintf("Invalid input: instrument must be one of %s", strings.Join(config.InstrumentNames, ", ")), http.StatusBadRequest)
+		return
+	}
+	if groupScreen && inst.Unscored {
+		// Unscored answers live only in the QuestionnaireResponse, which is patient-scoped
+		log.Printf("ERROR: Validation failed - unscored instrument %q for a Group screen", instName)
+		sendJSONError(w, fmt.Sprintf("Invalid input: Group screens need a scored instrument (%s)", strings.Join(config.InstrumentNames, ", ")), http.StatusBadRequest)
+		return
+	}
+	rules := inst.Rules
+	numItems := rules.NumItems()
+	for i := numItems + 1; i <= scoring.NumQuestions; i++ {
+		if _, ok := r.Form[fmt.Sprintf("q%d", i)]; ok {
+			log.Printf("ERROR: Validation failed - q%d provided for %s", i, inst.Display)
+			sendJSONError(w, fmt.Sprintf("Invalid input: the %s has no q%d", inst.Display, i), http.StatusBadRequest)
 			return
 		}
+	}
 
-		if qValueInt < 0 || qValueInt > 3 {
-			log.Printf("ERROR: Validation failed - %s score (%d) out of range [0, 3]", qKey, qValueInt)
-			sendJSONError(w, fmt.Sprintf("Invalid input: %s score must be between 0 and 3", qKey), http.StatusBadRequest)
+	epdsScores := make([]int, numItems)
+	q10Missing := false // Self-harm item unanswered; only possible with STRICT_Q10=false
+	if inst.Unscored {
+		answers, msg := unscoredAnswers(r, jsonScores)
+		if msg != "" {
+			log.Printf("ERROR: Validation failed - %s", msg)
+			sendJSONError(w, "Invalid input: "+msg, http.StatusBadRequest)
+			return
+		}
+		epdsScores = answers
+	} else if jsonScores != nil {
+		// The scores array replaces q1..qN; accepting both would be ambiguous
+		for i := 1; i <= numItems; i++ {
+			if _, ok := r.Form[fmt.Sprintf("q%d", i)]; ok {
+				log.Printf("ERROR: Validation failed - both scores and q%d provided", i)
+				sendJSONError(w, fmt.Sprintf("Invalid input: provide either scores or q1..q%d, not both", numItems), http.StatusBadRequest)
+				return
+			}
+		}
+		// The schema allows the lengths and answers of every instrument; check this one's
+		if len(jsonScores) != numItems {
+			log.Printf("ERROR: Validation failed - %d scores for %s", len(jsonScores), inst.Display)
+			sendJSONError(w, fmt.Sprintf("Invalid input: scores must contain %d answers for the %s, got %d", numItems, inst.Display, len(jsonScores)), http.StatusBadRequest)
 			return
 		}
-		epdsScores[i-1] = qValueInt // Store score (adjusting for 0-based index)
+		for i, v := range jsonScores {
+			if v < rules.MinAnswer || v > rules.MaxAnswer {
+				log.Printf("ERROR: Validation failed - scores[%d] (%d) out of range [%d, %d]", i, v, rules.MinAnswer, rules.MaxAnswer)
+				sendJSONError(w, fmt.Sprintf("Invalid input: scores[%d] must be between %d and %d, got %d", i, rules.MinAnswer, rules.MaxAnswer, v), http.StatusBadRequest)
+				return
+			}
+		}
+		copy(epdsScores, jsonScores)
+	} else {
+		for i := 1; i <= numItems; i++ {
+			qKey := fmt.Sprintf("q%d", i)
+			qValueStr := r.FormValue(qKey)
+			if qValueStr == "" && i == rules.SelfHarmIndex()+1 && !h.Config.StrictQ10 {
+				log.Printf("WARN: %s is missing; flagging the screen for clinician review (STRICT_Q10=false)", qKey)
+				q10Missing = true
+				continue
+			}
+			if qValueStr == "" {
+				log.Printf("ERROR: Validation failed - %s is missing", qKey)
+				sendJSONError(w, fmt.Sprintf("Invalid input: %s is required", qKey), http.StatusBadRequest)
+				return
+			}
+
+			qValueInt, err := strconv.Atoi(qValueStr)
+			if err != nil {
+				log.Printf("ERROR: Validation failed - %s is not a valid integer ('%s'): %v", qKey, qValueStr, err)
+				sendJSONError(w, fmt.Sprintf("Invalid input: %s must be an integer", qKey), http.StatusBadRequest)
+				return
+			}
+
+			if qValueInt < rules.MinAnswer || qValueInt > rules.MaxAnswer {
+				log.Printf("ERROR: Validation failed - %s score (%d) out of range [%d, %d]", qKey, qValueInt, rules.MinAnswer, rules.MaxAnswer)
+				sendJSONError(w, fmt.Sprintf("Invalid input: %s score must be between %d and %d", qKey, rules.MinAnswer, rules.MaxAnswer), http.StatusBadRequest)
+				return
+			}
+			epdsScores[i-1] = qValueInt // Store score (adjusting for 0-based index)
+		}
 	}
 
 	log.Printf("Successfully parsed and validated input for Patient ID: %s, Scores: %v", patientID, epdsScores)
 
 	// --- 3. Calculate EPDS Score ---
-	totalScore := 0
-	for _, score := range epdsScores {
-		totalScore += score
+	var result scoring.Result
+	switch {
+	case inst.Unscored:
+		result = scoring.Result{Q10: scoring.Q10Unanswered} // No total, band or risk without rules
+	case q10Missing:
+		result = scoring.ScoreWithoutQ10(epdsScores, rules)
+	default:
+		result = scoring.Score(epdsScores, rules)
 	}
-	q10Score := epdsScores[9]
-	log.Printf("Calculated EPDS score (patient?: %s / %s|%s): Total=%d, Q10=%d", patientID, idSystem, idValue, totalScore, q10Score)
+
+	// The clinical-rules engine, when configured, owns the total and the high-risk decision
+	var flagReason, scoringWarning string
+	if h.Scorer != nil && !inst.Unscored {
+		scored, err := h.Scorer.Score(r.Context(), inst.Name, webhookAnswers(epdsScores, q10Missing, rules))
+		switch {
+		case err == nil && (scored.Total < 0 || scored.Total > rules.MaxScore()):
+			log.Printf("WARN: scoring webhook returned total %d outside 0..%d; using local scoring", scored.Total, rules.MaxScore())
+			scoringWarning = "scoring webhook returned an invalid total; the screen was scored locally"
+		case err == nil:
+			log.Printf("Scoring webhook: Total=%d (local %d), HighRisk=%t (local %t)", scored.Total, result.Total, scored.HighRisk, result.HighRisk)
+			// A positive self-harm item stays high risk unless the webhook may override it
+			switch {
+			case !result.SelfHarm || scored.HighRisk:
+			case h.Config.ScoringWebhookOverridesSelfHarm:
+				log.Printf("WARN: scoring webhook cleared a positive Q%d=%d; no alert will be raised (SCORING_WEBHOOK_OVERRIDES_SELF_HARM=true)", rules.SelfHarmIndex()+1, result.Q10)
+				result.SelfHarm = false
+			default:
+				log.Printf("WARN: scoring webhook cleared a positive Q%d=%d; keeping the screen high risk", rules.SelfHarmIndex()+1, result.Q10)
+				scored.HighRisk = true
+			}
+			result.Total, result.HighRisk, result.Band = scored.Total, scored.HighRisk, rules.Interpret(scored.Total)
+			flagReason = scored.FlagReason
+		case h.Config.ScoringWebhookFallback:
+			log.Printf("WARN: scoring webhook failed; using local scoring (SCORING_WEBHOOK_FALLBACK=true): %v", err)
+			scoringWarning = "scoring webhook unavailable; the screen was scored locally"
+		default:
+			log.Printf("ERROR: scoring webhook failed and SCORING_WEBHOOK_FALLBACK=false: %v", err)
+			sendJSONError(w, "Scoring service unavailable", http.StatusBadGateway)
+			return
+		}
+	}
+	totalScore := result.Total
+	q10Score := result.Q10
+	log.Printf("Calculated %s score (patient?: %s / %s|%s): Total=%d, Q%d=%d", inst.Display, patientID, idSystem, idValue, totalScore, rules.SelfHarmIndex()+1, q10Score)
 
 	// --- 4. Resolve Patient (if needed) & Authenticate with Oystehr ---
 	// Defer resolution until after we have a token (same headers)
 	token, err := h.Authenticator.GetAuthToken()
 	if err != nil {
 		log.Printf("ERROR: Failed to get Oystehr token: %v", err)
-		sendJSONError(w, "Internal server error - authentication failed", http.StatusInternalServerError)
+		h.sendUpstreamError(w, err, "Internal server error - authentication failed", http.StatusInternalServerError)
 		return
 	}
 	log.Printf("Successfully obtained Oystehr token.")
-	// Resolve patient via identifier if patientId was not provided
-	fhirClient := &http.Client{} // shared per request
-	if patientID == "" {
-		if idSystem == "" || idValue == "" {
-			sendJSONError(w, "provide patientId OR patientIdentifierSystem+patientIdentifierValue", http.StatusBadRequest)
-			return
+	var warnings []string // Non-fatal problems reported to the client
+	if scoringWarning != "" {
+		warnings = append(warnings, scoringWarning)
+	}
+	if q10Missing {
+		warnings = append(warnings, fmt.Sprintf("q%d (self-harm) was not answered; screen flagged as high risk for clinician review", rules.SelfHarmIndex()+1))
+	}
+
+	// Resolve patient via identifier if patientId was not provided.
+	// When both are provided, they must agree to avoid cross-patient contamination.
+	// All FHIR calls for this submission share one retry budget
+	fhirClient := transport.WithTokenRefresh(transport.WithRetryBudget(h.HTTPClient, transport.NewRetryBudget(h.Config.MaxRetriesPerRequest)), h.Authenticator)
+	var patientKey string        // PatientIDs key of the identifier; empty when the patient was given by ID
+	var provisional fhir.Created // Patient registered for an unknown identifier (PATIENT_NOT_FOUND_BEHAVIOR=create)
+	if idSystem != "" && idValue != "" {
+		idType := fhir.IdentifierType{System: h.Config.PatientIdentifierTypeSystem, Code: h.Config.PatientIdentifierTypeCode}
+		patientKey = dedup.Key(h.Config.TargetName, idSystem, idValue, idType.System, idType.Code)
+		var resolvedID string
+		var cached bool
+		var err error
+		if h.PatientIDs != nil {
+			resolvedID, cached = h.PatientIDs.Get(patientKey)
+		}
+		if !cached {
+			resolvedID, err = fhir.FindPatientIDByIdentifierOfType(fhirClient, h.Config, token, idSystem, idValue, idType)
+		}
+		if errors.Is(err, fhir.ErrPatientNotFound) && patientID == "" && h.Config.PatientNotFound == config.PatientNotFoundCreate {
+			// Site opted to register unknown identifiers as provisional patients
+			var createErr error
+			provisional, createErr = fhir.CreatePatient(fhirClient, h.Config, token, idSystem, idValue)
+			if createErr != nil {
+				log.Printf("ERROR: Failed to create provisional Patient for %s|%s: %v", idSystem, idValue, createErr)
+				h.sendUpstreamError(w, createErr, "Failed to create provisional patient from identifier", http.StatusInternalServerError)
+				return
+			}
+			log.Printf("Created provisional Patient %s for identifier %s|%s", provisional.ID, idSystem, idValue)
+			warnings = append(warnings, fmt.Sprintf("no patient matched the identifier; created provisional Patient %s", provisional.ID))
+			resolvedID, err = provisional.ID, nil
 		}
-		resolvedID, err := fhir.FindPatientIDByIdentifier(fhirClient, h.Config, token, idSystem, idValue)
 		if err != nil {
 			log.Printf("ERROR: patient lookup failed for %s|%s: %v", idSystem, idValue, err)
-			sendJSONError(w, "patient not found from identifier", http.StatusBadRequest)
+			if errors.Is(err, fhir.ErrAmbiguousPatient) {
+				sendJSONError(w, "identifier matches multiple patients", http.StatusConflict)
+				return
+			}
+			h.sendUpstreamError(w, err, "patient not found from identifier", http.StatusBadRequest)
+			return
+		}
+		if h.PatientIDs != nil && !cached {
+			h.PatientIDs.Put(patientKey, resolvedID)
+		}
+		if patientID == "" {
+			patientID = resolvedID
+		} else if resolvedID != patientID {
+			log.Printf("ERROR: patientId %s does not match identifier %s|%s (resolved to %s)", patientID, idSystem, idValue, resolvedID)
+			sendJSONError(w, "patientId does not match the patient resolved from identifier", http.StatusConflict)
 			return
 		}
-		patientID = resolvedID
 	}
 
-	// --- 5. Create FHIR Observation ---
-	observationId, err := fhir.CreateObservation(fhirClient, h.Config, token, patientID, totalScore)
-	if err != nil {
-		log.Printf("ERROR: Failed to create FHIR Observation: %v", err)
-		sendJSONError(w, "Failed to create FHIR Observation", http.StatusInternalServerError)
-		return
+	// Log this submission's payloads when it falls in the debug sample
+	if h.Config.DebugPayloadSampleRate > 0 && rand.Float64() < h.Config.DebugPayloadSampleRate {
+		fhirClient = transport.WithPayloadLog(fhirClient, patientID)
+	}
+
+	// Identical resubmissions (same patient, answers and day) within the window get the prior result
+	var dedupKey string
+	if h.Dedup != nil {
+		dedupKey = dedup.Key(h.Config.TargetName, subjectKey(subjectType, patientID), fmt.Sprint(inst.Name, epdsScores, q10Missing), fhir.EffectiveDate(h.Config))
+		if prior, ok := h.Dedup.Get(dedupKey); ok {
+			log.Printf("Duplicate submission for Patient %s within dedup window; returning prior Observation %s", patientID, prior.Response.ObservationID)
+			w.Header().Set("X-EPDS-Deduplicated", "true")
+			writeSubmissionResult(w, r, prior, apiVersion)
+			return
+		}
+	}
+
+	// --- 4b. Find the visit's Encounter (for the Observation and any Flag) before anything is created ---
+	// A Group has no visit to discover and no chart for a Flag or Communication; only the
+	// Observation is recorded, with any encounterId given
+	skipFlag := groupScreen
+	var encounter fhir.Created // Set when AUTO_CREATE_ENCOUNTER created the visit
+	if groupScreen {
+		warnings = append(warnings, "Group screen: only the Observation is recorded; no Flag, Communication, Task, QuestionnaireResponse, Provenance or attachment is created")
+	} else {
+		var encWarnings []string
+		encID, encounter, encWarnings, err = h.resolveEncounter(fhirClient, token, patientID, encID, apptID, result.HighRisk)
+		if err != nil {
+			sendJSONError(w, "Invalid input: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		warnings = append(warnings, encWarnings...)
+	}
+	if result.HighRisk && !groupScreen {
+		if encID == "" {
+			switch h.Config.NoEncounter {
+			case config.NoEncounterFail:
+				log.Printf("ERROR: No Encounter for high-risk screen of Patient %s; rejecting (NO_ENCOUNTER_BEHAVIOR=fail)", patientID)
+				sendJSONError(w, "No active encounter found for the patient; check in the visit (or pass encounterId) and resubmit", http.StatusUnprocessableEntity)
+				return
+			case config.NoEncounterSkipFlag:
+				skipFlag = true
+				warnings = append(warnings, "encounter not found; Flag skipped, provider alerted by Communication only")
+			default:
+				warnings = append(warnings, "encounter not found; Flag is patient-scoped and the chart banner may not show")
+			}
+		}
+	}
+
+	// At most one Communication of each kind per patient per COMMUNICATION_DEBOUNCE_SECONDS
+	sendNegative := h.Config.EnableNegativeScreenCommunication && !inst.Unscored && !groupScreen
+	skipComm := groupScreen
+	if (result.HighRisk && !groupScreen) || sendNegative {
+		skipComm = h.communicationDebounced(fhirClient, token, patientID, !result.HighRisk)
+		if skipComm {
+			warnings = append(warnings, "communication suppressed: one was already sent to the provider for this patient recently")
+		}
+	}
+	// ENFORCE_SAME_ORG: never alert a provider outside the patient's organization, nor when it cannot be confirmed
+	if h.Config.EnforceSameOrg && !skipComm && (result.HighRisk || sendNegative) {
+		if err := fhir.CheckSameOrganization(fhirClient, h.Config, token, patientID); err != nil {
+			log.Printf("ERROR: Refusing Communication for Patient %s to %s (ENFORCE_SAME_ORG): %v", patientID, h.Config.AlertProviderFHIRID, err)
+			skipComm = true
+			warnings = append(warnings, "communication not created: the alert provider could not be confirmed to be in the patient's organization")
+		}
+	}
+
+	// --- 5. Create FHIR Observation (with the Flag/Communication in message delivery mode) ---
+	var observation, flag, comm fhir.Created
+	obsOpts := fhir.ObservationOptions{
+		IfNoneExist:      h.Config.ObservationConditionalCreate,
+		Source:           source,
+		ClientResourceID: clientObsID,
+		Instrument:       inst.Name,
+		Method:           method,
+		EncounterID:      encID,
+		Unscored:         inst.Unscored,
+		HighRisk:         result.HighRisk,
+		Comments:         comments,
+		SubjectType:      subjectType,
 	}
+	messageMode := h.Config.DeliveryMode == config.DeliveryModeMessage
+	if messageMode {
+		delivered, err := fhir.ProcessMessage(fhirClient, h.Config, token, fhir.ScreeningMessage{
+			PatientID:         patientID,
+			EncounterID:       encID,
+			TotalScore:        totalScore,
+			Q10Score:          q10Score,
+			Observation:       obsOpts,
+			HighRisk:          result.HighRisk,
+			SkipFlag:          skipFlag,
+			NegativeScreen:    sendNegative,
+			Instrument:        inst.Name,
+			Locale:            locale,
+			FlagReason:        flagReason,
+			SkipCommunication: skipComm,
+		})
+		if err != nil {
+			log.Printf("ERROR: Failed to deliver FHIR message: %v", err)
+			h.forgetStalePatient(patientKey, err)
+			h.sendUpstreamError(w, err, "Failed to deliver FHIR message", http.StatusInternalServerError)
+			return
+		}
+		observation, flag, comm = delivered.Observation, delivered.Flag, delivered.Communication
+		// The score is recorded; a failed Flag/Communication message is handled like failed creates
+		if err := delivered.SideEffectsErr; err != nil {
+			if result.HighRisk {
+				if !skipFlag {
+					warnings = append(warnings, h.queueRetry(deadletter.KindFlag, patientID, encID, inst.Name, locale, flagReason, totalScore, q10Score, err))
+				}
+				if !skipComm {
+					warnings = append(warnings, h.queueRetry(deadletter.KindCommunication, patientID, "", inst.Name, locale, "", totalScore, q10Score, err))
+				}
+			} else {
+				warnings = append(warnings, fmt.Sprintf("communication creation failed: %v", err))
+			}
+		}
+	} else {
+		observation, err = fhir.CreateObservation(fhirClient, h.Config, token, patientID, totalScore, obsOpts)
+		if err != nil {
+			log.Printf("ERROR: Failed to create FHIR Observation: %v", err)
+			h.forgetStalePatient(patientKey, err)
+			h.sendUpstreamError(w, err, "Failed to create FHIR Observation", http.StatusInternalServerError)
+			return
+		}
+	}
+	observationId := observation.ID
 	log.Printf("Successfully created Observation ID: %s", observationId)
+	// A conditional create (or PUT) may return an earlier screen from today with another score
+	if recorded, ok := observation.ValueInteger(); ok && !inst.Unscored && recorded != totalScore {
+		log.Printf("WARN: Observation %s already existed with total %d; this screen's total %d was not recorded in it", observationId, recorded, totalScore)
+		warnings = append(warnings, fmt.Sprintf("observation %s already existed for today with total %d; this screen's total %d was not recorded in it", observationId, recorded, totalScore))
+	}
+	if !inst.Unscored {
+		epdsTotalScore.Observe(float64(totalScore))
+	}
+	if result.SelfHarm {
+		epdsQ10PositiveTotal.Inc()
+	}
+
+	// --- 5b. Attach uploaded file (multipart only), linked to the Observation ---
+	var doc fhir.Created
+	if isMultipart && !groupScreen {
+		if doc, err = h.attachUpload(r, fhirClient, token, patientID, observationId); err != nil {
+			log.Printf("ERROR: Failed to store uploaded attachment: %v", err)
+			warnings = append(warnings, fmt.Sprintf("attachment upload failed: %v", err))
+		} else if doc.ID != "" {
+			log.Printf("Successfully created DocumentReference ID: %s", doc.ID)
+		}
+	}
+
+	// --- 5c. Record the individual answers (always, for unscored instruments) ---
+	var questionnaire fhir.Created
+	if (h.Config.EnableQuestionnaireResponse || inst.Unscored) && !groupScreen {
+		var qrErr error
+		answers := epdsScores
+		if q10Missing {
+			answers = epdsScores[:rules.SelfHarmIndex()] // No item for the unanswered (last) question
+		}
+		questionnaire, qrErr = fhir.CreateQuestionnaireResponse(fhirClient, h.Config, token, patientID, inst.Name, answers)
+		if qrErr != nil {
+			log.Printf("ERROR: Failed to create FHIR QuestionnaireResponse: %v", qrErr)
+			warnings = append(warnings, fmt.Sprintf("questionnaire response creation failed: %v", qrErr))
+		} else {
+			log.Printf("Successfully created QuestionnaireResponse ID: %s", questionnaire.ID)
+		}
+	}
+
+	// --- 5d. Record who submitted the screen ---
+	var provenance fhir.Created
+	if h.Config.EnableProvenance && submittedBy != "" && !groupScreen {
+		var provErr error
+		provenance, provErr = fhir.CreateProvenance(fhirClient, h.Config, token, patientID, observationId, submittedBy)
+		if provErr != nil {
+			log.Printf("ERROR: Failed to create FHIR Provenance: %v", provErr)
+			warnings = append(warnings, fmt.Sprintf("provenance creation failed: %v", provErr))
+		} else {
+			log.Printf("Successfully created Provenance ID: %s", provenance.ID)
+		}
+	}
 
 	// --- 6. Create FHIR Flag & Communication if High Risk ---
-	isHighRisk := totalScore >= 13 || q10Score >= 1
-	if isHighRisk {
+	var task fhir.Created
+	if result.HighRisk && !groupScreen {
 		log.Printf("High risk detected for Patient %s (Score: %d, Q10: %d). Attempting to create Flag and Communication.", patientID, totalScore, q10Score)
-		
-		// Cascading encounter discovery
-		if encID == "" {
-			// Try appointment-based discovery first (if appointmentId provided)
-			if apptID != "" {
-				if found, err := fhir.FindEncounterByAppointment(fhirClient, h.Config, token, apptID); err == nil {
-					encID = found
-					log.Printf("Found encounter %s via appointment %s", encID, apptID)
+
+		if !messageMode {
+			// Create Flag (with Encounter link if we have it, patient-scoped if not)
+			if !skipFlag {
+				var flagErr error
+				flag, flagErr = fhir.CreateFlag(fhirClient, h.Config, token, patientID, encID, totalScore, q10Score, inst.Name, locale, flagReason)
+				if flagErr != nil {
+					// Log error but continue to attempt Communication creation
+					log.Printf("ERROR: Failed to create FHIR Flag: %v", flagErr)
+					warnings = append(warnings, h.queueRetry(deadletter.KindFlag, patientID, encID, inst.Name, locale, flagReason, totalScore, q10Score, flagErr))
 				} else {
-					log.Printf("WARN: appointment→encounter lookup failed for %s: %v", apptID, err)
+					log.Printf("Successfully created Flag ID: %s", flag.ID)
 				}
 			}
-			// Fall back to patient-based discovery
-			if encID == "" {
-				if found, err := fhir.FindActiveEncounterID(fhirClient, h.Config, token, patientID); err == nil {
-					encID = found
-					log.Printf("Found encounter %s via patient search", encID)
-				} else {
-					log.Printf("WARN: no active Encounter found for patient %s; creating patient-scoped Flag only (banner may not show). err=%v", patientID, err)
-				}
+
+			// Create Communication
+			var commErr error
+			if !skipComm {
+				comm, commErr = fhir.CreateCommunication(fhirClient, h.Config, token, patientID, h.Config.AlertProviderFHIRID, totalScore, q10Score, fhir.CommunicationOptions{Locale: locale, Instrument: inst.Name})
+			}
+			if commErr != nil {
+				// Log error, but response to client is already determined by Observation success
+				log.Printf("ERROR: Failed to create FHIR Communication: %v", commErr)
+				warnings = append(warnings, h.queueRetry(deadletter.KindCommunication, patientID, "", inst.Name, locale, "", totalScore, q10Score, commErr))
+			} else if comm.ID != "" {
+				log.Printf("Successfully created Communication ID: %s", comm.ID)
 			}
-		}
-		
-		// Create Flag (with Encounter link if we have it, patient-scoped if not)
-		flagId, flagErr := fhir.CreateFlag(fhirClient, h.Config, token, patientID, encID, totalScore, q10Score)
-		if flagErr != nil {
-			// Log error but continue to attempt Communication creation
-			log.Printf("ERROR: Failed to create FHIR Flag: %v", flagErr)
-		} else {
-			log.Printf("Successfully created Flag ID: %s", flagId)
 		}
 
-		// Create Communication
-		commId, commErr := fhir.CreateCommunication(fhirClient, h.Config, token, patientID, h.Config.AlertProviderFHIRID, totalScore, q10Score)
+		// Create follow-up Task with a due date based on what triggered the alert
+		if h.Config.EnableFollowUpTask {
+			dueHours := h.Config.FollowUpDueHoursElevatedTotal
+			if result.SelfHarm {
+				dueHours = h.Config.FollowUpDueHoursSelfHarm
+			}
+			due := h.Config.Now().Add(time.Duration(dueHours) * time.Hour)
+			var taskErr error
+			task, taskErr = fhir.CreateFollowUpTask(fhirClient, h.Config, token, patientID, encID, observationId, due, result.SelfHarm)
+			if taskErr != nil {
+				log.Printf("ERROR: Failed to create FHIR follow-up Task: %v", taskErr)
+				warnings = append(warnings, fmt.Sprintf("follow-up task creation failed: %v", taskErr))
+			} else {
+				log.Printf("Successfully created follow-up Task ID: %s (due in %dh)", task.ID, dueHours)
+			}
+		}
+	} else if sendNegative && !messageMode && !skipComm {
+		// Document that screening occurred and was negative (routine priority, no Flag)
+		var commErr error
+		comm, commErr = fhir.CreateCommunication(fhirClient, h.Config, token, patientID, h.Config.AlertProviderFHIRID, totalScore, q10Score, fhir.CommunicationOptions{NegativeScreen: true, Locale: locale, Instrument: inst.Name})
 		if commErr != nil {
-			// Log error, but response to client is already determined by Observation success
-			log.Printf("ERROR: Failed to create FHIR Communication: %v", commErr)
+			log.Printf("ERROR: Failed to create negative-screen FHIR Communication: %v", commErr)
+			warnings = append(warnings, fmt.Sprintf("communication creation failed: %v", commErr))
 		} else {
-			log.Printf("Successfully created Communication ID: %s", commId)
+			log.Printf("Successfully created negative-screen Communication ID: %s", comm.ID)
 		}
 	}
 
 	// --- 7. Return Success Response ---
 	// The primary outcome (Observation creation) was successful.
-	// Errors in Flag/Communication creation are logged but don't cause a client-facing error.
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, `{"status": "success", "observationId": "%s", "calculatedScore": %d}`, observationId, totalScore)
+	// Errors in Flag/Communication creation don't cause a client-facing error; they are
+	// logged and reported in the response's warnings.
+	// FHIR-native clients (Accept: application/fhir+json) receive a Bundle of the created resources.
+	receipt := receiptMessage(h.Config, receiptLocale, fhir.AlertMessageData{Instrument: inst.Display, SelfHarmItem: rules.SelfHarmIndex() + 1, TotalScore: totalScore})
+	res := submissionResult{
+		Response: SuccessResponseV2{
+			SuccessResponse: SuccessResponse{
+				Status:          "success",
+				ObservationID:   observationId,
+				CalculatedScore: totalScore,
+				Interpretation:  &Interpretation{Code: result.Band.Code, Label: result.Band.Label},
+				Receipt:         &Receipt{Language: receiptLocale, Message: receipt},
+				Warnings:        warnings,
+			},
+			HighRisk:                result.HighRisk,
+			EncounterID:             encID,
+			FlagID:                  flag.ID,
+			CommunicationID:         comm.ID,
+			QuestionnaireResponseID: questionnaire.ID,
+			ProvenanceID:            provenance.ID,
+		},
+		Resources: []fhir.Created{observation, questionnaire, flag, comm, provenance},
+	}
+	if inst.Unscored {
+		res.Response.Interpretation = nil
+		res.Response.Unscored = true
+		res.Response.Warnings = append(res.Response.Warnings, fmt.Sprintf("no scoring rules for instrument %q; answers recorded with a preliminary Observation and no score", inst.Name))
+	}
+	writeSubmissionResult(w, r, res, apiVersion)
+	if h.Dedup != nil {
+		h.Dedup.Put(dedupKey, res)
+	}
 	log.Printf("Successfully processed EPDS submission for Patient %s. Observation ID: %s", patientID, observationId)
+
+	// --- 8. Record audit entry (asynchronously, never blocks the client) ---
+	rec := audit.Record{
+		Event:       audit.EventSubmission,
+		PatientHash: audit.HashPatientID(subjectKey(subjectType, patientID)),
+		TotalScore:  totalScore,
+		Q10Score:    q10Score,
+		HighRisk:    result.HighRisk,
+		SubmittedBy: submittedBy,
+	}
+	rec.Add("Patient", provisional.ID)
+	rec.Add("Encounter", encounter.ID)
+	rec.Add("Observation", observationId)
+	rec.Add("DocumentReference", doc.ID)
+	rec.Add("QuestionnaireResponse", questionnaire.ID)
+	rec.Add("Provenance", provenance.ID)
+	rec.Add("Flag", flag.ID)
+	rec.Add("Communication", comm.ID)
+	rec.Add("Task", task.ID)
+	h.writeAudit(rec)
+}
+
+// writeAudit stamps rec and writes it to the audit sink, if one is configured, without
+// blocking the caller.
+func (h *ApiHandler) writeAudit(rec audit.Record) {
+	if h.Audit == nil {
+		return
+	}
+	rec.Timestamp = h.Config.Now().UTC()
+	go func() {
+		if err := h.Audit.Write(rec); err != nil {
+			log.Printf("ERROR: Failed to write %s audit record: %v", rec.Event, err)
+		}
+	}()
+}
+
+// attachUpload stores the optional "attachment" file of a multipart submission as a
+// DocumentReference linked to the Observation. It returns an empty Created if no file was sent.
+func (h *ApiHandler) attachUpload(r *http.Request, fhirClient *http.Client, token, patientID, observationID string) (fhir.Created, error) {
+	file, header, err := r.FormFile("attachment")
+	if errors.Is(err, http.ErrMissingFile) {
+		return fhir.Created{}, nil
+	}
+	if err != nil {
+		return fhir.Created{}, fmt.Errorf("failed to read attachment: %w", err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return fhir.Created{}, fmt.Errorf("failed to read attachment: %w", err)
+	}
+	contentType := header.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	return fhir.CreateDocumentReference(fhirClient, h.Config, token, patientID, observationID, header.Filename, contentType, data)
+}
+
+// forgetStalePatient drops the cached resolution under key when err is a create
+// rejected with 404 or 410, which suggests the patient was merged or retired, so the
+// identifier is searched afresh next time. key is empty when nothing was resolved.
+func (h *ApiHandler) forgetStalePatient(key string, err error) {
+	var createErr *fhir.CreateError
+	if h.PatientIDs == nil || key == "" || !errors.As(err, &createErr) {
+		return
+	}
+	if createErr.StatusCode == http.StatusNotFound || createErr.StatusCode == http.StatusGone {
+		h.PatientIDs.Delete(key)
+		log.Printf("Dropped cached patient resolution after %s create returned %d", createErr.ResourceType, createErr.StatusCode)
+	}
+}
+
+// communicationDebounced reports whether a Communication of the same kind (alert or
+// negative screen) was sent for the patient within COMMUNICATION_DEBOUNCE_SECONDS, so a
+// repeat screen should not notify the provider again. A failed search sends it anyway.
+func (h *ApiHandler) communicationDebounced(fhirClient *http.Client, token, patientID string, negativeScreen bool) bool {
+	if h.Config.AlertDebounce <= 0 {
+		return false
+	}
+	recent, err := fhir.HasRecentCommunication(fhirClient, h.Config, token, patientID, negativeScreen, h.Config.Now().Add(-h.Config.AlertDebounce))
+	if err != nil {
+		log.Printf("WARN: Communication debounce search failed for patient %s, sending anyway: %v", patientID, err)
+		return false
+	}
+	if recent {
+		log.Printf("Suppressed Communication for patient %s: one was sent within %s", patientID, h.Config.AlertDebounce)
+	}
+	return recent
+}
+
+// resolveEncounter returns the visit's Encounter for the Observation and any high-risk
+// Flag: encID when given and it is the patient's, otherwise one found via the
+// appointment or the patient's active encounters, otherwise (for high-risk screens, with
+// AUTO_CREATE_ENCOUNTER) a new one, which is also returned as created. It returns ""
+// when there is none (see NO_ENCOUNTER_BEHAVIOR), plus any warnings to report, including
+// a rejected encID. The error is set only when encID disagrees with apptID's Encounter
+// and ENCOUNTER_MISMATCH_BEHAVIOR=reject.
+func (h *ApiHandler) resolveEncounter(fhirClient *http.Client, token, patientID, encID, apptID string, highRisk bool) (string, fhir.Created, []string, error) {
+	var warnings []string
+	var created fhir.Created
+	// A client-supplied encounter must exist and belong to this patient, or the
+	// Observation and Flag would land on someone else's visit
+	if encID != "" {
+		if err := fhir.VerifyEncounterSubject(fhirClient, h.Config, token, encID, patientID); err != nil {
+			log.Printf("WARN: rejecting encounterId %s for patient %s; discovering the encounter instead: %v", encID, patientID, err)
+			warnings = append(warnings, fmt.Sprintf("encounterId %s rejected: %v", encID, err))
+			encID = ""
+		}
+	}
+	// With both IDs, the appointment's Encounter should be the given one; a mismatch is
+	// usually a client attaching the screen to the wrong visit (ENCOUNTER_MISMATCH_BEHAVIOR)
+	if encID != "" && apptID != "" {
+		apptEnc, err := fhir.FindEncounterByAppointment(fhirClient, h.Config, token, apptID)
+		switch {
+		case err != nil:
+			log.Printf("WARN: could not check encounterId %s against appointment %s: %v", encID, apptID, err)
+		case apptEnc != encID && h.Config.EncounterMismatch == config.EncounterMismatchReject:
+			log.Printf("ERROR: encounterId %s does not match appointment %s (Encounter %s); rejecting (ENCOUNTER_MISMATCH_BEHAVIOR=reject)", encID, apptID, apptEnc)
+			return "", created, warnings, fmt.Errorf("encounterId %s is not the Encounter of appointmentId %s (%s)", encID, apptID, apptEnc)
+		case apptEnc != encID:
+			log.Printf("WARN: encounterId %s does not match appointment %s (Encounter %s); keeping encounterId", encID, apptID, apptEnc)
+			warnings = append(warnings, fmt.Sprintf("encounterId %s is not the Encounter of appointmentId %s (%s); the screen was linked to %s", encID, apptID, apptEnc, encID))
+		}
+	}
+	if encID == "" {
+		// Try appointment-based discovery first (if appointmentId provided)
+		if apptID != "" {
+			if found, err := fhir.FindEncounterByAppointment(fhirClient, h.Config, token, apptID); err == nil {
+				encID = found
+				log.Printf("Found encounter %s via appointment %s", encID, apptID)
+			} else {
+				log.Printf("WARN: appointment→encounter lookup failed for %s: %v", apptID, err)
+			}
+		}
+		// Fall back to patient-based discovery
+		if encID == "" {
+			if found, err := fhir.FindActiveEncounterID(fhirClient, h.Config, token, patientID); err == nil {
+				encID = found
+				log.Printf("Found encounter %s via patient search", encID)
+			} else if h.Config.AutoCreateEncounter && highRisk {
+				log.Printf("No active Encounter found for patient %s (err=%v); auto-creating one", patientID, err)
+			} else if highRisk {
+				log.Printf("WARN: no active Encounter found for patient %s; creating patient-scoped Flag only (banner may not show). err=%v", patientID, err)
+			} else {
+				log.Printf("No active Encounter found for patient %s; the Observation is not linked to a visit. err=%v", patientID, err)
+			}
+		}
+		// Last resort: create a minimal Encounter so the Flag shows in the chart banner
+		if encID == "" && h.Config.AutoCreateEncounter && highRisk {
+			var err error
+			if created, err = fhir.CreateEncounter(fhirClient, h.Config, token, patientID); err == nil {
+				encID = created.ID
+				log.Printf("Auto-created encounter %s for patient %s", encID, patientID)
+			} else {
+				log.Printf("WARN: failed to auto-create Encounter for patient %s; creating patient-scoped Flag only (banner may not show). err=%v", patientID, err)
+				warnings = append(warnings, fmt.Sprintf("encounter auto-creation failed: %v", err))
+			}
+		}
+	}
+	return encID, created, warnings, nil
+}
+
+// queueRetry persists a failed high-risk side-effect to the deadletter queue (when
+// configured) and returns the warning to report to the client. A self-harm Communication
+// that cannot be queued is escalated at once, since nothing will retry it.
+func (h *ApiHandler) queueRetry(kind, patientID, encounterID, instrument, locale, flagReason string, totalScore, q10Score int, cause error) string {
+	warning := fmt.Sprintf("%s creation failed: %v", kind, cause)
+	now := h.Config.Now()
+	entry := deadletter.Entry{
+		ID:          deadletter.NewID(),
+		Kind:        kind,
+		Target:      h.Config.TargetName,
+		PatientID:   patientID,
+		EncounterID: encounterID,
+		Instrument:  instrument,
+		Locale:      locale,
+		FlagReason:  flagReason,
+		TotalScore:  totalScore,
+		Q10Score:    q10Score,
+		CreatedAt:   now,
+		NextAttempt: now.Add(h.Config.DeadLetterRetryInterval),
+		LastError:   cause.Error(),
+	}
+	if h.DeadLetter == nil {
+		go h.escalateIfSelfHarm(entry)
+		return warning
+	}
+	if err := h.DeadLetter.Enqueue(entry); err != nil {
+		log.Printf("ERROR: *** Failed to queue high-risk %s for patient %s; the alert is lost: %v ***", kind, patientID, err)
+		go h.escalateIfSelfHarm(entry)
+		return warning
+	}
+	log.Printf("Queued failed %s for patient %s as deadletter entry %s", kind, patientID, entry.ID)
+	return warning + " (queued for retry)"
+}
+
+// retryDeadLetter recreates a queued Flag or Communication against the target it was meant for.
+func (h *ApiHandler) retryDeadLetter(e deadletter.Entry) error {
+	target, ok := h.Targets[e.Target]
+	if !ok {
+		return fmt.Errorf("unknown FHIR target %q", e.Target)
+	}
+	token, err := target.Authenticator.GetAuthToken()
+	if err != nil {
+		return fmt.Errorf("failed to get Oystehr token: %w", err)
+	}
+
+	client := transport.WithTokenRefresh(h.HTTPClient, target.Authenticator)
+	var created fhir.Created
+	var resourceType string
+	switch e.Kind {
+	case deadletter.KindFlag:
+		resourceType = "Flag"
+		created, err = fhir.CreateFlag(client, target.Config, token, e.PatientID, e.EncounterID, e.TotalScore, e.Q10Score, e.Instrument, e.Locale, e.FlagReason)
+	case deadletter.KindCommunication:
+		resourceType = "Communication"
+		created, err = fhir.CreateCommunication(client, target.Config, token, e.PatientID, target.Config.AlertProviderFHIRID, e.TotalScore, e.Q10Score, fhir.CommunicationOptions{Locale: e.Locale, Instrument: e.Instrument})
+	default:
+		err = fmt.Errorf("unknown deadletter kind %q", e.Kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Queued alerts are always high risk
+	rec := audit.Record{
+		Event:       audit.EventDeadLetterRetry,
+		PatientHash: audit.HashPatientID(e.PatientID),
+		TotalScore:  e.TotalScore,
+		Q10Score:    e.Q10Score,
+		HighRisk:    true,
+	}
+	rec.Add(resourceType, created.ID)
+	h.writeAudit(rec)
+	return nil
 }
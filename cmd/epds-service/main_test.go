@@ -0,0 +1,455 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/epds-service/internal/auth"
+	"example.com/epds-service/internal/config"
+	"example.com/epds-service/internal/dedup"
+	"example.com/epds-service/internal/scoring"
+)
+
+// fhirRequest is one request received by fakeFHIR.
+type fhirRequest struct {
+	Method string
+	Path   string
+	Body   map[string]any
+}
+
+// fakeFHIR is a FHIR server that answers every create with a new ID (status createStatus,
+// default 201), every search with an empty Bundle, and records what it receives. handle,
+// when set, may answer a request first by returning true.
+type fakeFHIR struct {
+	*httptest.Server
+	createStatus int
+	handle       func(w http.ResponseWriter, r *http.Request, body map[string]any) bool
+
+	mu       sync.Mutex
+	requests []fhirRequest
+}
+
+func newFakeFHIR(t *testing.T) *fakeFHIR {
+	t.Helper()
+	f := &fakeFHIR{createStatus: http.StatusCreated}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		f.mu.Lock()
+		f.requests = append(f.requests, fhirRequest{Method: r.Method, Path: r.URL.Path, Body: body})
+		n := len(f.requests)
+		f.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/fhir+json")
+		if f.handle != nil && f.handle(w, r, body) {
+			return
+		}
+		resourceType, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		switch r.Method {
+		case http.MethodPost, http.MethodPut:
+			w.WriteHeader(f.createStatus)
+			fmt.Fprintf(w, `{"resourceType":%q,"id":"%s-%d"}`, resourceType, strings.ToLower(resourceType), n)
+		default:
+			w.Write([]byte(`{"resourceType":"Bundle","type":"searchset","entry":[]}`))
+		}
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// created returns the bodies of the resourceType creates received, in order.
+func (f *fakeFHIR) created(resourceType string) []map[string]any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var bodies []map[string]any
+	for _, req := range f.requests {
+		if (req.Method == http.MethodPost || req.Method == http.MethodPut) && strings.HasPrefix(req.Path, "/"+resourceType) {
+			bodies = append(bodies, req.Body)
+		}
+	}
+	return bodies
+}
+
+// testNow is the fixed clock of handler tests.
+var testNow = time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+
+// newTestHandler loads the configuration from the required settings plus env, pointed at
+// fhirServer, with a fixed clock and a static token.
+func newTestHandler(t *testing.T, fhirServer *fakeFHIR, env map[string]string) *ApiHandler {
+	t.Helper()
+	settings := map[string]string{
+		"OYSTEHR_FHIR_BASE_URL":     fhirServer.URL,
+		"OYSTEHR_AUTH_URL":          fhirServer.URL + "/token",
+		"OYSTEHR_PROJECT_ID":        "project",
+		"OYSTEHR_M2M_CLIENT_ID":     "client",
+		"OYSTEHR_M2M_CLIENT_SECRET": "secret",
+		"ALERT_PROVIDER_FHIR_ID":    "Practitioner/provider-1",
+	}
+	for name, value := range env {
+		settings[name] = value
+	}
+	for name, value := range settings {
+		t.Setenv(name, value)
+	}
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	cfg.Clock = func() time.Time { return testNow }
+	return &ApiHandler{Config: cfg, Authenticator: &auth.StaticTokenProvider{Token: "token"}, HTTPClient: fhirServer.Client()}
+}
+
+// submitForm posts a form submission for patient p1 with the given answers (q1 first).
+func submitForm(h *ApiHandler, answers []int) *httptest.ResponseRecorder {
+	form := url.Values{"patientId": {"p1"}}
+	for i, answer := range answers {
+		form.Set(fmt.Sprintf("q%d", i+1), fmt.Sprint(answer))
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/submit-epds", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	h.handleSubmitEPDS(rec, req)
+	return rec
+}
+
+func TestSubmitSelfHarmBelowThresholdAlerts(t *testing.T) {
+	tests := []struct {
+		name         string
+		answers      []int
+		wantHighRisk bool
+	}{
+		{"total 8 with q10=2", []int{1, 1, 1, 1, 1, 1, 0, 0, 0, 2}, true},
+		{"total 8 with q10=1", []int{1, 1, 1, 1, 1, 1, 1, 0, 0, 1}, true},
+		{"total 8 with q10=0", []int{1, 1, 1, 1, 1, 1, 1, 1, 0, 0}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fhirServer := newFakeFHIR(t)
+			h := newTestHandler(t, fhirServer, nil)
+
+			rec := submitForm(h, tt.answers)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+			}
+			if got := len(fhirServer.created("Observation")); got != 1 {
+				t.Errorf("Observations created = %d, want 1", got)
+			}
+			flags, comms := fhirServer.created("Flag"), fhirServer.created("Communication")
+			if !tt.wantHighRisk {
+				if len(flags) != 0 || len(comms) != 0 {
+					t.Errorf("created %d Flags and %d Communications for a negative screen, want none", len(flags), len(comms))
+				}
+				return
+			}
+			if len(flags) != 1 || len(comms) != 1 {
+				t.Fatalf("created %d Flags and %d Communications, want 1 of each", len(flags), len(comms))
+			}
+			// A self-harm Flag is always the highest severity, whatever the total
+			flag, _ := json.Marshal(flags[0])
+			if !strings.Contains(string(flag), `"code":"high"`) || !strings.Contains(string(flag), fmt.Sprintf("Q10 Risk (%d)", tt.answers[9])) {
+				t.Errorf("Flag does not record the self-harm risk: %s", flag)
+			}
+			comm, _ := json.Marshal(comms[0])
+			if !strings.Contains(string(comm), fmt.Sprintf("Q10 Score: %d", tt.answers[9])) {
+				t.Errorf("Communication does not report the self-harm score: %s", comm)
+			}
+		})
+	}
+}
+
+func TestSubmitJSONScoresArray(t *testing.T) {
+	tests := []struct {
+		name        string
+		scores      string
+		wantStatus  int
+		wantMessage string // Substring of the error message or of one schema error
+	}{
+		{"10 answers", `[1,1,1,1,1,1,1,1,1,1]`, http.StatusOK, ""},
+		{"9 answers", `[1,1,1,1,1,1,1,1,1]`, http.StatusBadRequest, "must contain 10 answers for the EPDS, got 9"},
+		{"11 answers", `[1,1,1,1,1,1,1,1,1,1,1]`, http.StatusBadRequest, "must contain at most 10 items, got 11"},
+		{"fractional answer", `[1,1,1,1,1.5,1,1,1,1,1]`, http.StatusBadRequest, "scores[4]"},
+		{"string answer", `[1,1,1,1,"1",1,1,1,1,1]`, http.StatusBadRequest, "scores[4]"},
+		{"object answer", `[1,1,1,1,{"value":1},1,1,1,1,1]`, http.StatusBadRequest, "scores[4]"},
+		{"out of range", `[1,1,1,1,4,1,1,1,1,1]`, http.StatusBadRequest, "scores[4]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fhirServer := newFakeFHIR(t)
+			h := newTestHandler(t, fhirServer, nil)
+
+			body := `{"patientId":"p1","scores":` + tt.scores + `}`
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/submit-epds", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			h.handleSubmitEPDS(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantMessage) {
+				t.Errorf("body %s does not mention %q", rec.Body, tt.wantMessage)
+			}
+			if tt.wantStatus != http.StatusOK && len(fhirServer.created("Observation")) != 0 {
+				t.Errorf("created an Observation for a rejected submission")
+			}
+		})
+	}
+}
+
+// messageEntryTypes returns the resource types in a $process-message Bundle body.
+func messageEntryTypes(body map[string]any) []string {
+	var types []string
+	entries, _ := body["entry"].([]any)
+	for _, entry := range entries {
+		resource, _ := entry.(map[string]any)["resource"].(map[string]any)
+		resourceType, _ := resource["resourceType"].(string)
+		types = append(types, resourceType)
+	}
+	return types
+}
+
+func TestSubmitMessageModeKeepsObservationWhenSideEffectsFail(t *testing.T) {
+	tests := []struct {
+		name            string
+		sideEffectsCode int    // Status of the Flag/Communication message
+		wantWarning     string // "" when no creation may be reported as failed
+	}{
+		{"side effects delivered", http.StatusOK, ""},
+		{"side effects rejected", http.StatusUnprocessableEntity, "flag creation failed"},
+		{"side effects server error", http.StatusInternalServerError, "communication creation failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fhirServer := newFakeFHIR(t)
+			var messages [][]string
+			fhirServer.handle = func(w http.ResponseWriter, r *http.Request, body map[string]any) bool {
+				if r.URL.Path != "/$process-message" {
+					return false
+				}
+				types := messageEntryTypes(body)
+				messages = append(messages, types)
+				if !slices.Contains(types, "Observation") && tt.sideEffectsCode != http.StatusOK {
+					w.WriteHeader(tt.sideEffectsCode)
+					w.Write([]byte(`{"resourceType":"OperationOutcome","issue":[{"severity":"error","code":"invalid"}]}`))
+					return true
+				}
+				reply := []any{map[string]any{"resource": map[string]any{"resourceType": "MessageHeader", "response": map[string]any{"code": "ok"}}}}
+				for _, resourceType := range types[1:] {
+					reply = append(reply, map[string]any{"response": map[string]any{"location": resourceType + "/" + strings.ToLower(resourceType) + "-1/_history/1"}})
+				}
+				json.NewEncoder(w).Encode(map[string]any{"resourceType": "Bundle", "type": "message", "entry": reply})
+				return true
+			}
+			h := newTestHandler(t, fhirServer, map[string]string{"DELIVERY_MODE": "message"})
+
+			rec := submitForm(h, []int{3, 3, 3, 3, 1, 1, 0, 0, 0, 1})
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200 (the score was recorded); body %s", rec.Code, rec.Body)
+			}
+			var resp SuccessResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.ObservationID != "observation-1" {
+				t.Errorf("observationId = %q, want observation-1", resp.ObservationID)
+			}
+
+			// The Observation travels alone, before the Flag and Communication
+			want := [][]string{{"MessageHeader", "Observation"}, {"MessageHeader", "Flag", "Communication"}}
+			if len(messages) != len(want) || !slices.Equal(messages[0], want[0]) || !slices.Equal(messages[1], want[1]) {
+				t.Errorf("messages = %v, want %v", messages, want)
+			}
+			if tt.wantWarning == "" && strings.Contains(strings.Join(resp.Warnings, "; "), "creation failed") {
+				t.Errorf("warnings = %v, want no failed creation", resp.Warnings)
+			}
+			if tt.wantWarning != "" && !strings.Contains(strings.Join(resp.Warnings, "; "), tt.wantWarning) {
+				t.Errorf("warnings = %v, want one containing %q", resp.Warnings, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestSubmitWebhookCannotClearSelfHarm(t *testing.T) {
+	// Total 8 with Q10=2: high risk locally only through the self-harm item
+	answers := []int{1, 1, 1, 1, 1, 1, 0, 0, 0, 2}
+	tests := []struct {
+		name         string
+		webhook      string
+		override     string
+		wantHighRisk bool
+		wantTotal    int
+		wantWarning  string
+	}{
+		{"webhook clears Q10", `{"total":8,"highRisk":false}`, "", true, 8, ""},
+		{"webhook clears Q10 with override", `{"total":8,"highRisk":false}`, "true", false, 8, ""},
+		{"webhook agrees", `{"total":9,"highRisk":true}`, "", true, 9, ""},
+		{"total above the maximum", `{"total":31,"highRisk":false}`, "", true, 8, "invalid total"},
+		{"negative total", `{"total":-1,"highRisk":false}`, "true", true, 8, "invalid total"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.webhook))
+			}))
+			defer webhook.Close()
+			fhirServer := newFakeFHIR(t)
+			h := newTestHandler(t, fhirServer, map[string]string{"SCORING_WEBHOOK_OVERRIDES_SELF_HARM": tt.override})
+			h.Scorer = &scoring.Webhook{URL: webhook.URL, Client: webhook.Client()}
+
+			rec := submitForm(h, answers)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+			}
+			var resp SuccessResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.CalculatedScore != tt.wantTotal {
+				t.Errorf("calculatedScore = %d, want %d", resp.CalculatedScore, tt.wantTotal)
+			}
+			flags, comms := fhirServer.created("Flag"), fhirServer.created("Communication")
+			wantCreated := 0
+			if tt.wantHighRisk {
+				wantCreated = 1
+			}
+			if len(flags) != wantCreated || len(comms) != wantCreated {
+				t.Errorf("created %d Flags and %d Communications, want high risk %t", len(flags), len(comms), tt.wantHighRisk)
+			}
+			if tt.wantWarning != "" && !strings.Contains(strings.Join(resp.Warnings, "; "), tt.wantWarning) {
+				t.Errorf("warnings = %v, want one containing %q", resp.Warnings, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestRateLimitedOffByDefault(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		want429s bool
+	}{
+		{"unset", nil, false},
+		{"zero", map[string]string{"RATE_LIMIT_PER_MINUTE": "0", "RATE_LIMIT_BURST": "5"}, false},
+		{"enabled", map[string]string{"RATE_LIMIT_PER_MINUTE": "30", "RATE_LIMIT_BURST": "10"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, newFakeFHIR(t), tt.env)
+			handler := rateLimited(h.Config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			// Every request comes from the same address, as from behind a clinic NAT
+			limited := 0
+			for range 50 {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/submit-epds", nil))
+				if rec.Code == http.StatusTooManyRequests {
+					limited++
+				}
+			}
+			if (limited > 0) != tt.want429s {
+				t.Errorf("%d of 50 requests got 429, want 429s %t", limited, tt.want429s)
+			}
+		})
+	}
+}
+
+func TestSubmitForgetsStalePatientInBothDeliveryModes(t *testing.T) {
+	tests := []struct {
+		name         string
+		deliveryMode string
+		failPath     string // Request answered 404, as for a merged patient
+	}{
+		{"create mode", "create", "/Observation"},
+		{"message mode", "message", "/$process-message"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fhirServer := newFakeFHIR(t)
+			fhirServer.handle = func(w http.ResponseWriter, r *http.Request, body map[string]any) bool {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/Patient":
+					w.Write([]byte(`{"resourceType":"Bundle","type":"searchset","entry":[{"resource":{"resourceType":"Patient","id":"merged-1"}}]}`))
+					return true
+				case r.Method == http.MethodPost && r.URL.Path == tt.failPath:
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`{"resourceType":"OperationOutcome","issue":[{"severity":"error","code":"not-found"}]}`))
+					return true
+				}
+				return false
+			}
+			h := newTestHandler(t, fhirServer, map[string]string{"DELIVERY_MODE": tt.deliveryMode})
+			h.PatientIDs = dedup.New[string](time.Hour, h.Config.Now)
+
+			form := url.Values{"patientIdentifierSystem": {"urn:mrn"}, "patientIdentifierValue": {"123"}}
+			for i := range 10 {
+				form.Set(fmt.Sprintf("q%d", i+1), "0")
+			}
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/submit-epds", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			h.handleSubmitEPDS(rec, req)
+
+			if rec.Code == http.StatusOK {
+				t.Fatalf("status = 200 though the Observation was rejected; body %s", rec.Body)
+			}
+			key := dedup.Key(h.Config.TargetName, "urn:mrn", "123", h.Config.PatientIdentifierTypeSystem, h.Config.PatientIdentifierTypeCode)
+			if id, ok := h.PatientIDs.Get(key); ok {
+				t.Errorf("patient resolution %s still cached after a 404, want it dropped", id)
+			}
+		})
+	}
+}
+
+func TestSubmitSameDayRescreenWithDifferentScore(t *testing.T) {
+	// Total 14 with Q10=2; the morning's screen totalled existingTotal
+	answers := []int{3, 3, 3, 1, 1, 1, 0, 0, 0, 2}
+	tests := []struct {
+		name          string
+		existingTotal int
+		wantWarning   bool
+	}{
+		{"different score", 5, true},
+		{"same score", 14, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fhirServer := newFakeFHIR(t)
+			var ifNoneExist string
+			fhirServer.handle = func(w http.ResponseWriter, r *http.Request, body map[string]any) bool {
+				if r.Method != http.MethodPost || r.URL.Path != "/Observation" {
+					return false
+				}
+				// The conditional create matches the morning's Observation
+				ifNoneExist = r.Header.Get("If-None-Exist")
+				fmt.Fprintf(w, `{"resourceType":"Observation","id":"morning-1","valueInteger":%d}`, tt.existingTotal)
+				return true
+			}
+			h := newTestHandler(t, fhirServer, map[string]string{"OBSERVATION_CONDITIONAL_CREATE": "true"})
+
+			rec := submitForm(h, answers)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+			}
+			if ifNoneExist == "" {
+				t.Fatal("Observation was not created conditionally")
+			}
+			var resp SuccessResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.ObservationID != "morning-1" || resp.CalculatedScore != 14 {
+				t.Errorf("observationId = %q, calculatedScore = %d; want morning-1 and 14", resp.ObservationID, resp.CalculatedScore)
+			}
+			warned := strings.Contains(strings.Join(resp.Warnings, "; "), "already existed for today with total 5")
+			if warned != tt.wantWarning {
+				t.Errorf("warnings = %v, want the unrecorded score reported: %t", resp.Warnings, tt.wantWarning)
+			}
+			// The rescreen's self-harm answer still alerts
+			if len(fhirServer.created("Flag")) != 1 || len(fhirServer.created("Communication")) != 1 {
+				t.Errorf("created %d Flags and %d Communications, want 1 of each", len(fhirServer.created("Flag")), len(fhirServer.created("Communication")))
+			}
+		})
+	}
+}
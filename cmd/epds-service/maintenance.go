@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// maintenanceStatus is the body of /admin/maintenance requests and responses.
+type maintenanceStatus struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// maintenanceGate rejects submissions with 503 and Retry-After while maintenance mode is on.
+// It wraps the submit handler outside API-key auth and rate limiting, so clients buffering
+// through a maintenance window do not spend their rate budget on rejected tries.
+func (h *ApiHandler) maintenanceGate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.Maintenance.Load() {
+			w.Header().Set("Retry-After", strconv.Itoa(int(h.Config.MaintenanceRetryAfter.Seconds())))
+			sendJSONError(w, "Service is in maintenance mode; retry later", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleMaintenance reports (GET) or sets (POST {"enabled": bool}) maintenance mode. The
+// setting lasts until the next change or restart, which falls back to MAINTENANCE_MODE.
+func (h *ApiHandler) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req maintenanceStatus
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&req); err != nil || req.Enabled == nil {
+			sendJSONError(w, `Body must be {"enabled": true|false}`, http.StatusBadRequest)
+			return
+		}
+		if h.Maintenance.Swap(*req.Enabled) != *req.Enabled {
+			log.Printf("Maintenance mode %s by %s", map[bool]string{true: "enabled", false: "disabled"}[*req.Enabled], r.RemoteAddr)
+		}
+	default:
+		sendJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	enabled := h.Maintenance.Load()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(maintenanceStatus{Enabled: &enabled})
+}
@@ -0,0 +1,23 @@
+package main
+
+import "example.com/epds-service/internal/metrics"
+
+// Screening-result metrics for population-level monitoring without querying FHIR.
+// Score buckets follow the clinical bands: 0-9 (unlikely), 10-12 (possible), 13-30 (probable depression).
+var (
+	epdsTotalScore = metrics.NewHistogram(
+		"epds_total_score",
+		"Distribution of EPDS total scores for accepted submissions.",
+		[]float64{9, 12, 30},
+	)
+	epdsQ10PositiveTotal = metrics.NewCounter(
+		"epds_q10_positive_total",
+		"Number of accepted submissions with a positive Q10 (self-harm) response.",
+	)
+)
+
+// Client retries, to tell retry storms from genuine new submissions during incidents.
+var submissionRetriesTotal = metrics.NewCounter(
+	"epds_submission_retries_total",
+	"Submissions received with an X-Attempt-Number greater than 1 (client retries).",
+)
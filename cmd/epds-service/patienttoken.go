@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"example.com/epds-service/internal/auth"
+	"example.com/epds-service/internal/config"
+	"example.com/epds-service/internal/fhir"
+)
+
+// PatientTokenHeader carries the patient portal's session JWT. When verified with
+// PATIENT_TOKEN_SECRET, its PATIENT_TOKEN_CLAIM claim is the screen's subject.
+const PatientTokenHeader = "X-Patient-Token"
+
+// patientFromToken verifies a patient-context token and returns the Patient ID from its
+// claim, which may be a bare ID or a Patient/{id} reference.
+func patientFromToken(cfg *config.Config, token string) (string, error) {
+	claims, err := auth.VerifyHS256(strings.TrimPrefix(token, "Bearer "), cfg.PatientTokenSecret, cfg.Now())
+	if err != nil {
+		return "", err
+	}
+	value, _ := claims[cfg.PatientTokenClaim].(string)
+	if value == "" {
+		return "", fmt.Errorf("token has no %q claim", cfg.PatientTokenClaim)
+	}
+	id := strings.TrimPrefix(value, "Patient/")
+	if !fhir.ValidID(id) {
+		return "", fmt.Errorf("%q claim %q is not a Patient ID or Patient/{id} reference", cfg.PatientTokenClaim, value)
+	}
+	return id, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"example.com/epds-service/internal/config"
+	"example.com/epds-service/internal/scoring"
+)
+
+// maxPreviewBytes bounds preview bodies, which never carry attachments.
+const maxPreviewBytes = 64 << 10
+
+// PreviewResponse is the body of POST /api/v1/epds/preview.
+type PreviewResponse struct {
+	Instrument       string          `json:"instrument"`
+	Total            int             `json:"total"`            // Sum of the answers given so far
+	Answered         int             `json:"answered"`         // Number of questions answered
+	Missing          []string        `json:"missing"`          // Questions still needed, e.g. ["q9", "q10"]
+	Complete         bool            `json:"complete"`         // Every question is answered
+	SelfHarmItem     string          `json:"selfHarmItem"`     // q10 on the EPDS, q9 on the PHQ-9
+	SelfHarmScore    *int            `json:"selfHarmScore"`    // null until the self-harm item is answered
+	SelfHarmPositive bool            `json:"selfHarmPositive"` // The self-harm answer meets its threshold
+	HighRisk         bool            `json:"highRisk"`         // The answers so far already make the screen high risk
+	Interpretation   *Interpretation `json:"interpretation"`   // Band of the total so far
+}
+
+// handlePreview scores a possibly partial set of answers for a live form, with no FHIR
+// calls and no Oystehr token. It accepts the submission's instrument, q1..qN and (JSON
+// only) scores fields; unanswered questions are listed in missing rather than rejected.
+// Answers only add to the total, so highRisk never reverts as more questions are answered.
+func (h *ApiHandler) handlePreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPreviewBytes)
+	var err error
+	if isJSONBody(r) {
+		err = parsePreviewJSON(r)
+	} else {
+		err = r.ParseForm()
+	}
+	if err != nil {
+		sendJSONError(w, "Invalid input: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if dups := duplicateQFields(r); len(dups) > 0 {
+		sendJSONError(w, "Invalid input: each question may be answered once; repeated "+strings.Join(dups, ", "), http.StatusBadRequest)
+		return
+	}
+
+	inst, ok := h.Config.Instrument(strings.TrimSpace(r.FormValue("instrument")))
+	if !ok {
+		sendJSONError(w, fmt.Sprintf("Invalid input: instrument must be one of %s", strings.Join(config.InstrumentNames, ", ")), http.StatusBadRequest)
+		return
+	}
+	rules := inst.Rules
+
+	resp := PreviewResponse{
+		Instrument:   inst.Name,
+		Missing:      []string{},
+		SelfHarmItem: fmt.Sprintf("q%d", rules.SelfHarmIndex()+1),
+	}
+	for i := 1; i <= scoring.NumQuestions; i++ {
+		qKey := fmt.Sprintf("q%d", i)
+		value := strings.TrimSpace(r.FormValue(qKey))
+		if i > rules.NumItems() {
+			if value != "" {
+				sendJSONError(w, fmt.Sprintf("Invalid input: the %s has no %s", inst.Display, qKey), http.StatusBadRequest)
+				return
+			}
+			continue
+		}
+		if value == "" {
+			resp.Missing = append(resp.Missing, qKey)
+			continue
+		}
+		answer, err := strconv.Atoi(value)
+		if err != nil || answer < rules.MinAnswer || answer > rules.MaxAnswer {
+			sendJSONError(w, fmt.Sprintf("Invalid input: %s must be an integer between %d and %d", qKey, rules.MinAnswer, rules.MaxAnswer), http.StatusBadRequest)
+			return
+		}
+		resp.Total += answer
+		resp.Answered++
+		if i == rules.SelfHarmIndex()+1 {
+			resp.SelfHarmScore = &answer
+			resp.SelfHarmPositive = answer >= rules.Q10Threshold
+		}
+	}
+
+	resp.Complete = len(resp.Missing) == 0
+	resp.HighRisk = rules.TotalHighRisk(resp.Total) || resp.SelfHarmPositive
+	band := rules.Interpret(resp.Total)
+	resp.Interpretation = &Interpretation{Code: band.Code, Label: band.Label}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// parsePreviewJSON copies a JSON preview body into r.Form. Answers may be given as
+// q1..qN or as a scores array; null fields and null scores entries are unanswered.
+func parsePreviewJSON(r *http.Request) error {
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	var body map[string]any
+	if err := dec.Decode(&body); err != nil {
+		return fmt.Errorf("invalid JSON body: %w", err)
+	}
+
+	form := url.Values{}
+	for key, value := range body {
+		switch v := value.(type) {
+		case string:
+			form.Set(key, v)
+		case json.Number:
+			form.Set(key, v.String())
+		case []any:
+			if key != "scores" {
+				continue
+			}
+			if len(v) > scoring.NumQuestions {
+				return fmt.Errorf("scores has more than %d answers, got %d", scoring.NumQuestions, len(v))
+			}
+			for i, item := range v {
+				if item == nil {
+					continue
+				}
+				n, ok := item.(json.Number)
+				if !ok {
+					return fmt.Errorf("scores[%d] must be an integer or null", i)
+				}
+				qKey := fmt.Sprintf("q%d", i+1)
+				if _, dup := body[qKey]; dup {
+					return fmt.Errorf("provide either scores or %s, not both", qKey)
+				}
+				form.Set(qKey, n.String())
+			}
+		}
+	}
+
+	r.Form = form
+	r.PostForm = form
+	return nil
+}
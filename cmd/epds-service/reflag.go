@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"example.com/epds-service/internal/audit"
+	"example.com/epds-service/internal/auth"
+	"example.com/epds-service/internal/config"
+	"example.com/epds-service/internal/fhir"
+	"example.com/epds-service/internal/scoring"
+	"example.com/epds-service/internal/transport"
+)
+
+// runReflagMode backfills missing high-risk alerts: it finds the total-score Observations
+// of every instrument effective from one date through another (YYYY-MM-DD, inclusive), and
+// for each high-risk screen without an active Flag from this service (for the same Encounter,
+// when the Observation has one) creates the Flag and the provider alert Communication.
+// Re-running over the same range only creates what is still missing.
+//
+// The Observation records only the total. When QuestionnaireResponses are enabled the
+// same day's answers supply the self-harm item, so screens high risk on that item alone
+// are found too; otherwise only the total is judged and the item is reported as unanswered.
+// With dryRun nothing is created. Each backfill is recorded in AUDIT_LOG_PATH, when set.
+// It uses the default FHIR target and returns the process exit code.
+func runReflagMode(from, to string, dryRun bool, out, errOut io.Writer) int {
+	for _, date := range []string{from, to} {
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			fmt.Fprintf(errOut, "error: -from and -to must be dates (YYYY-MM-DD), got %q\n", date)
+			return 1
+		}
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(errOut, "error: %v\n", err)
+		return 1
+	}
+	target := cfg.Targets[cfg.TargetNames[0]]
+	httpClient, err := transport.NewHTTPClient(target)
+	if err != nil {
+		fmt.Fprintf(errOut, "error: %v\n", err)
+		return 1
+	}
+	authenticator := auth.NewAuthenticator(target, httpClient)
+	token, err := authenticator.GetAuthToken()
+	if err != nil {
+		fmt.Fprintf(errOut, "error: %v\n", err)
+		return 1
+	}
+	client := transport.WithTokenRefresh(httpClient, authenticator)
+	var auditWriter *audit.FileWriter
+	if cfg.AuditLogPath != "" && !dryRun {
+		if auditWriter, err = audit.NewFileWriter(cfg.AuditLogPath); err != nil {
+			fmt.Fprintf(errOut, "error: %v\n", err)
+			return 1
+		}
+		defer auditWriter.Close()
+	}
+	// record audits the Flag and any Communication backfilled for obs
+	record := func(obs fhir.ScoreObservation, q10 int, flag, comm fhir.Created) {
+		if auditWriter == nil {
+			return
+		}
+		rec := audit.Record{
+			Timestamp:   cfg.Now().UTC(),
+			Event:       audit.EventReflag,
+			PatientHash: audit.HashPatientID(obs.PatientID),
+			TotalScore:  obs.Total,
+			Q10Score:    q10,
+			HighRisk:    true,
+		}
+		rec.Add("Flag", flag.ID)
+		rec.Add("Communication", comm.ID)
+		if err := auditWriter.Write(rec); err != nil {
+			fmt.Fprintf(errOut, "Observation/%s: failed to write audit record: %v\n", obs.ID, err)
+		}
+	}
+
+	var checked, notHighRisk, skipped, created, wouldCreate, failed int
+	for _, name := range config.InstrumentNames {
+		inst, _ := target.Instrument(name)
+		observations, err := fhir.FindScoreObservations(client, target, token, inst.TotalCode, from, to)
+		if err != nil {
+			fmt.Fprintf(errOut, "error: %s Observation search failed: %v\n", inst.Display, err)
+			return 1
+		}
+
+		for _, obs := range observations {
+			checked++
+			q10, highRisk := reflagRisk(client, target, token, inst, obs)
+			if !highRisk {
+				notHighRisk++
+				continue
+			}
+			exists, err := fhir.HasActiveHighRiskFlag(client, target, token, obs.PatientID, obs.EncounterID)
+			if err != nil {
+				failed++
+				fmt.Fprintf(errOut, "Observation/%s: Flag search failed: %v\n", obs.ID, err)
+				continue
+			}
+			if exists {
+				skipped++
+				continue
+			}
+			if dryRun {
+				wouldCreate++
+				fmt.Fprintf(out, "would create Flag and Communication for Observation/%s (Patient/%s, %s total %d)\n", obs.ID, obs.PatientID, inst.Display, obs.Total)
+				continue
+			}
+
+			flag, err := fhir.CreateFlag(client, target, token, obs.PatientID, obs.EncounterID, obs.Total, q10, name, "", "")
+			if err != nil {
+				failed++
+				fmt.Fprintf(errOut, "Observation/%s: Flag creation failed: %v\n", obs.ID, err)
+				continue
+			}
+			if target.EnforceSameOrg {
+				if err := fhir.CheckSameOrganization(client, target, token, obs.PatientID); err != nil {
+					failed++
+					record(obs, q10, flag, fhir.Created{})
+					fmt.Fprintf(errOut, "Observation/%s: created Flag/%s but refused the Communication (ENFORCE_SAME_ORG): %v\n", obs.ID, flag.ID, err)
+					continue
+				}
+			}
+			comm, err := fhir.CreateCommunication(client, target, token, obs.PatientID, target.AlertProviderFHIRID, obs.Total, q10, fhir.CommunicationOptions{Instrument: name})
+			record(obs, q10, flag, comm)
+			if err != nil {
+				// The Flag now exists, so a re-run would skip this screen; the alert must be sent by hand
+				failed++
+				fmt.Fprintf(errOut, "Observation/%s: created Flag/%s but Communication failed, notify the provider manually: %v\n", obs.ID, flag.ID, err)
+				continue
+			}
+			created++
+			fmt.Fprintf(out, "created Flag/%s and Communication/%s for Observation/%s (Patient/%s)\n", flag.ID, comm.ID, obs.ID, obs.PatientID)
+		}
+	}
+
+	if dryRun {
+		fmt.Fprintf(out, "dry run: checked=%d wouldCreate=%d skipped=%d notHighRisk=%d failed=%d\n", checked, wouldCreate, skipped, notHighRisk, failed)
+	} else {
+		fmt.Fprintf(out, "checked=%d created=%d skipped=%d notHighRisk=%d failed=%d\n", checked, created, skipped, notHighRisk, failed)
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// reflagRisk returns the self-harm item score and whether obs is a high-risk screen,
+// scoring the same day's QuestionnaireResponse when one matches the recorded total.
+func reflagRisk(client *http.Client, cfg *config.Config, token string, inst config.Instrument, obs fhir.ScoreObservation) (int, bool) {
+	if cfg.EnableQuestionnaireResponse && len(obs.Effective) >= len(time.DateOnly) {
+		answers, err := fhir.FindQuestionnaireAnswers(client, cfg, token, obs.PatientID, obs.Effective[:len(time.DateOnly)])
+		if err != nil {
+			log.Printf("Warning: answers for Observation/%s unavailable, judging the total only: %v", obs.ID, err)
+		} else if len(answers) == inst.Rules.NumItems() {
+			if result := scoring.Score(answers, inst.Rules); result.Total == obs.Total {
+				return result.Q10, result.HighRisk
+			}
+		}
+	}
+	return scoring.Q10Unanswered, inst.Rules.TotalHighRisk(obs.Total)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"example.com/epds-service/internal/config"
+	"example.com/epds-service/internal/fhir"
+	"example.com/epds-service/internal/schema"
+)
+
+// submissionSchema returns the JSON Schema for JSON submission bodies. It is built from the
+// configuration so answer ranges and allowed sources always match what the handler enforces.
+// Answer counts and ranges span every instrument; rules that depend on the chosen instrument
+// or span fields (patientId or identifier or groupId; scores or q1..qN) are checked by the handler.
+// With RECORD_UNSCORED_INSTRUMENTS any instrument name and non-negative answers are allowed.
+func submissionSchema(cfg *config.Config) *schema.Schema {
+	epds, _ := cfg.Instrument(config.InstrumentEPDS)
+	minAnswer, maxAnswer := epds.Rules.MinAnswer, epds.Rules.MaxAnswer
+	minItems, maxItems := epds.Rules.NumItems(), epds.Rules.NumItems()
+	for _, name := range config.InstrumentNames {
+		inst, _ := cfg.Instrument(name)
+		minAnswer, maxAnswer = min(minAnswer, inst.Rules.MinAnswer), max(maxAnswer, inst.Rules.MaxAnswer)
+		minItems, maxItems = min(minItems, inst.Rules.NumItems()), max(maxItems, inst.Rules.NumItems())
+	}
+	answer := func(desc string) *schema.Schema {
+		if cfg.RecordUnscoredInstruments {
+			return &schema.Schema{Type: "integer", Description: desc, Minimum: schema.Int(min(minAnswer, 0))}
+		}
+		return &schema.Schema{Type: "integer", Description: desc, Minimum: schema.Int(minAnswer), Maximum: schema.Int(maxAnswer)}
+	}
+	text := func(desc string) *schema.Schema {
+		return &schema.Schema{Type: "string", Description: desc, MinLength: schema.Int(1)}
+	}
+
+	props := map[string]*schema.Schema{
+		"patientId":               {Type: "string", Description: "FHIR Patient id", Pattern: fhir.IDPattern},
+		"patientIdentifierSystem": text("Patient identifier system (with patientIdentifierValue)"),
+		"patientIdentifierValue":  text("Patient identifier value (with patientIdentifierSystem)"),
+		"subjectType":             {Type: "string", Description: "Resource type of the Observation subject (default Patient)", Enum: fhir.SubjectTypes},
+		"groupId":                 {Type: "string", Description: "FHIR Group id (with subjectType Group)", Pattern: fhir.IDPattern},
+		"encounterId":             text("Encounter id (bypasses encounter discovery)"),
+		"appointmentId":           text("Appointment id used for encounter discovery"),
+		"clientResourceId":        {Type: "string", Description: "Client-assigned Observation id", Pattern: fhir.IDPattern},
+		"submittedBy":             {Type: "string", Description: "Practitioner/{id} or Device/{id}", Pattern: submittedByPattern.String()},
+		"source":                  {Type: "string", Description: "Submission channel", Enum: cfg.AllowedSources},
+		"method":                  {Type: "string", Description: "How the screen was administered (default " + cfg.ObservationMethods[0] + ")", Enum: cfg.ObservationMethods},
+		"language":                text("Locale of the provider-facing Flag/Communication text (overrides Accept-Language)"),
+		"patientLanguage":         text("Patient's preferred locale for the receipt message (overrides Accept-Language)"),
+		"comments":                {Type: "string", Description: "Patient's free-text additional comments (Observation.note)", MaxLength: schema.Int(cfg.MaxCommentLength)},
+		"instrument":              {Type: "string", Description: "Screening instrument (default epds)", Enum: config.InstrumentNames},
+		"scores": {
+			Type:        "array",
+			Description: "All answers in question order (instead of q1..qN)",
+			Items:       answer("Answer"),
+			MinItems:    schema.Int(minItems),
+			MaxItems:    schema.Int(maxItems),
+		},
+	}
+	for i := 1; i <= maxItems; i++ {
+		props[fmt.Sprintf("q%d", i)] = answer(fmt.Sprintf("Answer to question %d", i))
+	}
+	if cfg.RecordUnscoredInstruments {
+		props["instrument"] = &schema.Schema{Type: "string", Description: "Screening instrument (default epds); others are recorded unscored", Pattern: instrumentNamePattern.String()}
+		props["scores"].MinItems = schema.Int(1)
+	}
+
+	return &schema.Schema{
+		Schema:     schema.Draft,
+		Title:      "EPDS submission",
+		Type:       "object",
+		Properties: props,
+	}
+}
+
+// handleSchema serves the submission JSON Schema (GET /schema).
+func (h *ApiHandler) handleSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	json.NewEncoder(w).Encode(submissionSchema(h.Config))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"example.com/epds-service/internal/config"
+	"example.com/epds-service/internal/scoring"
+)
+
+// runScoreMode scores a set of answers to instrument offline and prints the result.
+// Answers come from the -answers flag, or the first line of stdin when the flag is empty.
+// Scoring rules come from the same environment variables as the server (Oystehr
+// credentials are not required). It returns the process exit code.
+func runScoreMode(instrument, answers string, in io.Reader, out, errOut io.Writer) int {
+	cfg, err := config.LoadScoringConfig()
+	if err != nil {
+		fmt.Fprintf(errOut, "error: %v\n", err)
+		return 1
+	}
+	inst, ok := cfg.Instrument(instrument)
+	if !ok {
+		fmt.Fprintf(errOut, "error: -instrument must be one of %s\n", strings.Join(config.InstrumentNames, ", "))
+		return 1
+	}
+	rules := inst.Rules
+
+	if strings.TrimSpace(answers) == "" {
+		line, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil && err != io.EOF {
+			fmt.Fprintf(errOut, "error: failed to read answers from stdin: %v\n", err)
+			return 1
+		}
+		answers = line
+	}
+
+	parsed, err := scoring.ParseAnswers(answers, rules)
+	if err != nil {
+		fmt.Fprintf(errOut, "error: %v\n", err)
+		return 1
+	}
+
+	result := scoring.Score(parsed, rules)
+	fmt.Fprintf(out, "total=%d q%d=%d highRisk=%t interpretation=%s\n", result.Total, rules.SelfHarmIndex()+1, result.Q10, result.HighRisk, result.Band.Code)
+	return 0
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"example.com/epds-service/internal/config"
+)
+
+// APIVersionParam is the query parameter pinning the response shape, e.g. ?apiVersion=v2.
+// Clients may instead send a version parameter on Accept: application/json; version=2.
+const APIVersionParam = "apiVersion"
+
+// SuccessResponseV2 is the expanded submission response (apiVersion v2): the v1 fields plus
+// the risk determination and the IDs of everything created, so integrations need no FHIR reads.
+type SuccessResponseV2 struct {
+	SuccessResponse
+	HighRisk                bool   `json:"highRisk"`
+	EncounterID             string `json:"encounterId,omitempty"`
+	FlagID                  string `json:"flagId,omitempty"`
+	CommunicationID         string `json:"communicationId,omitempty"`
+	QuestionnaireResponseID string `json:"questionnaireResponseId,omitempty"`
+	ProvenanceID            string `json:"provenanceId,omitempty"`
+}
+
+// responseVersion returns the response shape the client pinned (query parameter first,
+// then Accept), or fallback (RESPONSE_API_VERSION) when it pinned none. "2" and "v2" are equivalent.
+func responseVersion(r *http.Request, fallback string) (string, error) {
+	v := r.URL.Query().Get(APIVersionParam)
+	if v == "" {
+		for _, mediaRange := range strings.Split(r.Header.Get("Accept"), ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err == nil && mediaType == "application/json" && params["version"] != "" {
+				v = params["version"]
+				break
+			}
+		}
+	}
+	if v == "" {
+		return fallback, nil
+	}
+	v = strings.ToLower(v)
+	if !strings.HasPrefix(v, "v") {
+		v = "v" + v
+	}
+	switch v {
+	case config.APIVersion1, config.APIVersion2:
+		return v, nil
+	}
+	return "", fmt.Errorf("%s must be %s or %s", APIVersionParam, config.APIVersion1, config.APIVersion2)
+}
+
+// versionedResponse returns res in the given shape, with apiVersion set.
+func versionedResponse(res SuccessResponseV2, version string) any {
+	res.APIVersion = version
+	if version == config.APIVersion2 {
+		return res
+	}
+	return res.SuccessResponse
+}
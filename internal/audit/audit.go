@@ -0,0 +1,93 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Audit record events: what created the resources.
+const (
+	EventSubmission      = "submission"       // A screening submission
+	EventDeadLetterRetry = "deadletter-retry" // A queued Flag or Communication recreated
+	EventReflag          = "reflag"           // A missing alert backfilled by -reflag
+)
+
+// Resource identifies one FHIR resource created on a patient's behalf.
+type Resource struct {
+	ResourceType string `json:"resourceType"`
+	ID           string `json:"id"`
+}
+
+// Record is one immutable audit entry describing the resources created for a submission,
+// a deadletter retry or a reflag backfill.
+type Record struct {
+	Timestamp   time.Time  `json:"timestamp"`
+	Event       string     `json:"event"`       // EventSubmission, EventDeadLetterRetry or EventReflag
+	PatientHash string     `json:"patientHash"` // SHA-256 of the FHIR Patient ID, never the raw ID
+	TotalScore  int        `json:"totalScore"`
+	Q10Score    int        `json:"q10Score"`
+	HighRisk    bool       `json:"highRisk"`
+	Resources   []Resource `json:"resources"`             // Every resource created, in creation order
+	SubmittedBy string     `json:"submittedBy,omitempty"` // Practitioner/Device reference, when supplied
+}
+
+// Add appends a created resource to the record. An empty id (nothing created) is ignored.
+func (r *Record) Add(resourceType, id string) {
+	if id != "" {
+		r.Resources = append(r.Resources, Resource{ResourceType: resourceType, ID: id})
+	}
+}
+
+// Writer persists audit records. Implementations must be safe for concurrent use.
+type Writer interface {
+	Write(rec Record) error
+}
+
+// HashPatientID returns the hex-encoded SHA-256 of a patient ID for use in audit records.
+func HashPatientID(patientID string) string {
+	sum := sha256.Sum256([]byte(patientID))
+	return hex.EncodeToString(sum[:])
+}
+
+// FileWriter appends audit records to a local file as JSON Lines.
+type FileWriter struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewFileWriter opens (or creates) path in append-only mode.
+func NewFileWriter(path string) (*FileWriter, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %w", path, err)
+	}
+	return &FileWriter{file: f}, nil
+}
+
+// Write appends rec as a single JSON line.
+func (w *FileWriter) Write(rec Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit record: %w", err)
+	}
+	line = append(line, '\n')
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if _, err := w.file.Write(line); err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+	return nil
+}
+
+// Close closes the underlying file.
+func (w *FileWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.file.Close()
+}
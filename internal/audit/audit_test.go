@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRecordAdd(t *testing.T) {
+	tests := []struct {
+		name string
+		adds []Resource
+		want []Resource
+	}{
+		{"none", nil, nil},
+		{"in order", []Resource{{"Observation", "o1"}, {"Flag", "f1"}}, []Resource{{"Observation", "o1"}, {"Flag", "f1"}}},
+		{"empty IDs skipped", []Resource{{"Patient", ""}, {"Observation", "o1"}, {"Task", ""}}, []Resource{{"Observation", "o1"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rec Record
+			for _, r := range tt.adds {
+				rec.Add(r.ResourceType, r.ID)
+			}
+			if !slices.Equal(rec.Resources, tt.want) {
+				t.Errorf("Resources = %v, want %v", rec.Resources, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileWriterAppendsJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	w, err := NewFileWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := Record{Timestamp: time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC), Event: EventReflag, PatientHash: HashPatientID("p1"), HighRisk: true}
+	rec.Add("Flag", "f1")
+	for range 2 {
+		if err := w.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, _ := json.Marshal(rec)
+	want := string(line) + "\n" + string(line) + "\n"
+	if string(data) != want {
+		t.Errorf("audit log = %s, want %s", data, want)
+	}
+	if HashPatientID("p1") == "p1" || len(HashPatientID("p1")) != 64 {
+		t.Errorf("HashPatientID did not hash the ID")
+	}
+}
@@ -2,17 +2,51 @@ package auth
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"example.com/epds-service/internal/config" // Assuming this is your module path
+	"example.com/epds-service/internal/metrics"
 )
 
+// Token cache metrics across all Authenticators, for tuning the refresh buffer against
+// how often tokens are actually fetched.
+var (
+	tokenCacheHits = metrics.NewCounter(
+		"epds_token_cache_hits_total",
+		"GetAuthToken calls answered with the cached Oystehr token.",
+	)
+	tokenFetches = metrics.NewCounter(
+		"epds_token_fetches_total",
+		"GetAuthToken calls that requested a new token from the Oystehr auth endpoint (including failed requests).",
+	)
+	_ = metrics.NewGaugeFunc(
+		"epds_token_cache_hit_ratio",
+		"Share of GetAuthToken calls answered from the cache since start (0 before the first call).",
+		func() float64 { return hitRatio(tokenCacheHits.Value(), tokenFetches.Value()) },
+	)
+)
+
+// hitRatio returns hits/(hits+fetches), or 0 when there were none.
+func hitRatio(hits, fetches float64) float64 {
+	if hits+fetches == 0 {
+		return 0
+	}
+	return hits / (hits + fetches)
+}
+
 // AuthResponse represents the successful JSON response from the Oystehr auth endpoint.
 type AuthResponse struct {
 	AccessToken string `json:"access_token"`
@@ -20,6 +54,44 @@ type AuthResponse struct {
 	ExpiresIn   int64  `json:"expires_in"` // Oystehr returns expires_in in seconds
 }
 
+// UnmarshalJSON accepts expires_in as any JSON number or, as some non-Oystehr OAuth
+// servers send it, a string holding one ("3600"), truncated to whole seconds.
+func (r *AuthResponse) UnmarshalJSON(data []byte) error {
+	type plain AuthResponse // Without this method, so decoding does not recurse
+	aux := struct {
+		*plain
+		ExpiresIn json.RawMessage `json:"expires_in"`
+	}{plain: (*plain)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	raw := aux.ExpiresIn
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		raw = json.RawMessage(strings.TrimSpace(s))
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		r.ExpiresIn = 0
+		return nil
+	}
+	// Any JSON number is accepted (3600, 3600.0, 3.6e3); fractions of a second are dropped
+	f, err := strconv.ParseFloat(string(raw), 64)
+	if err != nil || math.IsNaN(f) || math.Abs(f) > math.MaxInt32 {
+		return fmt.Errorf("expires_in must be a number of seconds, got %s", aux.ExpiresIn)
+	}
+	r.ExpiresIn = int64(f)
+	return nil
+}
+
+// authRetryBackoff is the base delay before retrying a failed token request; it doubles
+// per attempt and each delay is jittered to between half and all of it.
+const authRetryBackoff = 200 * time.Millisecond
+
+// minTokenLifetime is the shortest token lifetime we accept from the auth server.
+// Zero, negative, or tiny expires_in values are clamped to this to avoid refreshing on every request.
+const minTokenLifetime = 60 * time.Second
+
 // AuthErrorResponse represents a potential error JSON response from Oystehr auth.
 type AuthErrorResponse struct {
 	Error            string `json:"error"`
@@ -32,8 +104,20 @@ type Authenticator struct {
 	httpClient  *http.Client
 	token       string
 	expiry      time.Time
+	refreshAt   time.Time     // When to proactively refresh (expiry minus buffer, see tokenLifetime)
+	projectID   string        // Project ID from config or, when blank, from the token claims
+	clockOffset time.Duration // Auth server clock minus local clock, measured at the last fetch
 	mutex       sync.RWMutex
 	tokenBuffer time.Duration // Buffer before actual expiry to refresh token
+	refresh     *tokenRefresh // In-flight token request; nil when none
+}
+
+// tokenRefresh is one token request shared by the callers that need it. token and err
+// are set before done is closed.
+type tokenRefresh struct {
+	done  chan struct{}
+	token string
+	err   error
 }
 
 // NewAuthenticator creates a new Authenticator instance.
@@ -49,34 +133,154 @@ func NewAuthenticator(cfg *config.Config, client *http.Client) *Authenticator {
 }
 
 // GetAuthToken retrieves a valid Oystehr access token, fetching a new one if necessary.
+// Only one token request runs at a time, and no lock is held while it (and its retries)
+// run: callers that arrive meanwhile get the cached token while it has not expired, and
+// otherwise wait for the request's result.
 func (a *Authenticator) GetAuthToken() (string, error) {
 	a.mutex.RLock()
 	// Check if the current token is valid and not nearing expiry
-	if a.token != "" && time.Now().Before(a.expiry.Add(-a.tokenBuffer)) {
+	if a.token != "" && a.config.Now().Before(a.refreshAt) {
 		token := a.token
 		a.mutex.RUnlock()
+		tokenCacheHits.Inc()
 		log.Println("Using cached Oystehr token")
 		return token, nil
 	}
 	a.mutex.RUnlock()
 
-	// If token is invalid or nearing expiry, acquire write lock and fetch new token
-	return a.fetchNewToken()
+	a.mutex.Lock()
+	now := a.config.Now()
+	// Double-check if another goroutine fetched the token while waiting for the lock
+	if a.token != "" && now.Before(a.refreshAt) {
+		token := a.token
+		a.mutex.Unlock()
+		log.Println("Another routine refreshed the token while waiting for lock")
+		tokenCacheHits.Inc()
+		return token, nil
+	}
+	if refresh := a.refresh; refresh != nil {
+		if a.token != "" && now.Before(a.expiry) {
+			token := a.token
+			a.mutex.Unlock()
+			tokenCacheHits.Inc()
+			log.Println("Using cached Oystehr token while it is refreshed")
+			return token, nil
+		}
+		a.mutex.Unlock()
+		<-refresh.done
+		tokenCacheHits.Inc()
+		return refresh.token, refresh.err
+	}
+	refresh := &tokenRefresh{done: make(chan struct{})}
+	a.refresh = refresh
+	cached, cachedExpiry := a.token, a.expiry
+	a.mutex.Unlock()
+
+	refresh.token, refresh.err = a.fetchNewToken()
+
+	a.mutex.Lock()
+	a.refresh = nil
+	// A failed refresh does not discard a token that is still valid
+	if refresh.err != nil && cached != "" && a.token == cached && a.config.Now().Before(cachedExpiry) {
+		log.Printf("WARNING: Oystehr token refresh failed; using the cached token until it expires at %s: %v", cachedExpiry.Format(time.RFC3339), refresh.err)
+		refresh.token, refresh.err = cached, nil
+	}
+	a.mutex.Unlock()
+	close(refresh.done)
+	return refresh.token, refresh.err
 }
 
-// fetchNewToken performs the POST request to get a new token.
-func (a *Authenticator) fetchNewToken() (string, error) {
+// Invalidate discards the cached token so the next GetAuthToken fetches a new one.
+func (a *Authenticator) Invalidate() {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
+	a.token = ""
+	a.expiry = time.Time{}
+	a.refreshAt = time.Time{}
+	log.Println("Cached Oystehr token invalidated")
+}
 
-	// Double-check if another goroutine fetched the token while waiting for the lock
-	if a.token != "" && time.Now().Before(a.expiry.Add(-a.tokenBuffer)) {
-		log.Println("Another routine refreshed the token while waiting for lock")
-		return a.token, nil
+// Reject discards token if it is still the cached one. The FHIR server answered 401 to it,
+// so when it looked valid locally the log notes the likely causes (clock drift beyond
+// TOKEN_CLOCK_SKEW_SECONDS or server-side revocation).
+func (a *Authenticator) Reject(token string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if token == "" || token != a.token {
+		return // Already replaced by a fresh token
+	}
+	if remaining := a.expiry.Sub(a.config.Now()); remaining > 0 {
+		log.Printf("WARNING: Oystehr rejected a cached token that appears valid locally (expires in %s by local clock, auth server clock offset %s); possible clock drift or revocation",
+			remaining.Round(time.Second), a.clockOffset.Round(time.Second))
 	}
+	a.token = ""
+	a.expiry = time.Time{}
+	a.refreshAt = time.Time{}
+}
+
+// fetchNewToken performs the POST request to get a new token and caches it. Only the
+// caller that owns a.refresh calls it, without holding the lock.
+func (a *Authenticator) fetchNewToken() (string, error) {
+	tokenFetches.Inc()
 
 	log.Println("Fetching new Oystehr token...")
 
+	// Retry transient failures (network errors, 429, 5xx) with jittered backoff so a blip
+	// at the auth endpoint does not fail the submission waiting on this token
+	var authResp AuthResponse
+	var resp *http.Response
+	var err error
+	backoff := authRetryBackoff
+	for attempt := 0; ; attempt++ {
+		authResp, resp, err = a.requestToken()
+		if err == nil || attempt >= a.config.AuthMaxRetries || !transientAuthError(err) {
+			break
+		}
+		delay := backoff/2 + rand.N(backoff/2)
+		log.Printf("Oystehr token request failed (attempt %d of %d), retrying in %s: %v", attempt+1, a.config.AuthMaxRetries+1, delay.Round(time.Millisecond), err)
+		time.Sleep(delay)
+		backoff *= 2
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if authResp.AccessToken == "" {
+		return "", fmt.Errorf("received empty access token from Oystehr auth API")
+	}
+
+	// Resolve the project ID, deriving it from the token when config leaves it blank
+	projectID, err := a.resolveProjectID(authResp.AccessToken)
+	if err != nil {
+		return "", err
+	}
+	// A tenant-routed base URL without FHIR_TENANT is filled from the token on every request
+	if a.config.OystehrFHIRBaseURL == "" && ProjectIDFromToken(authResp.AccessToken, a.config.FHIRTenantClaim) == "" {
+		return "", fmt.Errorf("FHIR_TENANT is not set and the access token has no %q claim", a.config.FHIRTenantClaim)
+	}
+
+	// Store the new token and expiry time
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.token = authResp.AccessToken
+	a.projectID = projectID
+	now := a.config.Now()
+	a.clockOffset = a.serverClockOffset(resp, now)
+	lifetime := a.tokenLifetime(authResp.ExpiresIn)
+	if exp, ok := a.claimedLifetime(authResp.AccessToken, now); ok && exp < lifetime {
+		log.Printf("Token exp claim is earlier than expires_in; assuming %s lifetime", exp.Round(time.Second))
+		lifetime = exp
+	}
+	a.expiry = now.Add(lifetime)
+	a.refreshAt = a.expiry.Add(-a.refreshBuffer(lifetime))
+	log.Printf("Successfully fetched new Oystehr token. Expires in: %d seconds", authResp.ExpiresIn)
+
+	return a.token, nil
+}
+
+// requestToken performs one POST to the auth endpoint and parses the token response.
+// The returned response's body is already closed; only its headers are still useful.
+func (a *Authenticator) requestToken() (AuthResponse, *http.Response, error) {
 	// Prepare request body according to Appendix A.4
 	reqBodyMap := map[string]string{
 		"client_id":     a.config.OystehrM2MClientID,
@@ -86,13 +290,20 @@ func (a *Authenticator) fetchNewToken() (string, error) {
 	}
 	reqBodyBytes, err := json.Marshal(reqBodyMap)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal auth request body: %w", err)
+		return AuthResponse{}, nil, fmt.Errorf("failed to marshal auth request body: %w", err)
 	}
 
 	// Create POST request
 	req, err := http.NewRequest("POST", a.config.OystehrAuthURL, bytes.NewBuffer(reqBodyBytes))
 	if err != nil {
-		return "", fmt.Errorf("failed to create auth request: %w", err)
+		return AuthResponse{}, nil, fmt.Errorf("failed to create auth request: %w", err)
+	}
+	if a.config.ExtraHeadersOnAuth {
+		for name, values := range a.config.ExtraFHIRHeaders {
+			for _, value := range values {
+				req.Header.Add(name, value)
+			}
+		}
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
@@ -100,13 +311,13 @@ func (a *Authenticator) fetchNewToken() (string, error) {
 	// Execute request
 	resp, err := a.httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to execute auth request: %w", err)
+		return AuthResponse{}, nil, fmt.Errorf("failed to execute auth request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read auth response body: %w", err)
+		return AuthResponse{}, nil, fmt.Errorf("failed to read auth response body: %w", err)
 	}
 
 	// Handle non-200 status codes
@@ -114,26 +325,147 @@ func (a *Authenticator) fetchNewToken() (string, error) {
 		var errResp AuthErrorResponse
 		if json.Unmarshal(bodyBytes, &errResp) == nil && errResp.Error != "" {
 			// Try to parse Oystehr error format
-			return "", fmt.Errorf("oystehr auth API error (%d): %s - %s", resp.StatusCode, errResp.Error, errResp.ErrorDescription)
+			return AuthResponse{}, nil, &authStatusError{status: resp.StatusCode, message: fmt.Sprintf("oystehr auth API error (%d): %s - %s", resp.StatusCode, errResp.Error, errResp.ErrorDescription)}
 		}
 		// Fallback error message
-		return "", fmt.Errorf("oystehr auth API request failed with status code %d: %s", resp.StatusCode, string(bodyBytes))
+		return AuthResponse{}, nil, &authStatusError{status: resp.StatusCode, message: fmt.Sprintf("oystehr auth API request failed with status code %d: %s", resp.StatusCode, string(bodyBytes))}
 	}
 
 	// Parse successful response
 	var authResp AuthResponse
 	if err := json.Unmarshal(bodyBytes, &authResp); err != nil {
-		return "", fmt.Errorf("failed to unmarshal auth response JSON: %w", err)
+		return AuthResponse{}, nil, fmt.Errorf("failed to unmarshal auth response JSON: %w", err)
 	}
+	return authResp, resp, nil
+}
 
-	if authResp.AccessToken == "" {
-		return "", fmt.Errorf("received empty access token from Oystehr auth API")
+// authStatusError is a non-200 response from the auth endpoint.
+type authStatusError struct {
+	status  int
+	message string
+}
+
+func (e *authStatusError) Error() string { return e.message }
+
+// transientAuthError reports whether a token request failure is worth retrying: a
+// connection failure, a timeout, or a 429/5xx from the auth endpoint. Other failures
+// (bad credentials, an open circuit breaker) would fail again.
+func transientAuthError(err error) bool {
+	var statusErr *authStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.status == http.StatusTooManyRequests || statusErr.status >= 500
 	}
+	var opErr *net.OpError
+	var netErr net.Error
+	return errors.As(err, &opErr) || (errors.As(err, &netErr) && netErr.Timeout()) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
 
-	// Store the new token and expiry time
-	a.token = authResp.AccessToken
-	a.expiry = time.Now().Add(time.Duration(authResp.ExpiresIn) * time.Second)
-	log.Printf("Successfully fetched new Oystehr token. Expires in: %d seconds", authResp.ExpiresIn)
+// tokenLifetime validates expires_in and returns the lifetime to assume for the token.
+// Suspicious values are clamped to minTokenLifetime.
+func (a *Authenticator) tokenLifetime(expiresIn int64) time.Duration {
+	lifetime := time.Duration(expiresIn) * time.Second
+	if lifetime < minTokenLifetime {
+		log.Printf("WARNING: Oystehr auth returned suspicious expires_in=%d; assuming %s lifetime", expiresIn, minTokenLifetime)
+		lifetime = minTokenLifetime
+	}
+	return lifetime
+}
 
-	return a.token, nil
+// refreshBuffer returns how long before expiry to refresh: the token buffer plus the
+// clock-skew allowance, never more than half the lifetime so a short-lived token is still reused.
+func (a *Authenticator) refreshBuffer(lifetime time.Duration) time.Duration {
+	buffer := a.tokenBuffer + a.config.TokenClockSkew
+	if buffer > lifetime/2 {
+		buffer = lifetime / 2
+	}
+	return buffer
+}
+
+// serverClockOffset estimates the auth server's clock minus the local clock from the
+// response Date header, warning when the drift exceeds TOKEN_CLOCK_SKEW_SECONDS.
+// It returns 0 when the header is missing or unparsable.
+func (a *Authenticator) serverClockOffset(resp *http.Response, now time.Time) time.Duration {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0
+	}
+	offset := date.Sub(now).Truncate(time.Second) // Date has one-second resolution
+	if offset > a.config.TokenClockSkew || -offset > a.config.TokenClockSkew {
+		log.Printf("WARNING: Local clock differs from the Oystehr auth server by %s (allowed skew %s)", offset, a.config.TokenClockSkew)
+	}
+	return offset
+}
+
+// claimedLifetime returns the remaining lifetime implied by the token's exp claim,
+// translated to the local clock with the measured server offset. It reports false when
+// the token has no usable exp claim.
+func (a *Authenticator) claimedLifetime(token string, now time.Time) (time.Duration, bool) {
+	claims, err := TokenClaims(token)
+	if err != nil {
+		return 0, false
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return 0, false
+	}
+	lifetime := time.Unix(int64(exp), 0).Add(-a.clockOffset).Sub(now)
+	if lifetime < minTokenLifetime {
+		// An already-expired claim means the offset estimate is off; trust expires_in
+		return 0, false
+	}
+	return lifetime, true
+}
+
+// resolveProjectID returns the project ID for token: the configured value if set
+// (validated against the token claim when both exist), otherwise the claim itself.
+// With FHIR_URL_TEMPLATE the project ID is optional.
+func (a *Authenticator) resolveProjectID(token string) (string, error) {
+	claimID := ProjectIDFromToken(token, a.config.OystehrProjectIDClaim)
+	configID := a.config.OystehrProjectID
+
+	switch {
+	case configID != "" && claimID != "" && configID != claimID:
+		return "", fmt.Errorf("OYSTEHR_PROJECT_ID (%s) does not match token claim %q (%s)", configID, a.config.OystehrProjectIDClaim, claimID)
+	case configID != "":
+		return configID, nil
+	case claimID != "":
+		log.Printf("Using project ID %s from token claim %q", claimID, a.config.OystehrProjectIDClaim)
+		return claimID, nil
+	case a.config.FHIRURLTemplate != "":
+		return "", nil // The tenant path segment routes the request; no project header is needed
+	default:
+		return "", fmt.Errorf("OYSTEHR_PROJECT_ID is not set and the access token has no %q claim", a.config.OystehrProjectIDClaim)
+	}
+}
+
+// ProjectID returns the Oystehr project ID in effect for the current token.
+// It is empty until a token has been fetched.
+func (a *Authenticator) ProjectID() string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.projectID
+}
+
+// LogCacheStats logs the token cache hits and fetches of each interval until ctx is
+// cancelled (TOKEN_STATS_LOG_INTERVAL_SECONDS). Intervals without token use are skipped.
+func LogCacheStats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lastHits, lastFetches float64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			hits, fetches := tokenCacheHits.Value(), tokenFetches.Value()
+			dHits, dFetches := hits-lastHits, fetches-lastFetches
+			lastHits, lastFetches = hits, fetches
+			if dHits+dFetches == 0 {
+				continue
+			}
+			log.Printf("Oystehr token cache over the last %s: %.0f hits, %.0f fetches (hit ratio %.1f%%; %.1f%% since start)",
+				interval, dHits, dFetches, 100*hitRatio(dHits, dFetches), 100*hitRatio(hits, fetches))
+		}
+	}
 }
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/epds-service/internal/config"
+)
+
+// tokenServer is an auth endpoint that counts token requests and answers each with a
+// distinct token expiring after expiresIn. delay holds each response back.
+func tokenServer(t *testing.T, expiresIn string, delay time.Duration) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := fetches.Add(1)
+		time.Sleep(delay)
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":%s}`, n, expiresIn)
+	}))
+	t.Cleanup(server.Close)
+	return server, &fetches
+}
+
+// testConfig points an Authenticator at authURL with a clock the test controls.
+func testConfig(authURL string, now *time.Time) *config.Config {
+	return &config.Config{
+		OystehrAuthURL:     authURL,
+		OystehrFHIRBaseURL: "https://fhir.example.com/r4",
+		OystehrProjectID:   "project",
+		Clock:              func() time.Time { return *now },
+	}
+}
+
+func TestAuthResponseExpiresIn(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    int64
+		wantErr bool
+	}{
+		{"integer", `{"access_token":"t","expires_in":3600}`, 3600, false},
+		{"decimal", `{"access_token":"t","expires_in":3600.0}`, 3600, false},
+		{"exponent", `{"access_token":"t","expires_in":3.6e3}`, 3600, false},
+		{"fraction truncated", `{"access_token":"t","expires_in":59.9}`, 59, false},
+		{"string", `{"access_token":"t","expires_in":"3600"}`, 3600, false},
+		{"padded string", `{"access_token":"t","expires_in":" 3600 "}`, 3600, false},
+		{"null", `{"access_token":"t","expires_in":null}`, 0, false},
+		{"absent", `{"access_token":"t"}`, 0, false},
+		{"word", `{"access_token":"t","expires_in":"soon"}`, 0, true},
+		{"NaN string", `{"access_token":"t","expires_in":"NaN"}`, 0, true},
+		{"out of range", `{"access_token":"t","expires_in":1e300}`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r AuthResponse
+			err := json.Unmarshal([]byte(tt.body), &r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err == nil && r.ExpiresIn != tt.want {
+				t.Errorf("ExpiresIn = %d, want %d", r.ExpiresIn, tt.want)
+			}
+			if err == nil && r.AccessToken != "t" {
+				t.Errorf("AccessToken = %q, want %q", r.AccessToken, "t")
+			}
+		})
+	}
+}
+
+func TestGetAuthTokenConcurrentCallersShareOneFetch(t *testing.T) {
+	server, fetches := tokenServer(t, "3600", 50*time.Millisecond)
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	a := NewAuthenticator(testConfig(server.URL, &now), server.Client())
+
+	const callers = 100
+	tokens := make([]string, callers)
+	errs := make([]error, callers)
+	var start, done sync.WaitGroup
+	start.Add(1)
+	for i := range callers {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			start.Wait()
+			tokens[i], errs[i] = a.GetAuthToken()
+		}()
+	}
+	start.Done()
+	done.Wait()
+
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("token fetches = %d, want 1", got)
+	}
+	for i := range callers {
+		if errs[i] != nil {
+			t.Fatalf("caller %d: GetAuthToken error: %v", i, errs[i])
+		}
+		if tokens[i] != "token-1" {
+			t.Errorf("caller %d got %q, want the shared token-1", i, tokens[i])
+		}
+	}
+}
+
+func TestGetAuthTokenClampsShortLifetimes(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresIn string
+	}{
+		{"zero", "0"},
+		{"negative", "-5"},
+		{"one second", "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, fetches := tokenServer(t, tt.expiresIn, 0)
+			now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+			a := NewAuthenticator(testConfig(server.URL, &now), server.Client())
+
+			// The token is clamped to minTokenLifetime and reused, not refetched per call
+			for range 10 {
+				if _, err := a.GetAuthToken(); err != nil {
+					t.Fatalf("GetAuthToken error: %v", err)
+				}
+			}
+			if got := fetches.Load(); got != 1 {
+				t.Fatalf("token fetches = %d, want 1", got)
+			}
+
+			// It is refreshed once past the refresh point (at most half the clamped lifetime)
+			now = now.Add(minTokenLifetime/2 + time.Second)
+			token, err := a.GetAuthToken()
+			if err != nil {
+				t.Fatalf("GetAuthToken error: %v", err)
+			}
+			if got := fetches.Load(); got != 2 || token != "token-2" {
+				t.Errorf("after refresh point: fetches = %d, token = %q; want 2, token-2", got, token)
+			}
+		})
+	}
+}
+
+func TestGetAuthTokenServesCachedTokenDuringRefreshRetries(t *testing.T) {
+	// The first token is issued; every refresh gets a 503 and is retried
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) == 1 {
+			w.Write([]byte(`{"access_token":"token-1","token_type":"Bearer","expires_in":3600}`))
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	cfg := testConfig(server.URL, &now)
+	cfg.AuthMaxRetries = 2
+	a := NewAuthenticator(cfg, server.Client())
+	if _, err := a.GetAuthToken(); err != nil {
+		t.Fatalf("GetAuthToken error: %v", err)
+	}
+
+	// Past the refresh point, but the token has not expired
+	now = now.Add(58 * time.Minute)
+	type result struct {
+		token string
+		err   error
+	}
+	refreshed := make(chan result, 1)
+	go func() {
+		token, err := a.GetAuthToken()
+		refreshed <- result{token, err}
+	}()
+	for fetches.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The refresh is backing off; another caller is not held up by it
+	start := time.Now()
+	token, err := a.GetAuthToken()
+	if err != nil || token != "token-1" {
+		t.Fatalf("GetAuthToken during the refresh = %q, %v; want the cached token-1", token, err)
+	}
+	if waited := time.Since(start); waited > authRetryBackoff/4 {
+		t.Errorf("GetAuthToken during the refresh took %s, want no wait for the backoff", waited)
+	}
+
+	// The failed refresh keeps the still-valid token
+	if got := <-refreshed; got.err != nil || got.token != "token-1" {
+		t.Errorf("refreshing GetAuthToken = %q, %v; want the cached token-1", got.token, got.err)
+	}
+	// The first fetch, then the refresh and its retries
+	if got, want := fetches.Load(), int32(2+cfg.AuthMaxRetries); got != want {
+		t.Errorf("token requests = %d, want %d", got, want)
+	}
+}
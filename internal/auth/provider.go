@@ -0,0 +1,33 @@
+package auth
+
+// TokenProvider supplies Oystehr access tokens. *Authenticator is the production
+// implementation; StaticTokenProvider lets handler tests run without a live auth endpoint.
+type TokenProvider interface {
+	// GetAuthToken returns a valid access token, fetching a new one if necessary.
+	GetAuthToken() (string, error)
+	// Invalidate discards any cached token so the next GetAuthToken fetches a fresh one.
+	Invalidate()
+	// Reject reports that the server answered 401 to token, discarding it if still cached.
+	Reject(token string)
+}
+
+// StaticTokenProvider is an in-memory TokenProvider that always returns Token (or Err).
+// It is intended for tests and local development only.
+type StaticTokenProvider struct {
+	Token string
+	Err   error
+}
+
+// GetAuthToken returns the canned token or error.
+func (s *StaticTokenProvider) GetAuthToken() (string, error) {
+	if s.Err != nil {
+		return "", s.Err
+	}
+	return s.Token, nil
+}
+
+// Invalidate is a no-op for the static provider.
+func (s *StaticTokenProvider) Invalidate() {}
+
+// Reject is a no-op for the static provider.
+func (s *StaticTokenProvider) Reject(token string) {}
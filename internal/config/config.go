@@ -1,19 +1,411 @@
 package config
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"maps"
+	"net/http"
+	"net/textproto"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	_ "time/tzdata" // Embed the zone database so OBSERVATION_TIMEZONE works in minimal containers
+	"unicode"
+
+	"example.com/epds-service/internal/scoring"
+)
+
+// ParticipationModes maps the supported v3 ParticipationMode codes (Communication.medium) to their display text.
+var ParticipationModes = map[string]string{
+	"ELECTRONIC": "electronic data",
+	"EMAILWRIT":  "email",
+	"FAXWRIT":    "telefax",
+	"ONLINEWRIT": "online written",
+	"PHONE":      "telephone",
+	"VERBAL":     "verbal",
+	"VIDEOCONF":  "videoconferencing",
+	"WRITTEN":    "written",
+	"MAILWRIT":   "mail",
+	"TYPEWRIT":   "typewritten",
+}
+
+// EncounterClass is a v3 ActCode for Encounter.class.
+type EncounterClass struct {
+	Code    string
+	Display string
+}
+
+// EncounterClasses maps the AUTO_ENCOUNTER_CLASS names to their v3 ActCode.
+var EncounterClasses = map[string]EncounterClass{
+	"ambulatory": {Code: "AMB", Display: "ambulatory"},
+	"emergency":  {Code: "EMER", Display: "emergency"},
+	"field":      {Code: "FLD", Display: "field"},
+	"home":       {Code: "HH", Display: "home health"},
+	"inpatient":  {Code: "IMP", Display: "inpatient encounter"},
+	"virtual":    {Code: "VR", Display: "virtual"},
+}
+
+// FlagPriorityCodes maps each Flag severity (FLAG_SEVERITY_MAP) to its code in
+// http://terminology.hl7.org/CodeSystem/flag-priority-code.
+var FlagPriorityCodes = map[string]string{
+	"low":      "PL",
+	"moderate": "PM",
+	"high":     "PH",
+}
+
+// FlagSeverityLevels lists the keys of FlagPriorityCodes lowest first, for ordering by severity.
+var FlagSeverityLevels = []string{"low", "moderate", "high"}
+
+// FlagSeveritySelfHarm is the severity of every Flag raised by the self-harm item,
+// whatever the total: the highest in FlagPriorityCodes.
+const FlagSeveritySelfHarm = "high"
+
+// DefaultFlagSeverities maps the default EPDS and PHQ-9 score band codes to Flag severities.
+// Bands below the high-risk cutoff only produce a Flag through the self-harm item (or a
+// lowered HIGH_RISK_THRESHOLD) and are mapped conservatively.
+func DefaultFlagSeverities() map[string]string {
+	return map[string]string{
+		"low":               "moderate",
+		"moderate":          "moderate",
+		"high":              "high",
+		"minimal":           "moderate",
+		"mild":              "moderate",
+		"moderately-severe": "high",
+		"severe":            "high",
+	}
+}
+
+// senderReferencePattern matches the Communication.sender reference types this service accepts.
+var senderReferencePattern = regexp.MustCompile(`^(Device|Practitioner|PractitionerRole|Organization|HealthcareService)/[A-Za-z0-9\-.]{1,64}$`)
+
+// DefaultTarget names the only FHIR target when FHIR_TARGETS is not set.
+const DefaultTarget = "default"
+
+// headerNamePattern matches an HTTP header field name (RFC 9110 token).
+var headerNamePattern = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// reservedFHIRHeaders are set by the service on every FHIR request and cannot be
+// replaced through EXTRA_FHIR_HEADERS.
+var reservedFHIRHeaders = []string{"Authorization", "X-Zapehr-Project-Id", "Content-Type", "Accept", "Accept-Encoding"}
+
+// targetNamePattern matches a FHIR_TARGETS entry (also its X-EPDS-Env header value).
+var targetNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ItemCode is the terminology code for one EPDS question (QuestionnaireResponse item.code).
+type ItemCode struct {
+	System  string
+	Code    string
+	Display string
+}
+
+// DefaultQuestionCodes returns the LOINC codes of the EPDS panel (71354-5) members, by question.
+func DefaultQuestionCodes() []ItemCode {
+	const loinc = "http://loinc.org"
+	return []ItemCode{
+		{loinc, "71355-2", "I have been able to laugh and see the funny side of things"},
+		{loinc, "71356-0", "I have looked forward with enjoyment to things"},
+		{loinc, "71357-8", "I have blamed myself unnecessarily when things went wrong"},
+		{loinc, "71358-6", "I have been anxious or worried for no good reason"},
+		{loinc, "71359-4", "I have felt scared or panicky for no very good reason"},
+		{loinc, "71360-2", "Things have been getting on top of me"},
+		{loinc, "71361-0", "I have been so unhappy that I have had difficulty sleeping"},
+		{loinc, "71362-8", "I have felt sad or miserable"},
+		{loinc, "71363-6", "I have been so unhappy that I have been crying"},
+		{loinc, "71364-4", "The thought of harming myself has occurred to me"},
+	}
+}
+
+// DefaultPHQ9QuestionCodes returns the LOINC codes of the PHQ-9 panel (44249-1) members, by question.
+func DefaultPHQ9QuestionCodes() []ItemCode {
+	const loinc = "http://loinc.org"
+	return []ItemCode{
+		{loinc, "44250-9", "Little interest or pleasure in doing things"},
+		{loinc, "44255-8", "Feeling down, depressed, or hopeless"},
+		{loinc, "44259-0", "Trouble falling or staying asleep, or sleeping too much"},
+		{loinc, "44254-1", "Feeling tired or having little energy"},
+		{loinc, "44251-7", "Poor appetite or overeating"},
+		{loinc, "44258-2", "Feeling bad about yourself - or that you are a failure or have let yourself or your family down"},
+		{loinc, "44252-5", "Trouble concentrating on things, such as reading the newspaper or watching television"},
+		{loinc, "44253-3", "Moving or speaking so slowly that other people could have noticed. Or the opposite - being so fidgety or restless that you have been moving around a lot more than usual"},
+		{loinc, "44260-8", "Thoughts that you would be better off dead, or of hurting yourself in some way"},
+	}
+}
+
+// Instrument names accepted in a submission's "instrument" field.
+const (
+	InstrumentEPDS = "epds" // Default
+	InstrumentPHQ9 = "phq9"
+)
+
+// InstrumentNames lists the accepted instrument names, default first.
+var InstrumentNames = []string{InstrumentEPDS, InstrumentPHQ9}
+
+// Instrument is a screening questionnaire the service can score and record.
+type Instrument struct {
+	Name      string     // Submission value, e.g. "epds"
+	Display   string     // Name used in FHIR text, e.g. "EPDS"
+	TotalCode ItemCode   // Observation.code of the total score
+	ItemCodes []ItemCode // QuestionnaireResponse item.code per question (index 0 is q1)
+	Rules     scoring.Rules
+	Unscored  bool // No scoring rules: answers are recorded without a total (see UnscoredInstrument)
+}
+
+// DefaultLocale is the locale of the provider-facing text built into the FHIR builders.
+// It is used when a submission asks for no locale or one without a message table.
+const DefaultLocale = "en"
+
+// Localized message keys (LOCALIZED_MESSAGES_FILE). Each value is a text/template
+// rendered with fhir.AlertMessageData.
+const (
+	MessageFlagCategory   = "flagCategory"   // Flag.category text
+	MessageFlagCode       = "flagCode"       // Flag.code text
+	MessageAlert          = "alert"          // High-risk Communication payload
+	MessageNegativeScreen = "negativeScreen" // Negative-screen Communication payload
+	MessageReceipt        = "receipt"        // Patient-facing confirmation in the submission response
+)
+
+// DefaultMessages holds the built-in translations, by locale and message key. English
+// lives in the FHIR builders (overridable with ALERT_MESSAGE_TEMPLATE) and, for the
+// receipt, the submit handler, so it is not listed.
+var DefaultMessages = map[string]map[string]string{
+	"es": {
+		MessageFlagCategory:   "Puntuación {{.Instrument}} alta o riesgo de autolesión reportado",
+		MessageFlagCode:       "Puntuación {{.Instrument}} alta ({{.TotalScore}}) o riesgo en P{{.SelfHarmItem}} ({{if .Q10Unanswered}}sin respuesta{{else}}{{.Q10Score}}{{end}}) indicado.",
+		MessageAlert:          "Alerta: Puntuación {{.Instrument}} alta ({{.TotalScore}}) registrada para el Paciente {{.PatientID}}. Puntuación P{{.SelfHarmItem}}: {{if .Q10Unanswered}}sin respuesta{{else}}{{.Q10Score}}{{end}}. Por favor revise el expediente del paciente.",
+		MessageNegativeScreen: "Evaluación {{.Instrument}} completada para el Paciente {{.PatientID}}. Puntuación: {{.TotalScore}} (tamizaje negativo). Puntuación P{{.SelfHarmItem}}: {{.Q10Score}}. No se requiere acción.",
+		MessageReceipt:        "Gracias. Hemos recibido su cuestionario {{.Instrument}}; su equipo de atención lo revisará.",
+	},
+}
+
+// REFERENCE_STYLE values.
+const (
+	ReferenceStyleRelative = "relative" // Patient/{id}
+	ReferenceStyleAbsolute = "absolute" // {OYSTEHR_FHIR_BASE_URL}/Patient/{id}
+)
+
+// PATIENT_NOT_FOUND_BEHAVIOR values.
+const (
+	PatientNotFoundReject = "reject" // 400 when an identifier matches no Patient
+	PatientNotFoundCreate = "create" // Create a provisional Patient with the identifier
+)
+
+// DELIVERY_MODE values.
+const (
+	DeliveryModeCreate  = "create"  // One create request per resource
+	DeliveryModeMessage = "message" // One message Bundle POSTed to $process-message
+)
+
+// FHIR_VERSION values. Resources are built in R4 shape and converted for R5 servers.
+const (
+	FHIRVersionR4 = "R4"
+	FHIRVersionR5 = "R5"
+)
+
+// NO_ENCOUNTER_BEHAVIOR values, for high-risk screens with no Encounter to link the Flag to.
+const (
+	NoEncounterPatientFlag = "patient-scoped-flag" // Flag without an encounter; the chart banner may not show
+	NoEncounterSkipFlag    = "skip-flag"           // No Flag; the provider is still alerted by Communication
+	NoEncounterFail        = "fail"                // Reject the submission before anything is created
+)
+
+// ENCOUNTER_MISMATCH_BEHAVIOR values, for submissions whose encounterId is not the
+// Encounter of their appointmentId.
+const (
+	EncounterMismatchWarn   = "warn"   // Keep the given encounterId and add a warning
+	EncounterMismatchReject = "reject" // Reject the submission before anything is created
+)
+
+// COMMUNICATION_STATUS values. This service only creates the Communication; delivering
+// it to the provider's inbox is the EHR's job, so "completed" is a claim about the EHR.
+const (
+	CommunicationStatusPreparation = "preparation" // Not yet sent; Communication.sent is omitted
+	CommunicationStatusInProgress  = "in-progress" // Handed to the EHR for delivery
+	CommunicationStatusCompleted   = "completed"   // Treated as delivered
+)
+
+// RESPONSE_API_VERSION values: the shape of successful submission responses, which
+// clients may pin per request (apiVersion query parameter or Accept version).
+const (
+	APIVersion1 = "v1" // status, observationId, calculatedScore, interpretation, receipt, warnings
+	APIVersion2 = "v2" // v1 plus highRisk and the IDs of every created resource
 )
 
 // Config holds the application configuration loaded from environment variables.
 type Config struct {
 	OystehrFHIRBaseURL     string
 	OystehrAuthURL         string
-	OystehrProjectID       string
+	OystehrProjectID       string // Optional; derived from the token's OystehrProjectIDClaim when blank
+	OystehrProjectIDClaim  string // JWT claim holding the project ID (OYSTEHR_PROJECT_ID_CLAIM)
+	FHIRURLTemplate        string // Tenant-routed base URL with a {tenant} placeholder; replaces OystehrFHIRBaseURL when set
+	FHIRTenant             string // Fills {tenant}; derived from the token's FHIRTenantClaim when blank
+	FHIRTenantClaim        string // JWT claim holding the tenant (FHIR_TENANT_CLAIM)
 	OystehrM2MClientID     string
 	OystehrM2MClientSecret string
+	TokenClockSkew         time.Duration // Allowance for local clock drift when judging cached token validity
+	AuthMaxRetries         int           // Retries of a token request after a transient failure; 0 disables
+	TokenStatsLogInterval  time.Duration // How often token cache hits/fetches are logged; 0 disables the log line
 	AlertProviderFHIRID    string
 	Port                   string // Optional port from environment
+	OystehrProxyURL        string // Optional explicit egress proxy; falls back to HTTPS_PROXY/NO_PROXY
+	AuditLogPath           string // Optional append-only JSONL audit log; auditing disabled when empty
+	OystehrCABundle        string // Optional PEM file of extra CAs trusted for Oystehr TLS
+	TLSMinVersion          uint16 // Minimum TLS version for outbound calls (OYSTEHR_TLS_MIN_VERSION)
+
+	ExtraFHIRHeaders   http.Header // Static headers added to every FHIR request (EXTRA_FHIR_HEADERS)
+	ExtraHeadersOnAuth bool        // Also send ExtraFHIRHeaders to the auth endpoint
+
+	OystehrInsecureSkipVerify bool // DEV ONLY: disables TLS certificate verification
+	DebugEcho                 bool // DEV ONLY: failed creates return the FHIR payload and response to the client
+
+	// Fraction of submissions (0.0-1.0) whose Observation/Flag/Communication payloads are
+	// logged at DEBUG with patient identifiers redacted (DEBUG_PAYLOAD_SAMPLE_RATE); 0 disables.
+	DebugPayloadSampleRate float64
+
+	ObservationConditionalCreate bool // Send If-None-Exist so repeat screens on the same day reuse the Observation
+
+	AlertMessageTemplate *template.Template // Optional Communication payload template (ALERT_MESSAGE_TEMPLATE)
+	AllowedSources       []string           // Accepted values for the submission "source" field
+	AutoCreateEncounter  bool               // Create an Encounter of AutoEncounterClass when none is active for a high-risk screen
+	AutoEncounterClass   EncounterClass     // Encounter.class of auto-created Encounters (AUTO_ENCOUNTER_CLASS)
+	RateLimitPerMinute   int                // Submissions per minute per client; 0 disables rate limiting
+	RateLimitBurst       int                // Burst size for the submission rate limiter; at least 1 when enabled
+	GzipMinBytes         int                // Smallest read-endpoint response gzip-compressed for clients that accept it; 0 disables
+	FlagsMaxPageSize     int                // Largest _count accepted by GET /api/v1/flags/active (FLAGS_MAX_PAGE_SIZE)
+	APIKeys              []string           // Accepted X-API-Key values; submit endpoint is unauthenticated when empty
+	CommunicationMedium  []string           // ParticipationMode codes for Communication.medium (e.g. PHONE, EMAILWRIT)
+	CommunicationSender  string             // Optional Communication.sender reference (e.g. Device/{id} for this service)
+	CommunicationStatus  string             // Communication.status of created alerts (COMMUNICATION_STATUS)
+	ReferenceStyle       string             // ReferenceStyleRelative or ReferenceStyleAbsolute for references in created resources
+	PatientNotFound      string             // PatientNotFoundReject or PatientNotFoundCreate for zero-match identifiers
+	DeliveryMode         string             // DeliveryModeCreate or DeliveryModeMessage for the Observation/Flag/Communication
+	APIVersion           string             // Response shape when the client pins none (RESPONSE_API_VERSION)
+	FHIRVersion          string             // FHIRVersionR4 or FHIRVersionR5; the shape of resources sent to Oystehr
+	NoEncounter          string             // NO_ENCOUNTER_BEHAVIOR when a high-risk screen has no Encounter
+	EncounterMismatch    string             // ENCOUNTER_MISMATCH_BEHAVIOR when encounterId and appointmentId disagree
+	MaxRetriesPerRequest int                // Total retries shared by all FHIR calls of one submission; 0 disables retries
+	ObservationLocation  *time.Location     // Timezone for emitted FHIR timestamps; nil means process-local (TZ)
+	MaxUploadBytes       int64              // Maximum request body size, including multipart attachments
+	MaxCommentLength     int                // Maximum characters of the patient's free-text comments (Observation.note)
+	ProgramID            string             // Screening program/study tagged on every created screen resource (PROGRAM_ID); empty for none
+	EnableProvenance     bool               // Create a Provenance for submissions that include submittedBy
+	DedupWindow          time.Duration      // Identical resubmissions within this window return the prior result; 0 disables
+	PatientCacheTTL      time.Duration      // Identifier-to-Patient resolutions are reused for this long; 0 disables the cache
+	AlertDebounce        time.Duration      // At most one Communication of a kind per patient in this window (COMMUNICATION_DEBOUNCE_SECONDS); 0 disables
+	EnforceSameOrg       bool               // Create the Communication only when the alert provider is in the patient's managingOrganization
+
+	// Identifier systems accepted for patient resolution (ALLOWED_IDENTIFIER_SYSTEMS), e.g.
+	// the organization's MRN namespaces; any system is accepted when empty.
+	AllowedIdentifierSystems []string
+
+	// Identifier type (PATIENT_IDENTIFIER_TYPE) that narrows identifier searches with
+	// :of-type; both are empty when patients are searched by system|value only.
+	PatientIdentifierTypeSystem string
+	PatientIdentifierTypeCode   string
+
+	// Flag severity by score band code (FLAG_SEVERITY_MAP), one of FlagPriorityCodes.
+	// Flags raised by the self-harm item are always FlagSeveritySelfHarm.
+	FlagSeverities map[string]string
+
+	// How screens are administered (OBSERVATION_METHODS): the accepted submission "method"
+	// codes, the first being the default, and their Observation.method code system.
+	ObservationMethods      []string
+	ObservationMethodSystem string
+
+	// Observation.category text (OBSERVATION_CATEGORY_TEXT) for chart views that show text
+	// rather than the coding display; omitted when empty.
+	ObservationCategoryText string
+
+	// Localized provider-facing text by locale then message key (DefaultMessages merged
+	// with LOCALIZED_MESSAGES_FILE). Missing entries fall back to the English wording.
+	Messages map[string]map[string]*template.Template
+
+	// QuestionnaireResponse settings
+	EnableQuestionnaireResponse bool       // Also record the individual answers as a QuestionnaireResponse
+	RecordUnscoredInstruments   bool       // Record unknown instruments' answers with a preliminary, valueless Observation
+	QuestionCodes               []ItemCode // item.code per question (index 0 is q1); LOINC EPDS items by default
+
+	// FHIR request timeouts. Each create is bounded (retries included) by the timeout for
+	// its resource type, falling back to FHIRTimeout; FHIRTimeout also bounds searches.
+	FHIRTimeout      time.Duration
+	ResourceTimeouts map[string]time.Duration // By resource type (Observation, Flag, Communication)
+
+	// Outbound connection pool (shared by auth and FHIR requests)
+	MaxIdleConnsPerHost int           // Idle keep-alive connections kept per host (HTTP_MAX_IDLE_CONNS_PER_HOST)
+	IdleConnTimeout     time.Duration // How long an idle connection is kept before closing; 0 keeps it indefinitely
+
+	// External scoring (SCORING_WEBHOOK_URL): a clinical-rules engine decides the total and
+	// high risk in place of the local rules; empty URL scores locally
+	ScoringWebhookURL      string
+	ScoringWebhookTimeout  time.Duration
+	ScoringWebhookFallback bool // Score locally when the webhook fails; otherwise the submission fails
+	// ScoringWebhookOverridesSelfHarm lets the webhook clear a locally positive self-harm
+	// item; by default a positive item keeps the screen high risk whatever the webhook says
+	ScoringWebhookOverridesSelfHarm bool
+
+	// Self-harm escalation (SELF_HARM_ESCALATION_URL): a pager or secondary webhook called when
+	// a self-harm alert Communication cannot be delivered, after any deadletter retries
+	SelfHarmEscalationURL     string
+	SelfHarmEscalationTimeout time.Duration
+
+	// Maintenance mode: submissions get 503 with Retry-After while health and read endpoints
+	// keep working. MaintenanceMode is the startup state; /admin/maintenance flips it at runtime
+	// when AdminAPIKeys (ADMIN_API_KEYS) is set.
+	MaintenanceMode       bool
+	MaintenanceRetryAfter time.Duration
+	AdminAPIKeys          []string
+
+	// Patient-context tokens (PATIENT_TOKEN_SECRET): HS256 JWTs from the patient portal whose
+	// PatientTokenClaim names the patient, used as the subject when no patient is in the body
+	PatientTokenSecret []byte
+	PatientTokenClaim  string
+
+	// Circuit breaker settings (per upstream host)
+	CircuitBreakerThreshold int           // Consecutive failures that open the circuit; 0 disables the breaker
+	CircuitBreakerCooldown  time.Duration // How long an open circuit fails fast before a half-open probe
+
+	// Deadletter queue for failed high-risk Flag/Communication side-effects
+	DeadLetterPath          string        // File backing the retry queue; queueing disabled when empty
+	DeadLetterRetryInterval time.Duration // Worker poll interval and base retry backoff
+	DeadLetterMaxAge        time.Duration // Entries older than this are abandoned (logged as errors)
+
+	// Janitor resolving high-risk Flags once the patient's latest screen is below threshold
+	FlagJanitorInterval time.Duration // How often it runs (FLAG_JANITOR_INTERVAL_SECONDS); 0 disables it
+	FlagJanitorMinAge   time.Duration // Only Flags not updated for this long are considered
+
+	// Named Oystehr projects (FHIR_TARGETS) selectable per request; the first is the default.
+	// Each target is a full Config sharing every non-Oystehr setting with its parent.
+	Targets     map[string]*Config
+	TargetNames []string
+	TargetName  string // Name of this target within Targets
+
+	// Clock overrides the current time for the Authenticator and FHIR builders (tests only).
+	// It is never set from the environment; nil means time.Now.
+	Clock func() time.Time
+
+	// Follow-up Task settings
+	EnableFollowUpTask            bool // Create a follow-up Task for high-risk screens
+	FollowUpDueHoursSelfHarm      int  // Due window when Q10 (self-harm) triggered the alert
+	FollowUpDueHoursElevatedTotal int  // Due window when only the total score triggered the alert
+
+	// Negative-screen Communication settings
+	EnableNegativeScreenCommunication bool               // Send a routine Communication for non-high-risk screens
+	NegativeScreenMessageTemplate     *template.Template // Optional payload template (NEGATIVE_SCREEN_MESSAGE_TEMPLATE)
+
+	// Scoring settings (see LoadScoringConfig)
+	AnswerMin int // Lowest valid per-question answer (ANSWER_MIN)
+	AnswerMax int // Highest valid per-question answer (ANSWER_MAX)
+
+	StrictQ10 bool // Reject submissions without q10; when false they are flagged for clinician review (STRICT_Q10)
+
+	HighRiskThreshold int            // Total score threshold for high risk (HIGH_RISK_THRESHOLD)
+	HighRiskOperator  string         // "gte" or "gt" comparison against the threshold (HIGH_RISK_OPERATOR)
+	ScoreBands        []scoring.Band // Interpretation bands of the total score (SCORE_BANDS)
 }
 
 // LoadConfig reads required environment variables and returns a Config struct.
@@ -23,30 +415,459 @@ func LoadConfig() (*Config, error) {
 		OystehrFHIRBaseURL:     os.Getenv("OYSTEHR_FHIR_BASE_URL"),
 		OystehrAuthURL:         os.Getenv("OYSTEHR_AUTH_URL"),
 		OystehrProjectID:       os.Getenv("OYSTEHR_PROJECT_ID"),
+		OystehrProjectIDClaim:  os.Getenv("OYSTEHR_PROJECT_ID_CLAIM"),
+		FHIRURLTemplate:        os.Getenv("FHIR_URL_TEMPLATE"),
+		FHIRTenant:             os.Getenv("FHIR_TENANT"),
+		FHIRTenantClaim:        getEnvFallback("FHIR_TENANT_CLAIM", "tenant"),
 		OystehrM2MClientID:     os.Getenv("OYSTEHR_M2M_CLIENT_ID"),
 		OystehrM2MClientSecret: os.Getenv("OYSTEHR_M2M_CLIENT_SECRET"),
 		AlertProviderFHIRID:    os.Getenv("ALERT_PROVIDER_FHIR_ID"),
 		Port:                   os.Getenv("PORT"),
+		OystehrProxyURL:        os.Getenv("OYSTEHR_PROXY_URL"),
+		AuditLogPath:           os.Getenv("AUDIT_LOG_PATH"),
+		DeadLetterPath:         os.Getenv("DEADLETTER_PATH"),
+		OystehrCABundle:        os.Getenv("OYSTEHR_CA_BUNDLE"),
+	}
+
+	// Validate required fields. With FHIR_TARGETS the unsuffixed values are only
+	// shared defaults, so they are validated per target instead (see loadTargets).
+	targetNames := getEnvList("FHIR_TARGETS", nil)
+	if len(targetNames) == 0 {
+		if err := cfg.requireTarget(""); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.OystehrProjectIDClaim == "" {
+		cfg.OystehrProjectIDClaim = "project_id"
+	}
+
+	if cfg.OystehrProxyURL != "" {
+		if u, err := url.Parse(cfg.OystehrProxyURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("OYSTEHR_PROXY_URL must be an absolute URL (e.g. http://proxy:3128), got %q", cfg.OystehrProxyURL)
+		}
+	}
+
+	var err error
+	if cfg.ObservationConditionalCreate, err = getEnvBool("OBSERVATION_CONDITIONAL_CREATE", false); err != nil {
+		return nil, err
+	}
+
+	switch v := getEnvFallback("OYSTEHR_TLS_MIN_VERSION", "1.2"); v {
+	case "1.2":
+		cfg.TLSMinVersion = tls.VersionTLS12
+	case "1.3":
+		cfg.TLSMinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("OYSTEHR_TLS_MIN_VERSION must be 1.2 or 1.3, got %q", v)
+	}
+	if cfg.OystehrInsecureSkipVerify, err = getEnvBool("OYSTEHR_INSECURE_SKIP_VERIFY", false); err != nil {
+		return nil, err
+	}
+	if cfg.DebugEcho, err = getEnvBool("DEBUG_ECHO", false); err != nil {
+		return nil, err
+	}
+	if cfg.DebugPayloadSampleRate, err = getEnvFloat("DEBUG_PAYLOAD_SAMPLE_RATE", 0); err != nil {
+		return nil, err
+	}
+	if cfg.DebugPayloadSampleRate < 0 || cfg.DebugPayloadSampleRate > 1 {
+		return nil, fmt.Errorf("DEBUG_PAYLOAD_SAMPLE_RATE must be between 0.0 and 1.0, got %g", cfg.DebugPayloadSampleRate)
+	}
+
+	if cfg.AutoCreateEncounter, err = getEnvBool("AUTO_CREATE_ENCOUNTER", false); err != nil {
+		return nil, err
+	}
+	className := strings.ToLower(getEnvFallback("AUTO_ENCOUNTER_CLASS", "ambulatory"))
+	class, ok := EncounterClasses[className]
+	if !ok {
+		return nil, fmt.Errorf("AUTO_ENCOUNTER_CLASS must be one of %s, got %q", strings.Join(slices.Sorted(maps.Keys(EncounterClasses)), ", "), className)
+	}
+	cfg.AutoEncounterClass = class
+
+	if cfg.EnableProvenance, err = getEnvBool("ENABLE_PROVENANCE", false); err != nil {
+		return nil, err
+	}
+
+	if cfg.EnableQuestionnaireResponse, err = getEnvBool("ENABLE_QUESTIONNAIRE_RESPONSE", false); err != nil {
+		return nil, err
+	}
+	if cfg.RecordUnscoredInstruments, err = getEnvBool("RECORD_UNSCORED_INSTRUMENTS", false); err != nil {
+		return nil, err
+	}
+	if cfg.QuestionCodes, err = parseQuestionCodes(os.Getenv("QUESTION_CODES")); err != nil {
+		return nil, err
+	}
+
+	if tmpl := os.Getenv("ALERT_MESSAGE_TEMPLATE"); tmpl != "" {
+		if cfg.AlertMessageTemplate, err = template.New("alert").Parse(tmpl); err != nil {
+			return nil, fmt.Errorf("invalid ALERT_MESSAGE_TEMPLATE: %w", err)
+		}
+	}
+
+	if cfg.ExtraFHIRHeaders, err = parseHeaders(os.Getenv("EXTRA_FHIR_HEADERS")); err != nil {
+		return nil, err
+	}
+	if cfg.ExtraHeadersOnAuth, err = getEnvBool("EXTRA_FHIR_HEADERS_ON_AUTH", false); err != nil {
+		return nil, err
+	}
+
+	if cfg.Messages, err = loadMessages(os.Getenv("LOCALIZED_MESSAGES_FILE")); err != nil {
+		return nil, err
+	}
+
+	if cfg.EnableNegativeScreenCommunication, err = getEnvBool("ENABLE_NEGATIVE_SCREEN_COMMUNICATION", false); err != nil {
+		return nil, err
+	}
+	if tmpl := os.Getenv("NEGATIVE_SCREEN_MESSAGE_TEMPLATE"); tmpl != "" {
+		if cfg.NegativeScreenMessageTemplate, err = template.New("negative").Parse(tmpl); err != nil {
+			return nil, fmt.Errorf("invalid NEGATIVE_SCREEN_MESSAGE_TEMPLATE: %w", err)
+		}
+	}
+
+	if cfg.RateLimitPerMinute, err = getEnvInt("RATE_LIMIT_PER_MINUTE", 0); err != nil {
+		return nil, err
+	}
+	if cfg.RateLimitBurst, err = getEnvInt("RATE_LIMIT_BURST", 0); err != nil {
+		return nil, err
+	}
+	if cfg.GzipMinBytes, err = getEnvInt("GZIP_MIN_BYTES", 1024); err != nil {
+		return nil, err
+	}
+	if cfg.GzipMinBytes < 0 {
+		return nil, fmt.Errorf("GZIP_MIN_BYTES must not be negative, got %d", cfg.GzipMinBytes)
+	}
+	if cfg.FlagsMaxPageSize, err = getEnvInt("FLAGS_MAX_PAGE_SIZE", 200); err != nil {
+		return nil, err
+	}
+	if cfg.FlagsMaxPageSize < 1 {
+		return nil, fmt.Errorf("FLAGS_MAX_PAGE_SIZE must be positive, got %d", cfg.FlagsMaxPageSize)
+	}
+
+	if cfg.EnableFollowUpTask, err = getEnvBool("ENABLE_FOLLOWUP_TASK", false); err != nil {
+		return nil, err
+	}
+	if cfg.FollowUpDueHoursSelfHarm, err = getEnvInt("FOLLOWUP_DUE_HOURS_SELF_HARM", 48); err != nil {
+		return nil, err
+	}
+	if cfg.FollowUpDueHoursElevatedTotal, err = getEnvInt("FOLLOWUP_DUE_HOURS_ELEVATED_TOTAL", 14*24); err != nil {
+		return nil, err
+	}
+	if cfg.FollowUpDueHoursSelfHarm <= 0 || cfg.FollowUpDueHoursElevatedTotal <= 0 {
+		return nil, fmt.Errorf("FOLLOWUP_DUE_HOURS_* values must be positive")
+	}
+	if cfg.FollowUpDueHoursSelfHarm > cfg.FollowUpDueHoursElevatedTotal {
+		return nil, fmt.Errorf("FOLLOWUP_DUE_HOURS_SELF_HARM (%d) must not exceed FOLLOWUP_DUE_HOURS_ELEVATED_TOTAL (%d)",
+			cfg.FollowUpDueHoursSelfHarm, cfg.FollowUpDueHoursElevatedTotal)
+	}
+
+	if cfg.MaxRetriesPerRequest, err = getEnvInt("MAX_RETRIES_PER_REQUEST", 3); err != nil {
+		return nil, err
+	}
+
+	if tz := os.Getenv("OBSERVATION_TIMEZONE"); tz != "" {
+		if cfg.ObservationLocation, err = time.LoadLocation(tz); err != nil {
+			return nil, fmt.Errorf("invalid OBSERVATION_TIMEZONE %q: %w", tz, err)
+		}
+	}
+
+	maxUpload, err := getEnvInt("MAX_UPLOAD_BYTES", 10<<20)
+	if err != nil {
+		return nil, err
+	}
+	if maxUpload <= 0 {
+		return nil, fmt.Errorf("MAX_UPLOAD_BYTES must be positive, got %d", maxUpload)
+	}
+	cfg.MaxUploadBytes = int64(maxUpload)
+	if cfg.MaxCommentLength, err = getEnvInt("MAX_COMMENT_LENGTH", 2000); err != nil {
+		return nil, err
+	}
+	if cfg.MaxCommentLength <= 0 {
+		return nil, fmt.Errorf("MAX_COMMENT_LENGTH must be positive, got %d", cfg.MaxCommentLength)
+	}
+	cfg.ProgramID = strings.TrimSpace(os.Getenv("PROGRAM_ID"))
+	if strings.ContainsFunc(cfg.ProgramID, unicode.IsSpace) {
+		return nil, fmt.Errorf("PROGRAM_ID must be a single code without whitespace, got %q", cfg.ProgramID)
+	}
+
+	if cfg.CircuitBreakerThreshold, err = getEnvInt("CIRCUIT_BREAKER_THRESHOLD", 5); err != nil {
+		return nil, err
+	}
+	cooldown, err := getEnvInt("CIRCUIT_BREAKER_COOLDOWN_SECONDS", 30)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.CircuitBreakerThreshold < 0 || cooldown <= 0 {
+		return nil, fmt.Errorf("CIRCUIT_BREAKER_THRESHOLD must not be negative and CIRCUIT_BREAKER_COOLDOWN_SECONDS must be positive")
+	}
+	cfg.CircuitBreakerCooldown = time.Duration(cooldown) * time.Second
+
+	if cfg.MaintenanceMode, err = getEnvBool("MAINTENANCE_MODE", false); err != nil {
+		return nil, err
+	}
+	maintenanceRetry, err := getEnvInt("MAINTENANCE_RETRY_AFTER_SECONDS", 300)
+	if err != nil {
+		return nil, err
+	}
+	if maintenanceRetry <= 0 {
+		return nil, fmt.Errorf("MAINTENANCE_RETRY_AFTER_SECONDS must be positive")
+	}
+	cfg.MaintenanceRetryAfter = time.Duration(maintenanceRetry) * time.Second
+	cfg.AdminAPIKeys = getEnvList("ADMIN_API_KEYS", nil)
+	if secret := os.Getenv("PATIENT_TOKEN_SECRET"); secret != "" {
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("PATIENT_TOKEN_SECRET must be at least 32 characters")
+		}
+		cfg.PatientTokenSecret = []byte(secret)
 	}
+	cfg.PatientTokenClaim = getEnvFallback("PATIENT_TOKEN_CLAIM", "patient")
 
-	// Validate required fields
-	if cfg.OystehrFHIRBaseURL == "" {
-		return nil, fmt.Errorf("required environment variable OYSTEHR_FHIR_BASE_URL is not set")
+	if cfg.ScoringWebhookURL = os.Getenv("SCORING_WEBHOOK_URL"); cfg.ScoringWebhookURL != "" {
+		if u, err := url.Parse(cfg.ScoringWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("SCORING_WEBHOOK_URL must be an absolute http(s) URL, got %q", cfg.ScoringWebhookURL)
+		}
 	}
-	if cfg.OystehrAuthURL == "" {
-		return nil, fmt.Errorf("required environment variable OYSTEHR_AUTH_URL is not set")
+	webhookTimeout, err := getEnvInt("SCORING_WEBHOOK_TIMEOUT_SECONDS", 5)
+	if err != nil {
+		return nil, err
 	}
-	if cfg.OystehrProjectID == "" {
-		return nil, fmt.Errorf("required environment variable OYSTEHR_PROJECT_ID is not set")
+	if webhookTimeout <= 0 {
+		return nil, fmt.Errorf("SCORING_WEBHOOK_TIMEOUT_SECONDS must be positive, got %d", webhookTimeout)
 	}
-	if cfg.OystehrM2MClientID == "" {
-		return nil, fmt.Errorf("required environment variable OYSTEHR_M2M_CLIENT_ID is not set")
+	cfg.ScoringWebhookTimeout = time.Duration(webhookTimeout) * time.Second
+	if cfg.ScoringWebhookFallback, err = getEnvBool("SCORING_WEBHOOK_FALLBACK", true); err != nil {
+		return nil, err
 	}
-	if cfg.OystehrM2MClientSecret == "" {
-		return nil, fmt.Errorf("required environment variable OYSTEHR_M2M_CLIENT_SECRET is not set")
+	if cfg.ScoringWebhookOverridesSelfHarm, err = getEnvBool("SCORING_WEBHOOK_OVERRIDES_SELF_HARM", false); err != nil {
+		return nil, err
 	}
-	if cfg.AlertProviderFHIRID == "" {
-		return nil, fmt.Errorf("required environment variable ALERT_PROVIDER_FHIR_ID is not set")
+
+	if cfg.SelfHarmEscalationURL = os.Getenv("SELF_HARM_ESCALATION_URL"); cfg.SelfHarmEscalationURL != "" {
+		if u, err := url.Parse(cfg.SelfHarmEscalationURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("SELF_HARM_ESCALATION_URL must be an absolute http(s) URL, got %q", cfg.SelfHarmEscalationURL)
+		}
+	}
+	escalationTimeout, err := getEnvInt("SELF_HARM_ESCALATION_TIMEOUT_SECONDS", 10)
+	if err != nil {
+		return nil, err
+	}
+	if escalationTimeout <= 0 {
+		return nil, fmt.Errorf("SELF_HARM_ESCALATION_TIMEOUT_SECONDS must be positive, got %d", escalationTimeout)
+	}
+	cfg.SelfHarmEscalationTimeout = time.Duration(escalationTimeout) * time.Second
+
+	if cfg.MaxIdleConnsPerHost, err = getEnvInt("HTTP_MAX_IDLE_CONNS_PER_HOST", http.DefaultMaxIdleConnsPerHost); err != nil {
+		return nil, err
+	}
+	idleTimeout, err := getEnvInt("HTTP_IDLE_CONN_TIMEOUT_SECONDS", 90)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxIdleConnsPerHost < 1 || idleTimeout < 0 {
+		return nil, fmt.Errorf("HTTP_MAX_IDLE_CONNS_PER_HOST must be positive and HTTP_IDLE_CONN_TIMEOUT_SECONDS must not be negative")
+	}
+	cfg.IdleConnTimeout = time.Duration(idleTimeout) * time.Second
+
+	skew, err := getEnvInt("TOKEN_CLOCK_SKEW_SECONDS", 30)
+	if err != nil {
+		return nil, err
+	}
+	if skew < 0 {
+		return nil, fmt.Errorf("TOKEN_CLOCK_SKEW_SECONDS must not be negative")
+	}
+	cfg.TokenClockSkew = time.Duration(skew) * time.Second
+
+	if cfg.AuthMaxRetries, err = getEnvInt("AUTH_MAX_RETRIES", 2); err != nil {
+		return nil, err
+	}
+	if cfg.AuthMaxRetries < 0 {
+		return nil, fmt.Errorf("AUTH_MAX_RETRIES must not be negative, got %d", cfg.AuthMaxRetries)
+	}
+	statsInterval, err := getEnvInt("TOKEN_STATS_LOG_INTERVAL_SECONDS", 3600)
+	if err != nil {
+		return nil, err
+	}
+	if statsInterval < 0 {
+		return nil, fmt.Errorf("TOKEN_STATS_LOG_INTERVAL_SECONDS must not be negative, got %d", statsInterval)
+	}
+	cfg.TokenStatsLogInterval = time.Duration(statsInterval) * time.Second
+
+	fhirTimeout, err := getEnvInt("FHIR_TIMEOUT_SECONDS", 15)
+	if err != nil {
+		return nil, err
+	}
+	if fhirTimeout <= 0 {
+		return nil, fmt.Errorf("FHIR_TIMEOUT_SECONDS must be positive, got %d", fhirTimeout)
+	}
+	cfg.FHIRTimeout = time.Duration(fhirTimeout) * time.Second
+	cfg.ResourceTimeouts = make(map[string]time.Duration)
+	for resourceType, key := range map[string]string{
+		"Observation":   "OBSERVATION_TIMEOUT_SECONDS",
+		"Flag":          "FLAG_TIMEOUT_SECONDS",
+		"Communication": "COMMUNICATION_TIMEOUT_SECONDS",
+	} {
+		seconds, err := getEnvInt(key, fhirTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if seconds <= 0 {
+			return nil, fmt.Errorf("%s must be positive, got %d", key, seconds)
+		}
+		cfg.ResourceTimeouts[resourceType] = time.Duration(seconds) * time.Second
+	}
+
+	dedupWindow, err := getEnvInt("DEDUP_WINDOW_SECONDS", 0)
+	if err != nil {
+		return nil, err
+	}
+	if dedupWindow < 0 {
+		return nil, fmt.Errorf("DEDUP_WINDOW_SECONDS must not be negative, got %d", dedupWindow)
+	}
+	cfg.DedupWindow = time.Duration(dedupWindow) * time.Second
+
+	patientCacheTTL, err := getEnvInt("PATIENT_CACHE_TTL_SECONDS", 0)
+	if err != nil {
+		return nil, err
+	}
+	if patientCacheTTL < 0 {
+		return nil, fmt.Errorf("PATIENT_CACHE_TTL_SECONDS must not be negative, got %d", patientCacheTTL)
+	}
+	cfg.PatientCacheTTL = time.Duration(patientCacheTTL) * time.Second
+
+	debounce, err := getEnvInt("COMMUNICATION_DEBOUNCE_SECONDS", 0)
+	if err != nil {
+		return nil, err
+	}
+	if debounce < 0 {
+		return nil, fmt.Errorf("COMMUNICATION_DEBOUNCE_SECONDS must not be negative, got %d", debounce)
+	}
+	cfg.AlertDebounce = time.Duration(debounce) * time.Second
+	if cfg.EnforceSameOrg, err = getEnvBool("ENFORCE_SAME_ORG", false); err != nil {
+		return nil, err
+	}
+
+	retryInterval, err := getEnvInt("DEADLETTER_RETRY_INTERVAL_SECONDS", 30)
+	if err != nil {
+		return nil, err
+	}
+	maxAge, err := getEnvInt("DEADLETTER_MAX_AGE_HOURS", 24)
+	if err != nil {
+		return nil, err
+	}
+	if retryInterval <= 0 || maxAge <= 0 {
+		return nil, fmt.Errorf("DEADLETTER_RETRY_INTERVAL_SECONDS and DEADLETTER_MAX_AGE_HOURS must be positive")
+	}
+	cfg.DeadLetterRetryInterval = time.Duration(retryInterval) * time.Second
+	cfg.DeadLetterMaxAge = time.Duration(maxAge) * time.Hour
+
+	janitorInterval, err := getEnvInt("FLAG_JANITOR_INTERVAL_SECONDS", 0)
+	if err != nil {
+		return nil, err
+	}
+	janitorAge, err := getEnvInt("FLAG_JANITOR_MIN_AGE_DAYS", 30)
+	if err != nil {
+		return nil, err
+	}
+	if janitorInterval < 0 || janitorAge <= 0 {
+		return nil, fmt.Errorf("FLAG_JANITOR_INTERVAL_SECONDS must not be negative and FLAG_JANITOR_MIN_AGE_DAYS must be positive")
+	}
+	cfg.FlagJanitorInterval = time.Duration(janitorInterval) * time.Second
+	cfg.FlagJanitorMinAge = time.Duration(janitorAge) * 24 * time.Hour
+
+	cfg.APIKeys = getEnvList("API_KEYS", nil)
+	for _, code := range getEnvList("COMMUNICATION_MEDIUM", nil) {
+		code = strings.ToUpper(code)
+		if _, ok := ParticipationModes[code]; !ok {
+			return nil, fmt.Errorf("COMMUNICATION_MEDIUM contains unsupported ParticipationMode code %q", code)
+		}
+		cfg.CommunicationMedium = append(cfg.CommunicationMedium, code)
+	}
+	if cfg.CommunicationSender = os.Getenv("COMMUNICATION_SENDER_FHIR_ID"); cfg.CommunicationSender != "" && !senderReferencePattern.MatchString(cfg.CommunicationSender) {
+		return nil, fmt.Errorf("COMMUNICATION_SENDER_FHIR_ID must be a reference such as Device/{id} or Practitioner/{id}, got %q", cfg.CommunicationSender)
+	}
+	switch cfg.CommunicationStatus = strings.ToLower(os.Getenv("COMMUNICATION_STATUS")); cfg.CommunicationStatus {
+	case "":
+		cfg.CommunicationStatus = CommunicationStatusCompleted
+	case CommunicationStatusPreparation, CommunicationStatusInProgress, CommunicationStatusCompleted:
+	default:
+		return nil, fmt.Errorf("COMMUNICATION_STATUS must be %q, %q or %q, got %q", CommunicationStatusPreparation, CommunicationStatusInProgress, CommunicationStatusCompleted, cfg.CommunicationStatus)
+	}
+	switch cfg.ReferenceStyle = strings.ToLower(os.Getenv("REFERENCE_STYLE")); cfg.ReferenceStyle {
+	case "":
+		cfg.ReferenceStyle = ReferenceStyleRelative
+	case ReferenceStyleRelative, ReferenceStyleAbsolute:
+	default:
+		return nil, fmt.Errorf("REFERENCE_STYLE must be %q or %q, got %q", ReferenceStyleRelative, ReferenceStyleAbsolute, cfg.ReferenceStyle)
+	}
+	switch cfg.PatientNotFound = strings.ToLower(os.Getenv("PATIENT_NOT_FOUND_BEHAVIOR")); cfg.PatientNotFound {
+	case "":
+		cfg.PatientNotFound = PatientNotFoundReject
+	case PatientNotFoundReject, PatientNotFoundCreate:
+	default:
+		return nil, fmt.Errorf("PATIENT_NOT_FOUND_BEHAVIOR must be %q or %q, got %q", PatientNotFoundReject, PatientNotFoundCreate, cfg.PatientNotFound)
+	}
+	if v := strings.TrimSpace(os.Getenv("PATIENT_IDENTIFIER_TYPE")); v != "" {
+		var ok bool
+		cfg.PatientIdentifierTypeSystem, cfg.PatientIdentifierTypeCode, ok = strings.Cut(v, "|")
+		if !ok || cfg.PatientIdentifierTypeSystem == "" || cfg.PatientIdentifierTypeCode == "" {
+			return nil, fmt.Errorf("PATIENT_IDENTIFIER_TYPE must be system|code (e.g. http://terminology.hl7.org/CodeSystem/v2-0203|MR), got %q", v)
+		}
+	}
+	switch cfg.APIVersion = strings.ToLower(os.Getenv("RESPONSE_API_VERSION")); cfg.APIVersion {
+	case "":
+		cfg.APIVersion = APIVersion1
+	case APIVersion1, APIVersion2:
+	default:
+		return nil, fmt.Errorf("RESPONSE_API_VERSION must be %q or %q, got %q", APIVersion1, APIVersion2, cfg.APIVersion)
+	}
+	switch cfg.DeliveryMode = strings.ToLower(os.Getenv("DELIVERY_MODE")); cfg.DeliveryMode {
+	case "":
+		cfg.DeliveryMode = DeliveryModeCreate
+	case DeliveryModeCreate, DeliveryModeMessage:
+	default:
+		return nil, fmt.Errorf("DELIVERY_MODE must be %q or %q, got %q", DeliveryModeCreate, DeliveryModeMessage, cfg.DeliveryMode)
+	}
+	switch cfg.FHIRVersion = strings.ToUpper(os.Getenv("FHIR_VERSION")); cfg.FHIRVersion {
+	case "":
+		cfg.FHIRVersion = FHIRVersionR4
+	case FHIRVersionR4, FHIRVersionR5:
+	default:
+		return nil, fmt.Errorf("FHIR_VERSION must be %q or %q, got %q", FHIRVersionR4, FHIRVersionR5, cfg.FHIRVersion)
+	}
+	switch cfg.NoEncounter = strings.ToLower(os.Getenv("NO_ENCOUNTER_BEHAVIOR")); cfg.NoEncounter {
+	case "":
+		cfg.NoEncounter = NoEncounterPatientFlag
+	case NoEncounterPatientFlag, NoEncounterSkipFlag, NoEncounterFail:
+	default:
+		return nil, fmt.Errorf("NO_ENCOUNTER_BEHAVIOR must be %q, %q or %q, got %q", NoEncounterPatientFlag, NoEncounterSkipFlag, NoEncounterFail, cfg.NoEncounter)
+	}
+	switch cfg.EncounterMismatch = strings.ToLower(os.Getenv("ENCOUNTER_MISMATCH_BEHAVIOR")); cfg.EncounterMismatch {
+	case "":
+		cfg.EncounterMismatch = EncounterMismatchWarn
+	case EncounterMismatchWarn, EncounterMismatchReject:
+	default:
+		return nil, fmt.Errorf("ENCOUNTER_MISMATCH_BEHAVIOR must be %q or %q, got %q", EncounterMismatchWarn, EncounterMismatchReject, cfg.EncounterMismatch)
+	}
+	cfg.AllowedSources = getEnvList("ALLOWED_SOURCES", []string{"portal", "kiosk", "clinician"})
+	cfg.AllowedIdentifierSystems = getEnvList("ALLOWED_IDENTIFIER_SYSTEMS", nil)
+	cfg.ObservationMethods = getEnvList("OBSERVATION_METHODS", []string{"self-administered", "interviewer-administered"})
+	if len(cfg.ObservationMethods) == 0 {
+		return nil, fmt.Errorf("OBSERVATION_METHODS must list at least one method code")
+	}
+	cfg.ObservationMethodSystem = getEnvFallback("OBSERVATION_METHOD_SYSTEM", "urn:cornell:epds:method")
+	cfg.ObservationCategoryText = strings.TrimSpace(os.Getenv("OBSERVATION_CATEGORY_TEXT"))
+	cfg.FlagSeverities = DefaultFlagSeverities()
+	if items := getEnvList("FLAG_SEVERITY_MAP", nil); items != nil {
+		cfg.FlagSeverities = make(map[string]string, len(items))
+		for _, item := range items {
+			band, severity, _ := strings.Cut(item, ":")
+			if _, ok := FlagPriorityCodes[severity]; !ok || band == "" {
+				return nil, fmt.Errorf("FLAG_SEVERITY_MAP entries must be band:severity with severity low, moderate or high, got %q", item)
+			}
+			cfg.FlagSeverities[band] = severity
+		}
+	}
+
+	if err := loadScoring(cfg); err != nil {
+		return nil, err
 	}
 
 	// Set default port if not provided
@@ -54,5 +875,409 @@ func LoadConfig() (*Config, error) {
 		cfg.Port = "8080"
 	}
 
+	if err := loadTargets(cfg, targetNames); err != nil {
+		return nil, err
+	}
+
 	return cfg, nil
 }
+
+// requireTarget checks the per-target required settings; suffix names the environment
+// variables they came from (e.g. "_STAGING"), or is empty for the single-target variables.
+func (c *Config) requireTarget(suffix string) error {
+	baseURL := c.OystehrFHIRBaseURL
+	if c.FHIRURLTemplate != "" {
+		baseURL = c.FHIRURLTemplate
+	}
+	required := []struct{ name, value string }{
+		{"OYSTEHR_FHIR_BASE_URL", baseURL},
+		{"OYSTEHR_AUTH_URL", c.OystehrAuthURL},
+		{"OYSTEHR_M2M_CLIENT_ID", c.OystehrM2MClientID},
+		{"OYSTEHR_M2M_CLIENT_SECRET", c.OystehrM2MClientSecret},
+		{"ALERT_PROVIDER_FHIR_ID", c.AlertProviderFHIRID},
+	}
+	for _, r := range required {
+		if r.value == "" {
+			return fmt.Errorf("required environment variable %s%s is not set", r.name, suffix)
+		}
+	}
+	return nil
+}
+
+// TenantPlaceholder is replaced by the tenant in FHIR_URL_TEMPLATE.
+const TenantPlaceholder = "{tenant}"
+
+// TenantURL returns FHIR_URL_TEMPLATE with the placeholder replaced by tenant.
+func (c *Config) TenantURL(tenant string) string {
+	return strings.ReplaceAll(c.FHIRURLTemplate, TenantPlaceholder, url.PathEscape(tenant))
+}
+
+// applyURLTemplate checks FHIR_URL_TEMPLATE and, when it is set, makes it the target's base
+// URL in place of OYSTEHR_FHIR_BASE_URL. With FHIR_TENANT the URL is filled in now; otherwise
+// OystehrFHIRBaseURL is left empty and each request fills it from the token's tenant claim.
+func (c *Config) applyURLTemplate(suffix string) error {
+	if c.FHIRURLTemplate == "" {
+		return nil
+	}
+	if !strings.Contains(c.FHIRURLTemplate, TenantPlaceholder) {
+		return fmt.Errorf("FHIR_URL_TEMPLATE%s must contain %s, got %q", suffix, TenantPlaceholder, c.FHIRURLTemplate)
+	}
+	if u, err := url.Parse(c.TenantURL("tenant")); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("FHIR_URL_TEMPLATE%s must be an absolute URL (e.g. https://gateway/fhir/{tenant}/R4), got %q", suffix, c.FHIRURLTemplate)
+	}
+	c.OystehrFHIRBaseURL = ""
+	if c.FHIRTenant != "" {
+		c.OystehrFHIRBaseURL = c.TenantURL(c.FHIRTenant)
+	} else if c.ReferenceStyle == ReferenceStyleAbsolute {
+		// Created resources are built without the token, so the tenant must be known up front
+		return fmt.Errorf("REFERENCE_STYLE=absolute with FHIR_URL_TEMPLATE%s requires FHIR_TENANT%s", suffix, suffix)
+	}
+	return nil
+}
+
+// loadTargets fills cfg.Targets and cfg.TargetNames. Without FHIR_TARGETS there is a single
+// target, DefaultTarget, which is cfg itself. Otherwise each named target is a copy of cfg
+// whose Oystehr settings come from <VAR>_<NAME>, falling back to the unsuffixed <VAR>.
+func loadTargets(cfg *Config, names []string) error {
+	if len(names) == 0 {
+		cfg.TargetName = DefaultTarget
+		cfg.TargetNames = []string{DefaultTarget}
+		cfg.Targets = map[string]*Config{DefaultTarget: cfg}
+		return cfg.applyURLTemplate("")
+	}
+
+	targets := make(map[string]*Config, len(names))
+	for i, name := range names {
+		name = strings.ToLower(name)
+		if !targetNamePattern.MatchString(name) {
+			return fmt.Errorf("FHIR_TARGETS contains invalid target name %q (use a-z, 0-9, '-' or '_')", name)
+		}
+		if _, dup := targets[name]; dup {
+			return fmt.Errorf("FHIR_TARGETS lists target %q more than once", name)
+		}
+		names[i] = name
+
+		suffix := "_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		target := *cfg
+		target.TargetName = name
+		target.OystehrFHIRBaseURL = getEnvFallback("OYSTEHR_FHIR_BASE_URL"+suffix, cfg.OystehrFHIRBaseURL)
+		target.OystehrAuthURL = getEnvFallback("OYSTEHR_AUTH_URL"+suffix, cfg.OystehrAuthURL)
+		target.OystehrProjectID = getEnvFallback("OYSTEHR_PROJECT_ID"+suffix, cfg.OystehrProjectID)
+		target.FHIRURLTemplate = getEnvFallback("FHIR_URL_TEMPLATE"+suffix, cfg.FHIRURLTemplate)
+		target.FHIRTenant = getEnvFallback("FHIR_TENANT"+suffix, cfg.FHIRTenant)
+		target.OystehrM2MClientID = getEnvFallback("OYSTEHR_M2M_CLIENT_ID"+suffix, cfg.OystehrM2MClientID)
+		target.OystehrM2MClientSecret = getEnvFallback("OYSTEHR_M2M_CLIENT_SECRET"+suffix, cfg.OystehrM2MClientSecret)
+		target.AlertProviderFHIRID = getEnvFallback("ALERT_PROVIDER_FHIR_ID"+suffix, cfg.AlertProviderFHIRID)
+		if err := target.requireTarget(suffix); err != nil {
+			return fmt.Errorf("FHIR target %q: %w", name, err)
+		}
+		if err := target.applyURLTemplate(suffix); err != nil {
+			return fmt.Errorf("FHIR target %q: %w", name, err)
+		}
+		targets[name] = &target
+	}
+
+	// Every target can see its siblings (e.g. to list them in errors)
+	cfg.TargetNames, cfg.Targets = names, targets
+	for _, target := range targets {
+		target.TargetNames, target.Targets = names, targets
+	}
+	return nil
+}
+
+// getEnvFallback returns the environment variable, or fallback when it is unset or empty.
+func getEnvFallback(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// ResourceTimeout returns how long a create of resourceType may take, including retries.
+func (c *Config) ResourceTimeout(resourceType string) time.Duration {
+	if d, ok := c.ResourceTimeouts[resourceType]; ok {
+		return d
+	}
+	if c.FHIRTimeout > 0 {
+		return c.FHIRTimeout
+	}
+	return 15 * time.Second
+}
+
+// MaxFHIRTimeout returns the longest configured FHIR timeout, used as the HTTP client's
+// overall timeout so it never cuts a slower resource type short.
+func (c *Config) MaxFHIRTimeout() time.Duration {
+	longest := c.ResourceTimeout("")
+	for _, d := range c.ResourceTimeouts {
+		longest = max(longest, d)
+	}
+	return longest
+}
+
+// Now returns the current time from the injected Clock, defaulting to time.Now.
+func (c *Config) Now() time.Time {
+	if c.Clock != nil {
+		return c.Clock()
+	}
+	return time.Now()
+}
+
+// LoadScoringConfig reads only the scoring-related environment variables.
+// It does not require Oystehr credentials, so it can back offline tools like -score.
+func LoadScoringConfig() (*Config, error) {
+	cfg := &Config{}
+	if err := loadScoring(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadScoring populates the scoring settings of cfg from the environment.
+func loadScoring(cfg *Config) error {
+	defaults := scoring.DefaultRules()
+
+	var err error
+	if cfg.AnswerMin, err = getEnvInt("ANSWER_MIN", defaults.MinAnswer); err != nil {
+		return err
+	}
+	if cfg.AnswerMax, err = getEnvInt("ANSWER_MAX", defaults.MaxAnswer); err != nil {
+		return err
+	}
+	if cfg.AnswerMin > cfg.AnswerMax {
+		return fmt.Errorf("ANSWER_MIN (%d) must not be greater than ANSWER_MAX (%d)", cfg.AnswerMin, cfg.AnswerMax)
+	}
+	if cfg.StrictQ10, err = getEnvBool("STRICT_Q10", true); err != nil {
+		return err
+	}
+
+	if cfg.HighRiskThreshold, err = getEnvInt("HIGH_RISK_THRESHOLD", defaults.HighRiskThreshold); err != nil {
+		return err
+	}
+	cfg.HighRiskOperator = strings.ToLower(os.Getenv("HIGH_RISK_OPERATOR"))
+	switch cfg.HighRiskOperator {
+	case "":
+		cfg.HighRiskOperator = defaults.HighRiskOperator
+	case scoring.OperatorGTE, scoring.OperatorGT:
+	default:
+		return fmt.Errorf("HIGH_RISK_OPERATOR must be %q or %q, got %q", scoring.OperatorGTE, scoring.OperatorGT, cfg.HighRiskOperator)
+	}
+
+	cfg.ScoreBands = defaults.Bands
+	if v := os.Getenv("SCORE_BANDS"); v != "" {
+		if cfg.ScoreBands, err = scoring.ParseBands(v); err != nil {
+			return fmt.Errorf("invalid SCORE_BANDS: %w", err)
+		}
+	}
+	return nil
+}
+
+// ScoringRules returns the scoring rules derived from this configuration.
+func (c *Config) ScoringRules() scoring.Rules {
+	rules := scoring.DefaultRules()
+	rules.MinAnswer = c.AnswerMin
+	rules.MaxAnswer = c.AnswerMax
+	rules.HighRiskThreshold = c.HighRiskThreshold
+	rules.HighRiskOperator = c.HighRiskOperator
+	if c.ScoreBands != nil {
+		rules.Bands = c.ScoreBands
+	}
+	return rules
+}
+
+// Instrument returns the named instrument; an empty name is the EPDS. The EPDS uses the
+// configured scoring rules and QUESTION_CODES, the PHQ-9 its standard scale and cutoffs.
+func (c *Config) Instrument(name string) (Instrument, bool) {
+	switch strings.ToLower(name) {
+	case "", InstrumentEPDS:
+		codes := c.QuestionCodes
+		if codes == nil {
+			codes = DefaultQuestionCodes()
+		}
+		return Instrument{
+			Name:      InstrumentEPDS,
+			Display:   "EPDS",
+			TotalCode: ItemCode{"http://loinc.org", "99046-5", "Total score [EPDS]"},
+			ItemCodes: codes,
+			Rules:     c.ScoringRules(),
+		}, true
+	case InstrumentPHQ9:
+		return Instrument{
+			Name:      InstrumentPHQ9,
+			Display:   "PHQ-9",
+			TotalCode: ItemCode{"http://loinc.org", "44261-6", "Patient Health Questionnaire 9 item (PHQ-9) total score [Reported]"},
+			ItemCodes: DefaultPHQ9QuestionCodes(),
+			Rules:     scoring.PHQ9Rules(),
+		}, true
+	}
+	return Instrument{}, false
+}
+
+// UnscoredInstrumentSystem is the code system of the Observation.code of an unscored instrument.
+const UnscoredInstrumentSystem = "urn:cornell:epds:instrument"
+
+// UnscoredInstrument describes an instrument the service has no scoring rules for, so that
+// its answers can still be recorded (RECORD_UNSCORED_INSTRUMENTS). Its code is the name in
+// UnscoredInstrumentSystem; it has no item codes, and its zero Rules must not be used to score.
+func UnscoredInstrument(name string) Instrument {
+	name = strings.ToLower(name)
+	return Instrument{
+		Name:      name,
+		Display:   name,
+		TotalCode: ItemCode{UnscoredInstrumentSystem, name, ""},
+		Unscored:  true,
+	}
+}
+
+// parseQuestionCodes overrides the default question codes with comma-separated
+// "qN=system|code" entries (e.g. "q10=http://snomed.info/sct|225444004"). Questions not
+// listed keep their LOINC code; an overridden code has no display text.
+func parseQuestionCodes(v string) ([]ItemCode, error) {
+	codes := DefaultQuestionCodes()
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		question, coding, ok := strings.Cut(item, "=")
+		n, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(question), "q"))
+		if !ok || err != nil || n < 1 || n > len(codes) {
+			return nil, fmt.Errorf("QUESTION_CODES entry %q must have the form qN=system|code with N from 1 to %d", item, len(codes))
+		}
+		system, code, ok := strings.Cut(strings.TrimSpace(coding), "|")
+		if !ok || system == "" || code == "" {
+			return nil, fmt.Errorf("QUESTION_CODES entry %q must have the form qN=system|code", item)
+		}
+		codes[n-1] = ItemCode{System: system, Code: code}
+	}
+	return codes, nil
+}
+
+// parseHeaders parses comma-separated "Name=value" pairs (e.g. "x-tenant-region=us-east")
+// into headers. Headers the service sets itself are rejected so they cannot be overridden.
+func parseHeaders(v string) (http.Header, error) {
+	headers := http.Header{}
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || !headerNamePattern.MatchString(name) || value == "" || strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("EXTRA_FHIR_HEADERS entry %q must have the form Name=value", item)
+		}
+		name = textproto.CanonicalMIMEHeaderKey(name)
+		for _, reserved := range reservedFHIRHeaders {
+			if name == reserved {
+				return nil, fmt.Errorf("EXTRA_FHIR_HEADERS cannot set %s; the service manages it", name)
+			}
+		}
+		headers.Add(name, value)
+	}
+	return headers, nil
+}
+
+// loadMessages parses DefaultMessages overlaid with the optional JSON file at path, which
+// maps lowercase locales to message keys to templates, e.g. {"es": {"alert": "..."}}.
+func loadMessages(path string) (map[string]map[string]*template.Template, error) {
+	table := make(map[string]map[string]string)
+	for locale, messages := range DefaultMessages {
+		table[locale] = make(map[string]string)
+		for key, text := range messages {
+			table[locale][key] = text
+		}
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read LOCALIZED_MESSAGES_FILE: %w", err)
+		}
+		var custom map[string]map[string]string
+		if err := json.Unmarshal(data, &custom); err != nil {
+			return nil, fmt.Errorf("invalid LOCALIZED_MESSAGES_FILE: %w", err)
+		}
+		for locale, messages := range custom {
+			locale = strings.ToLower(locale)
+			if table[locale] == nil {
+				table[locale] = make(map[string]string)
+			}
+			for key, text := range messages {
+				table[locale][key] = text
+			}
+		}
+	}
+
+	parsed := make(map[string]map[string]*template.Template, len(table))
+	for locale, messages := range table {
+		parsed[locale] = make(map[string]*template.Template, len(messages))
+		for key, text := range messages {
+			switch key {
+			case MessageFlagCategory, MessageFlagCode, MessageAlert, MessageNegativeScreen, MessageReceipt:
+			default:
+				return nil, fmt.Errorf("LOCALIZED_MESSAGES_FILE: unknown message key %q for locale %q", key, locale)
+			}
+			tmpl, err := template.New(locale + "." + key).Parse(text)
+			if err != nil {
+				return nil, fmt.Errorf("LOCALIZED_MESSAGES_FILE: invalid %s.%s template: %w", locale, key, err)
+			}
+			parsed[locale][key] = tmpl
+		}
+	}
+	return parsed, nil
+}
+
+// getEnvBool reads an optional boolean environment variable, returning def when unset.
+func getEnvBool(key string, def bool) (bool, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("environment variable %s must be a boolean, got %q", key, v)
+	}
+	return b, nil
+}
+
+// getEnvList reads an optional comma-separated environment variable, returning def when unset.
+// Surrounding whitespace and empty items are dropped.
+func getEnvList(key string, def []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getEnvFloat reads an optional decimal environment variable, returning def when unset.
+func getEnvFloat(key string, def float64) (float64, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("environment variable %s must be a number, got %q", key, v)
+	}
+	return f, nil
+}
+
+// getEnvInt reads an optional integer environment variable, returning def when unset.
+func getEnvInt(key string, def int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("environment variable %s must be an integer, got %q", key, v)
+	}
+	return n, nil
+}
@@ -0,0 +1,173 @@
+package deadletter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kinds of side-effect that can be queued for retry.
+const (
+	KindFlag          = "flag"
+	KindCommunication = "communication"
+)
+
+// Entry is one failed high-risk side-effect, with everything needed to recreate it.
+type Entry struct {
+	ID          string    `json:"id"`
+	Kind        string    `json:"kind"`   // KindFlag or KindCommunication
+	Target      string    `json:"target"` // FHIR target name (X-EPDS-Env)
+	PatientID   string    `json:"patientId"`
+	EncounterID string    `json:"encounterId,omitempty"`
+	Instrument  string    `json:"instrument,omitempty"` // Screening instrument; empty is the EPDS
+	Locale      string    `json:"locale,omitempty"`     // Language of the provider-facing text
+	FlagReason  string    `json:"flagReason,omitempty"` // Flag code text from the scoring webhook
+	TotalScore  int       `json:"totalScore"`
+	Q10Score    int       `json:"q10Score"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// Queue durably stores failed side-effects until they are retried successfully.
+// Implementations must be safe for concurrent use; the file-backed queue is the first,
+// and the interface leaves room for shared backends (e.g. Redis or SQS).
+type Queue interface {
+	Enqueue(e Entry) error
+	Due(now time.Time) ([]Entry, error)
+	Update(e Entry) error
+	Remove(id string) error
+}
+
+// NewID returns a random identifier for a queue entry.
+func NewID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// FileQueue keeps the queue in memory and rewrites it to a JSON file on every change.
+// The file is replaced atomically, so a crash never leaves a partially written queue.
+type FileQueue struct {
+	mutex   sync.Mutex
+	path    string
+	entries map[string]Entry
+}
+
+// NewFileQueue opens the queue stored at path, creating it if it does not exist.
+func NewFileQueue(path string) (*FileQueue, error) {
+	q := &FileQueue{path: path, entries: make(map[string]Entry)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, q.save()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deadletter queue %s: %w", path, err)
+	}
+
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse deadletter queue %s: %w", path, err)
+	}
+	for _, e := range entries {
+		q.entries[e.ID] = e
+	}
+	return q, nil
+}
+
+// Enqueue adds e to the queue.
+func (q *FileQueue) Enqueue(e Entry) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.entries[e.ID] = e
+	return q.save()
+}
+
+// Due returns the entries whose NextAttempt is not after now, oldest first.
+func (q *FileQueue) Due(now time.Time) ([]Entry, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var due []Entry
+	for _, e := range q.entries {
+		if !e.NextAttempt.After(now) {
+			due = append(due, e)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].CreatedAt.Before(due[j].CreatedAt) })
+	return due, nil
+}
+
+// Update replaces the stored copy of e (e.g. after a failed attempt).
+func (q *FileQueue) Update(e Entry) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if _, ok := q.entries[e.ID]; !ok {
+		return fmt.Errorf("deadletter entry %s not found", e.ID)
+	}
+	q.entries[e.ID] = e
+	return q.save()
+}
+
+// Remove deletes the entry with the given id.
+func (q *FileQueue) Remove(id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	delete(q.entries, id)
+	return q.save()
+}
+
+// Len returns the number of queued entries.
+func (q *FileQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.entries)
+}
+
+// save writes all entries to a temporary file and renames it over the queue file.
+// Callers must hold q.mutex.
+func (q *FileQueue) save() error {
+	entries := make([]Entry, 0, len(q.entries))
+	for _, e := range q.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].CreatedAt.Before(entries[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal deadletter queue: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), ".deadletter-*")
+	if err != nil {
+		return fmt.Errorf("failed to write deadletter queue: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write deadletter queue: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync deadletter queue: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write deadletter queue: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return fmt.Errorf("failed to write deadletter queue: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), q.path); err != nil {
+		return fmt.Errorf("failed to replace deadletter queue %s: %w", q.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package deadletter
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Worker periodically retries due queue entries with exponential backoff until they
+// succeed or exceed MaxAge, at which point they are dropped with an error log.
+type Worker struct {
+	Queue    Queue
+	Process  func(Entry) error // Recreates the side-effect; nil error removes the entry
+	Interval time.Duration     // Poll interval and base retry delay
+	MaxAge   time.Duration     // Entries older than this are abandoned
+	Now      func() time.Time
+	// OnAbandon, when set, is called with each entry as it is abandoned (e.g. to escalate
+	// an alert that could not be delivered).
+	OnAbandon func(Entry)
+}
+
+// Run polls the queue every Interval until ctx is cancelled.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.RunOnce()
+		}
+	}
+}
+
+// RunOnce attempts every entry that is currently due.
+func (w *Worker) RunOnce() {
+	now := w.Now()
+	due, err := w.Queue.Due(now)
+	if err != nil {
+		log.Printf("ERROR: Failed to read deadletter queue: %v", err)
+		return
+	}
+
+	for _, e := range due {
+		err := w.Process(e)
+		if err == nil {
+			log.Printf("Deadletter retry succeeded for %s %s (patient %s) after %d attempts", e.Kind, e.ID, e.PatientID, e.Attempts+1)
+			if err := w.Queue.Remove(e.ID); err != nil {
+				log.Printf("ERROR: Failed to remove deadletter entry %s: %v", e.ID, err)
+			}
+			continue
+		}
+
+		e.Attempts++
+		e.LastError = err.Error()
+		if now.Sub(e.CreatedAt) >= w.MaxAge {
+			log.Printf("ERROR: *** Abandoning high-risk %s %s for patient %s after %d attempts over %s: %v ***", e.Kind, e.ID, e.PatientID, e.Attempts, w.MaxAge, err)
+			if err := w.Queue.Remove(e.ID); err != nil {
+				log.Printf("ERROR: Failed to remove deadletter entry %s: %v", e.ID, err)
+			}
+			if w.OnAbandon != nil {
+				w.OnAbandon(e)
+			}
+			continue
+		}
+
+		e.NextAttempt = now.Add(w.backoff(e.Attempts))
+		log.Printf("Deadletter retry %d failed for %s %s (patient %s), next attempt at %s: %v", e.Attempts, e.Kind, e.ID, e.PatientID, e.NextAttempt.Format(time.RFC3339), err)
+		if err := w.Queue.Update(e); err != nil {
+			log.Printf("ERROR: Failed to update deadletter entry %s: %v", e.ID, err)
+		}
+	}
+}
+
+// backoff doubles the delay per attempt, capped at one hour.
+func (w *Worker) backoff(attempts int) time.Duration {
+	d := w.Interval
+	for i := 1; i < attempts && d < time.Hour; i++ {
+		d *= 2
+	}
+	return min(d, time.Hour)
+}
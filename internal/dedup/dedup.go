@@ -0,0 +1,67 @@
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache remembers values by key for a fixed window, so identical resubmissions can be
+// answered with the earlier result instead of recreating resources. It is in-memory only:
+// entries are lost on restart and are not shared between replicas.
+type Cache[V any] struct {
+	mutex   sync.Mutex
+	window  time.Duration
+	now     func() time.Time
+	entries map[string]entry[V]
+}
+
+type entry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// New creates a cache whose entries expire window after they are stored.
+func New[V any](window time.Duration, now func() time.Time) *Cache[V] {
+	return &Cache[V]{window: window, now: now, entries: make(map[string]entry[V])}
+}
+
+// Get returns the value stored under key if it has not expired.
+func (c *Cache[V]) Get(key string) (V, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, ok := c.entries[key]
+	if !ok || !c.now().Before(e.expires) {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+// Put stores value under key and drops any expired entries.
+func (c *Cache[V]) Put(key string, value V) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.now()
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = entry[V]{value: value, expires: now.Add(c.window)}
+}
+
+// Delete removes the value stored under key, if any.
+func (c *Cache[V]) Delete(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.entries, key)
+}
+
+// Key returns the hex SHA-256 of parts, separated so that ("ab","c") and ("a","bc") differ.
+func Key(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,37 @@
+package fhir
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Bundle is a minimal FHIR Bundle used to return the resources a submission produced.
+type Bundle struct {
+	ResourceType string        `json:"resourceType"`
+	Type         string        `json:"type"`
+	Timestamp    string        `json:"timestamp"`
+	Entry        []BundleEntry `json:"entry"`
+}
+
+// BundleEntry holds a single resource within a Bundle.
+type BundleEntry struct {
+	Resource json.RawMessage `json:"resource"`
+}
+
+// NewCollectionBundle wraps the given created resources in a Bundle of type "collection".
+// Entries without a resource body are skipped.
+func NewCollectionBundle(resources ...Created) Bundle {
+	b := Bundle{
+		ResourceType: "Bundle",
+		Type:         "collection",
+		Timestamp:    time.Now().Format(time.RFC3339),
+		Entry:        []BundleEntry{},
+	}
+	for _, r := range resources {
+		if len(r.Resource) == 0 {
+			continue
+		}
+		b.Entry = append(b.Entry, BundleEntry{Resource: r.Resource})
+	}
+	return b
+}
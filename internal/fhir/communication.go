@@ -2,14 +2,14 @@ package fhir
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"time"
+	"strconv"
+	"text/template"
 
 	"example.com/epds-service/internal/config"
+	"example.com/epds-service/internal/scoring"
 )
 
 // fhirCommunication represents the structure needed to create the Communication resource.
@@ -20,8 +20,26 @@ type fhirCommunication struct {
 	Category     []fhirCategory  `json:"category"`  // Reusing from observation.go
 	Subject      fhirReference   `json:"subject"`   // Reusing from observation.go
 	Recipient    []fhirReference `json:"recipient"` // Reusing fhirReference
+	Sender       *fhirReference  `json:"sender,omitempty"`
+	Priority     string          `json:"priority,omitempty"`
+	Medium       []fhirCategory  `json:"medium,omitempty"`
 	Payload      []fhirPayload   `json:"payload"`
-	Sent         string          `json:"sent"`
+	Sent         string          `json:"sent,omitempty"` // Omitted while in preparation
+	Meta         *fhirMeta       `json:"meta,omitempty"` // Defined in flag.go
+}
+
+// Communication meta.tag codes (in urn:cornell:epds:tags), used to find recent ones of a kind.
+const (
+	alertCommunicationTag    = "epds-alert"
+	negativeCommunicationTag = "epds-negative-screen"
+)
+
+// communicationTag returns the meta.tag code of a high-risk alert or negative-screen Communication.
+func communicationTag(negativeScreen bool) string {
+	if negativeScreen {
+		return negativeCommunicationTag
+	}
+	return alertCommunicationTag
 }
 
 type fhirPayload struct {
@@ -31,18 +49,111 @@ type fhirPayload struct {
 // Note: fhirCategory, fhirCoding, fhirReference, and createdResource are assumed
 // to be defined in the same package (e.g., in observation.go or flag.go).
 
+// AlertMessageData is the data available to ALERT_MESSAGE_TEMPLATE.
+type AlertMessageData struct {
+	Instrument    string // Display name of the instrument, e.g. "EPDS" or "PHQ-9"
+	SelfHarmItem  int    // Number of the self-harm item (10 on the EPDS, 9 on the PHQ-9)
+	TotalScore    int
+	Q10Score      int  // Self-harm item score; scoring.Q10Unanswered when Q10Unanswered is set
+	Q10Unanswered bool // The self-harm item was not answered (STRICT_Q10=false)
+	PatientID     string
+	ProviderID    string
+}
+
+// newAlertMessageData returns the template data for a screen's alert text. An unknown
+// instrument is described as the EPDS.
+func newAlertMessageData(cfg *config.Config, instrument, patientID, providerID string, totalScore, q10Score int) AlertMessageData {
+	inst, ok := cfg.Instrument(instrument)
+	if !ok {
+		inst, _ = cfg.Instrument(config.InstrumentEPDS)
+	}
+	return AlertMessageData{
+		Instrument:    inst.Display,
+		SelfHarmItem:  inst.Rules.SelfHarmIndex() + 1,
+		TotalScore:    totalScore,
+		Q10Score:      q10Score,
+		Q10Unanswered: q10Score == scoring.Q10Unanswered,
+		PatientID:     patientID,
+		ProviderID:    providerID,
+	}
+}
+
+// q10Text formats the self-harm item score for the default English messages.
+func q10Text(data AlertMessageData) string {
+	if data.Q10Unanswered {
+		return "unanswered"
+	}
+	return strconv.Itoa(data.Q10Score)
+}
+
+// CommunicationOptions selects the kind of Communication created.
+type CommunicationOptions struct {
+	// NegativeScreen creates a routine-priority notification documenting a negative
+	// screen (NEGATIVE_SCREEN_MESSAGE_TEMPLATE) instead of the high-risk alert.
+	NegativeScreen bool
+
+	// Locale selects the payload language from the configured message table
+	// (e.g. "es"); empty or unknown locales use English.
+	Locale string
+
+	// Instrument names the screening instrument (config.InstrumentNames); empty is the EPDS.
+	Instrument string
+}
+
+// alertMessage renders the Communication payload text in locale, using the configured
+// template when present and falling back to the default wording otherwise.
+func alertMessage(cfg *config.Config, locale string, data AlertMessageData) string {
+	return localizedMessage(cfg, locale, config.MessageAlert, data, renderMessage(cfg.AlertMessageTemplate, "ALERT_MESSAGE_TEMPLATE", data,
+		fmt.Sprintf("Alert: High %s score (%d) recorded for Patient %s. Q%d Score: %s. Please review patient chart.", data.Instrument, data.TotalScore, data.PatientID, data.SelfHarmItem, q10Text(data))))
+}
+
+// negativeScreenMessage renders the payload text for a negative-screen Communication.
+func negativeScreenMessage(cfg *config.Config, locale string, data AlertMessageData) string {
+	return localizedMessage(cfg, locale, config.MessageNegativeScreen, data, renderMessage(cfg.NegativeScreenMessageTemplate, "NEGATIVE_SCREEN_MESSAGE_TEMPLATE", data,
+		fmt.Sprintf("%s screening completed for Patient %s. Score: %d (negative screen). Q%d Score: %d. No action required.", data.Instrument, data.PatientID, data.TotalScore, data.SelfHarmItem, data.Q10Score)))
+}
+
+// localizedMessage renders the locale's translation of key, returning english (the
+// default-locale wording) when the locale has none.
+func localizedMessage(cfg *config.Config, locale, key string, data AlertMessageData, english string) string {
+	if tmpl := cfg.Messages[locale][key]; tmpl != nil {
+		return renderMessage(tmpl, "LOCALIZED_MESSAGES_FILE "+tmpl.Name(), data, english)
+	}
+	return english
+}
+
+// renderMessage executes tmpl with data, returning fallback if tmpl is nil or fails.
+func renderMessage(tmpl *template.Template, name string, data AlertMessageData, fallback string) string {
+	if tmpl != nil {
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, data)
+		if err == nil {
+			return buf.String()
+		}
+		log.Printf("Warning: failed to render %s, using default message: %v", name, err)
+	}
+	return fallback
+}
+
 // CreateCommunication sends a POST request to the Oystehr FHIR API to create a Communication resource.
-// It returns the ID of the created Communication or an error.
-func CreateCommunication(httpClient *http.Client, cfg *config.Config, token string, patientID string, providerID string, totalScore int, q10Score int) (string, error) {
-	// Use a default client if none is provided
-	if httpClient == nil {
-		httpClient = &http.Client{Timeout: 15 * time.Second}
+// By default it creates the high-risk provider alert; see CommunicationOptions for the
+// negative-screen variant. It returns the created Communication or an error.
+func CreateCommunication(httpClient *http.Client, cfg *config.Config, token string, patientID string, providerID string, totalScore int, q10Score int, opts CommunicationOptions) (Created, error) {
+	if _, err := buildReference("Patient", patientID); err != nil {
+		return Created{}, fmt.Errorf("cannot create Communication: %w", err)
 	}
+	comm := newCommunication(cfg, patientID, providerID, totalScore, q10Score, opts)
+	return createResource(httpClient, cfg, token, "Communication", comm, patientID)
+}
+
+// newCommunication builds the Communication payload (see CreateCommunication).
+func newCommunication(cfg *config.Config, patientID string, providerID string, totalScore int, q10Score int, opts CommunicationOptions) fhirCommunication {
+	data := newAlertMessageData(cfg, opts.Instrument, patientID, providerID, totalScore, q10Score)
 
 	// Construct the FHIR Communication payload
 	comm := fhirCommunication{
 		ResourceType: "Communication",
-		Status:       "completed",
+		Status:       cfg.CommunicationStatus,
 		Category: []fhirCategory{{
 			Coding: []fhirCoding{{
 				System:  "http://terminology.hl7.org/CodeSystem/communication-category",
@@ -50,69 +161,42 @@ func CreateCommunication(httpClient *http.Client, cfg *config.Config, token stri
 				Display: "Alert",
 			}},
 		}},
-		Subject:   fhirReference{Reference: fmt.Sprintf("Patient/%s", patientID)},
-		Recipient: []fhirReference{{Reference: providerID}}, // Use providerID from config
-		Payload: []fhirPayload{{
-			ContentString: fmt.Sprintf("Alert: High EPDS score (%d) recorded for Patient %s. Q10 Score: %d. Please review patient chart.", totalScore, patientID, q10Score),
-		}},
-		Sent: time.Now().Format(time.RFC3339), // ISO8601 Format
+		Subject:   reference(cfg, "Patient", patientID),
+		Recipient: []fhirReference{styledReference(cfg, providerID)}, // Use providerID from config
+		Payload:   []fhirPayload{{ContentString: alertMessage(cfg, opts.Locale, data)}},
+		Sent:      fhirDateTime(cfg, cfg.Now()), // ISO8601 Format
+		Meta:      &fhirMeta{Tag: []fhirCoding{{System: "urn:cornell:epds:tags", Code: communicationTag(opts.NegativeScreen)}}},
 	}
 
-	commBytes, err := json.Marshal(comm)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal FHIR Communication JSON: %w", err)
+	// Identify the alert's origin in the provider inbox
+	if cfg.CommunicationSender != "" {
+		comm.Sender = refPtr(styledReference(cfg, cfg.CommunicationSender))
 	}
 
-	// Construct the request URL
-	url := cfg.OystehrFHIRBaseURL + "/Communication"
-
-	// Create the HTTP request
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(commBytes))
-	if err != nil {
-		return "", fmt.Errorf("failed to create FHIR Communication request: %w", err)
-	}
-
-	// Set required headers
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("x-zapehr-project-id", cfg.OystehrProjectID)
-	req.Header.Set("Content-Type", "application/fhir+json")
-	req.Header.Set("Accept", "application/fhir+json")
-
-	// Execute the request
-	log.Printf("Sending POST request to %s to create Communication for Patient %s", url, patientID)
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to execute FHIR Communication request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	bodyBytes, readErr := io.ReadAll(resp.Body)
-	if readErr != nil {
-		log.Printf("Warning: failed to read response body after status %d for Communication creation: %v", resp.StatusCode, readErr)
+	// A Communication still in preparation has not been sent
+	if cfg.CommunicationStatus == config.CommunicationStatusPreparation {
+		comm.Sent = ""
 	}
 
-	// Check response status code
-	if resp.StatusCode != http.StatusCreated { // 201 Created
-		errBody := string(bodyBytes)
-		if errBody == "" && readErr != nil {
-			errBody = fmt.Sprintf("(could not read body: %v)", readErr)
-		}
-		log.Printf("ERROR: FHIR Communication creation failed. Status: %d, Body: %s", resp.StatusCode, errBody)
-		return "", fmt.Errorf("FHIR API error creating Communication (status %d): %s", resp.StatusCode, errBody)
-	}
-
-	// Parse the response body to get the created resource ID
-	var createdComm createdResource // Reusing the struct from observation.go
-	if err := json.Unmarshal(bodyBytes, &createdComm); err != nil {
-		log.Printf("ERROR: Failed to unmarshal FHIR Communication response body: %s. Error: %v", string(bodyBytes), err)
-		return "", fmt.Errorf("failed to parse FHIR Communication response body: %w", err)
+	// A negative screen is a routine notification rather than an alert
+	if opts.NegativeScreen {
+		comm.Category[0].Coding[0].Code = "notification"
+		comm.Category[0].Coding[0].Display = "Notification"
+		comm.Priority = "routine"
+		comm.Payload[0].ContentString = negativeScreenMessage(cfg, opts.Locale, data)
 	}
 
-	if createdComm.ID == "" {
-		log.Printf("ERROR: FHIR Communication created (201) but response did not contain an ID. Body: %s", string(bodyBytes))
-		return "", fmt.Errorf("FHIR Communication created but response missing ID")
+	// Add the configured delivery medium (drives downstream routing)
+	for _, code := range cfg.CommunicationMedium {
+		comm.Medium = append(comm.Medium, fhirCategory{
+			Coding: []fhirCoding{{
+				System:  "http://terminology.hl7.org/CodeSystem/v3-ParticipationMode",
+				Code:    code,
+				Display: config.ParticipationModes[code],
+			}},
+		})
 	}
+	comm.Meta = withProgramTag(cfg, comm.Meta)
 
-	log.Printf("Successfully created FHIR Communication with ID: %s for Patient %s", createdComm.ID, patientID)
-	return createdComm.ID, nil
+	return comm
 }
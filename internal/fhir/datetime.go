@@ -0,0 +1,32 @@
+package fhir
+
+import (
+	"time"
+
+	"example.com/epds-service/internal/config"
+)
+
+// fhirDateTime formats t as a FHIR dateTime (RFC3339 with offset) in the configured
+// clinic timezone, so chart timestamps are correct regardless of the container's zone.
+func fhirDateTime(cfg *config.Config, t time.Time) string {
+	return t.In(clinicLocation(cfg)).Format(time.RFC3339)
+}
+
+// fhirDate formats t as a FHIR date (YYYY-MM-DD) in the configured clinic timezone.
+func fhirDate(cfg *config.Config, t time.Time) string {
+	return t.In(clinicLocation(cfg)).Format(time.DateOnly)
+}
+
+// EffectiveDate returns today's date in the clinic timezone, i.e. the date a submission
+// made now would be charted under.
+func EffectiveDate(cfg *config.Config) string {
+	return fhirDate(cfg, cfg.Now())
+}
+
+// clinicLocation returns OBSERVATION_TIMEZONE, or the process-local zone (which honors TZ).
+func clinicLocation(cfg *config.Config) *time.Location {
+	if cfg.ObservationLocation != nil {
+		return cfg.ObservationLocation
+	}
+	return time.Local
+}
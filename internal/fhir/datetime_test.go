@@ -0,0 +1,41 @@
+package fhir
+
+import (
+	"testing"
+	"time"
+)
+
+func TestObservationTimestamps(t *testing.T) {
+	// 02:30 UTC is still the previous evening in New York
+	now := time.Date(2026, 10, 16, 2, 30, 5, 123456789, time.UTC)
+
+	tests := []struct {
+		name         string
+		timezone     string
+		wantDateTime string
+		wantDate     string
+	}{
+		{"UTC", "UTC", "2026-10-16T02:30:05Z", "2026-10-16"},
+		{"behind UTC, previous day", "America/New_York", "2026-10-15T22:30:05-04:00", "2026-10-15"},
+		{"ahead of UTC", "Asia/Kolkata", "2026-10-16T08:00:05+05:30", "2026-10-16"},
+		{"summer time", "Europe/Berlin", "2026-10-16T04:30:05+02:00", "2026-10-16"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OBSERVATION_TIMEZONE", tt.timezone)
+			cfg := testConfig(t, "https://fhir.example.com/r4")
+			cfg.Clock = func() time.Time { return now }
+
+			obs := newObservation(cfg, "p1", 14, ObservationOptions{})
+			if obs.EffectiveDateTime != tt.wantDateTime {
+				t.Errorf("effectiveDateTime = %q, want %q", obs.EffectiveDateTime, tt.wantDateTime)
+			}
+			if _, err := time.Parse(time.RFC3339, obs.EffectiveDateTime); err != nil {
+				t.Errorf("effectiveDateTime %q is not RFC3339: %v", obs.EffectiveDateTime, err)
+			}
+			if got := EffectiveDate(cfg); got != tt.wantDate {
+				t.Errorf("EffectiveDate = %q, want %q", got, tt.wantDate)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package fhir
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"example.com/epds-service/internal/config"
+)
+
+// fhirDocumentReference represents an uploaded document (e.g. a scanned consent form)
+// stored alongside the EPDS Observation.
+type fhirDocumentReference struct {
+	ResourceType string           `json:"resourceType"`
+	Status       string           `json:"status"`
+	Type         fhirCode         `json:"type"`
+	Subject      fhirReference    `json:"subject"`
+	Date         string           `json:"date"`
+	Content      []fhirDocContent `json:"content"`
+	Context      *fhirDocContext  `json:"context,omitempty"`
+}
+
+type fhirDocContent struct {
+	Attachment fhirAttachment `json:"attachment"`
+}
+
+type fhirAttachment struct {
+	ContentType string `json:"contentType"`
+	Data        string `json:"data"` // base64-encoded
+	Title       string `json:"title,omitempty"`
+	Size        int    `json:"size"`
+}
+
+type fhirDocContext struct {
+	Related []fhirReference `json:"related,omitempty"`
+}
+
+// CreateDocumentReference stores an uploaded file as a DocumentReference with an inline
+// attachment, linked to the patient and (when given) the EPDS Observation.
+// It returns the created DocumentReference or an error.
+func CreateDocumentReference(httpClient *http.Client, cfg *config.Config, token string, patientID string, observationID string, filename string, contentType string, data []byte) (Created, error) {
+	if _, err := buildReference("Patient", patientID); err != nil {
+		return Created{}, fmt.Errorf("cannot create DocumentReference: %w", err)
+	}
+	if _, err := buildReference("Observation", observationID); err != nil {
+		return Created{}, fmt.Errorf("cannot create DocumentReference: %w", err)
+	}
+	doc := fhirDocumentReference{
+		ResourceType: "DocumentReference",
+		Status:       "current",
+		Type: fhirCode{
+			Coding: []fhirCoding{},
+			Text:   "EPDS submission attachment",
+		},
+		Subject: reference(cfg, "Patient", patientID),
+		Date:    fhirDateTime(cfg, cfg.Now()),
+		Content: []fhirDocContent{{
+			Attachment: fhirAttachment{
+				ContentType: contentType,
+				Data:        base64.StdEncoding.EncodeToString(data),
+				Title:       filename,
+				Size:        len(data),
+			},
+		}},
+	}
+	if observationID != "" {
+		doc.Context = &fhirDocContext{Related: []fhirReference{reference(cfg, "Observation", observationID)}}
+	}
+
+	return createResource(httpClient, cfg, token, "DocumentReference", doc, patientID)
+}
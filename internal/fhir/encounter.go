@@ -0,0 +1,111 @@
+package fhir
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"example.com/epds-service/internal/config"
+)
+
+// fhirEncounter represents the minimal Encounter created when no active visit exists.
+type fhirEncounter struct {
+	ResourceType string        `json:"resourceType"`
+	Status       string        `json:"status"`
+	Class        fhirCoding    `json:"class"`
+	Subject      fhirReference `json:"subject"`
+	Period       fhirPeriod    `json:"period"`
+}
+
+type fhirPeriod struct {
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+}
+
+// CreateEncounter sends a POST request to the Oystehr FHIR API to create a minimal
+// in-progress Encounter of AUTO_ENCOUNTER_CLASS for the patient, so a Flag can be linked to it.
+// It returns the created Encounter or an error.
+func CreateEncounter(httpClient *http.Client, cfg *config.Config, token string, patientID string) (Created, error) {
+	if _, err := buildReference("Patient", patientID); err != nil {
+		return Created{}, fmt.Errorf("cannot create Encounter: %w", err)
+	}
+	// Use a default client if none is provided
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 15 * time.Second}
+	}
+
+	// Construct the FHIR Encounter payload
+	enc := fhirEncounter{
+		ResourceType: "Encounter",
+		Status:       "in-progress",
+		Class: fhirCoding{
+			System:  "http://terminology.hl7.org/CodeSystem/v3-ActCode",
+			Code:    cfg.AutoEncounterClass.Code,
+			Display: cfg.AutoEncounterClass.Display,
+		},
+		Subject: reference(cfg, "Patient", patientID),
+		Period:  fhirPeriod{Start: fhirDateTime(cfg, cfg.Now())},
+	}
+
+	encBytes, err := marshalResource(cfg, enc)
+	if err != nil {
+		return Created{}, fmt.Errorf("failed to marshal FHIR Encounter JSON: %w", err)
+	}
+
+	// Construct the request URL
+	url := baseURL(cfg, token) + "/Encounter"
+
+	// Create the HTTP request
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(encBytes))
+	if err != nil {
+		return Created{}, fmt.Errorf("failed to create FHIR Encounter request: %w", err)
+	}
+
+	req, cancel := withResourceTimeout(req, cfg, "Encounter")
+	defer cancel()
+
+	// Set required headers
+	setFHIRHeaders(req, cfg, token)
+
+	// Execute the request
+	log.Printf("Sending POST request to %s to create Encounter for Patient %s", url, patientID)
+	resp, err := doRequest(httpClient, req)
+	if err != nil {
+		return Created{}, fmt.Errorf("failed to execute FHIR Encounter request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, readErr := io.ReadAll(resp.Body)
+	if readErr != nil {
+		log.Printf("Warning: failed to read response body after status %d for Encounter creation: %v", resp.StatusCode, readErr)
+	}
+
+	// Check response status code
+	if !createSucceeded(resp.StatusCode) {
+		errBody := string(bodyBytes)
+		if errBody == "" && readErr != nil {
+			errBody = fmt.Sprintf("(could not read body: %v)", readErr)
+		}
+		log.Printf("ERROR: FHIR Encounter creation failed. Status: %d, Body: %s", resp.StatusCode, errBody)
+		return Created{}, &CreateError{ResourceType: "Encounter", StatusCode: resp.StatusCode, Request: encBytes, Response: errBody}
+	}
+
+	// Parse the response body to get the created resource ID
+	var createdEnc createdResource
+	if err := json.Unmarshal(bodyBytes, &createdEnc); err != nil {
+		log.Printf("ERROR: Failed to unmarshal FHIR Encounter response body: %s. Error: %v", string(bodyBytes), err)
+		return Created{}, fmt.Errorf("failed to parse FHIR Encounter response body: %w", err)
+	}
+
+	if createdEnc.ID == "" {
+		log.Printf("ERROR: FHIR Encounter created (%d) but response did not contain an ID. Body: %s", resp.StatusCode, string(bodyBytes))
+		return Created{}, fmt.Errorf("FHIR Encounter created but response missing ID")
+	}
+
+	log.Printf("Successfully created FHIR Encounter with ID: %s for Patient %s", createdEnc.ID, patientID)
+	return Created{ID: createdEnc.ID, Resource: bodyBytes}, nil
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"example.com/epds-service/internal/config"
+	"example.com/epds-service/internal/scoring"
 )
 
 // fhirFlag represents the structure needed to create the Flag resource.
@@ -22,6 +23,15 @@ type fhirFlag struct {
 	Subject      fhirReference  `json:"subject"`             // Reusing from observation.go (implicitly)
 	Encounter    *fhirReference `json:"encounter,omitempty"` // Add Encounter field
 	Meta         *fhirMeta      `json:"meta,omitempty"`      // Add Meta field
+
+	// Severity as the standard flag-priority extension (see flagSeverity)
+	Extension []fhirExtension `json:"extension,omitempty"`
+}
+
+// fhirExtension is an extension carrying a CodeableConcept value.
+type fhirExtension struct {
+	URL                  string       `json:"url"`
+	ValueCodeableConcept fhirCategory `json:"valueCodeableConcept"`
 }
 
 // fhirMeta defines the structure for the meta field, including tags.
@@ -30,17 +40,42 @@ type fhirMeta struct {
 	Tag []fhirCoding `json:"tag,omitempty"`
 }
 
+// programTagSystem is the meta.tag system of PROGRAM_ID.
+const programTagSystem = "urn:cornell:epds:program"
+
+// withProgramTag returns meta (which may be nil) with the PROGRAM_ID tag added, so one
+// _tag search retrieves every resource of a screening program or study. meta is
+// returned unchanged when no program is configured.
+func withProgramTag(cfg *config.Config, meta *fhirMeta) *fhirMeta {
+	if cfg.ProgramID == "" {
+		return meta
+	}
+	if meta == nil {
+		meta = &fhirMeta{}
+	}
+	meta.Tag = append(meta.Tag, fhirCoding{System: programTagSystem, Code: cfg.ProgramID, Display: "EPDS Screening Program"})
+	return meta
+}
+
 // Note: fhirCategory, fhirCoding, fhirCode, fhirReference, and createdResource are assumed
 // to be defined in the same package (e.g., in observation.go or a common types file).
 // If they are not accessible, they would need to be redefined or imported.
 
 // CreateFlag sends a POST request to the Oystehr FHIR API to create a Flag resource.
-// It returns the ID of the created Flag or an error.
-func CreateFlag(httpClient *http.Client, cfg *config.Config, token string, patientID string, encounterID string, totalScore int, q10Score int) (string, error) {
-	// Use a default client if none is provided
-	if httpClient == nil {
-		httpClient = &http.Client{Timeout: 15 * time.Second}
+// The category and code text name the instrument (see CommunicationOptions.Instrument)
+// and are rendered in locale (see CommunicationOptions.Locale). A non-empty reason (from the
+// scoring webhook) replaces the code text. It returns the created Flag or an error.
+func CreateFlag(httpClient *http.Client, cfg *config.Config, token string, patientID string, encounterID string, totalScore int, q10Score int, instrument string, locale string, reason string) (Created, error) {
+	if _, err := buildReference("Patient", patientID); err != nil {
+		return Created{}, fmt.Errorf("cannot create Flag: %w", err)
 	}
+	flag := newFlag(cfg, patientID, encounterID, totalScore, q10Score, instrument, locale, reason)
+	return createResource(httpClient, cfg, token, "Flag", flag, patientID)
+}
+
+// newFlag builds the high-risk Flag payload (see CreateFlag).
+func newFlag(cfg *config.Config, patientID string, encounterID string, totalScore int, q10Score int, instrument string, locale string, reason string) fhirFlag {
+	data := newAlertMessageData(cfg, instrument, patientID, cfg.AlertProviderFHIRID, totalScore, q10Score)
 
 	// Construct the FHIR Flag payload
 	flag := fhirFlag{
@@ -52,14 +87,18 @@ func CreateFlag(httpClient *http.Client, cfg *config.Config, token string, patie
 				Code:    "safety",
 				Display: "Safety",
 			}},
-			Text: "High EPDS Score or Self-Harm Risk Reported", // Adding text as per example
+			Text: localizedMessage(cfg, locale, config.MessageFlagCategory, data, fmt.Sprintf("High %s Score or Self-Harm Risk Reported", data.Instrument)), // Adding text as per example
 		}},
 		Code: fhirCode{
 			Coding: []fhirCoding{}, // Add empty coding slice
 			// No specific coding provided in PRD Appendix A.2, only text
-			Text: fmt.Sprintf("High EPDS Score (%d) or Q10 Risk (%d) indicated.", totalScore, q10Score),
+			Text: localizedMessage(cfg, locale, config.MessageFlagCode, data, fmt.Sprintf("High %s Score (%d) or Q%d Risk (%s) indicated.", data.Instrument, totalScore, data.SelfHarmItem, q10Text(data))),
 		},
-		Subject: fhirReference{Reference: fmt.Sprintf("Patient/%s", patientID)},
+		Subject: reference(cfg, "Patient", patientID),
+	}
+	// The external scoring service explains its own determination
+	if reason != "" {
+		flag.Code.Text = reason
 	}
 
 	// Add Meta tag
@@ -71,66 +110,117 @@ func CreateFlag(httpClient *http.Client, cfg *config.Config, token string, patie
 		}},
 	}
 
+	// Record the instrument, so the Flag can be judged against its own scoring rules later
+	if inst, ok := cfg.Instrument(instrument); ok {
+		flag.Meta.Tag = append(flag.Meta.Tag, fhirCoding{System: InstrumentTagSystem, Code: inst.Name})
+	}
+
+	// Add the severity, for EHRs that color-code or sort banners, as a meta.tag and the
+	// standard flag-priority extension
+	if severity := flagSeverity(cfg, instrument, totalScore, q10Score); severity != "" {
+		flag.Meta.Tag = append(flag.Meta.Tag, fhirCoding{System: SeverityTagSystem, Code: severity})
+		flag.Extension = append(flag.Extension, fhirExtension{
+			URL: "http://hl7.org/fhir/StructureDefinition/flag-priority",
+			ValueCodeableConcept: fhirCategory{Coding: []fhirCoding{{
+				System: "http://terminology.hl7.org/CodeSystem/flag-priority-code",
+				Code:   config.FlagPriorityCodes[severity],
+			}}, Text: severity},
+		})
+	}
+
 	// Add Encounter if encounterID is provided
 	if encounterID != "" {
-		flag.Encounter = &fhirReference{Reference: fmt.Sprintf("Encounter/%s", encounterID)}
+		flag.Encounter = refPtr(reference(cfg, "Encounter", encounterID))
 	}
+	flag.Meta = withProgramTag(cfg, flag.Meta)
 
-	flagBytes, err := json.Marshal(flag)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal FHIR Flag JSON: %w", err)
-	}
+	return flag
+}
 
-	// Construct the request URL
-	url := cfg.OystehrFHIRBaseURL + "/Flag"
+// SeverityTagSystem is the meta.tag system carrying a Flag's severity (FLAG_SEVERITY_MAP).
+const SeverityTagSystem = "urn:cornell:epds:severity"
 
-	// Create the HTTP request
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(flagBytes))
-	if err != nil {
-		return "", fmt.Errorf("failed to create FHIR Flag request: %w", err)
+// InstrumentTagSystem is the meta.tag system carrying the instrument a Flag was raised for
+// (e.g. "epds", "phq9").
+const InstrumentTagSystem = "urn:cornell:epds:instrument"
+
+// flagSeverity returns the Flag severity for a screen: FlagSeveritySelfHarm when the
+// self-harm item is positive or unanswered, otherwise FLAG_SEVERITY_MAP for the band of
+// the total ("" when the band is not mapped).
+func flagSeverity(cfg *config.Config, instrument string, totalScore int, q10Score int) string {
+	inst, ok := cfg.Instrument(instrument)
+	if !ok {
+		inst, _ = cfg.Instrument(config.InstrumentEPDS)
+	}
+	if q10Score == scoring.Q10Unanswered || q10Score >= inst.Rules.Q10Threshold {
+		return config.FlagSeveritySelfHarm
 	}
+	return cfg.FlagSeverities[inst.Rules.Interpret(totalScore).Code]
+}
 
-	// Set required headers
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("x-zapehr-project-id", cfg.OystehrProjectID)
-	req.Header.Set("Content-Type", "application/fhir+json")
-	req.Header.Set("Accept", "application/fhir+json")
+// ResolveFlag sets an active Flag inactive, closing its period at the current time. The
+// Flag is read and written back whole (with If-Match on its version when the server
+// reports one), so fields set by others are kept and a concurrent update fails the write.
+func ResolveFlag(httpClient *http.Client, cfg *config.Config, token string, flagID string) error {
+	ref, err := buildReference("Flag", flagID)
+	if err != nil {
+		return fmt.Errorf("cannot resolve Flag: %w", err)
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 15 * time.Second}
+	}
+	url := baseURL(cfg, token) + "/" + ref
 
-	// Execute the request
-	log.Printf("Sending POST request to %s to create Flag for Patient %s", url, patientID)
-	resp, err := httpClient.Do(req)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to execute FHIR Flag request: %w", err)
+		return fmt.Errorf("failed to create FHIR Flag read request: %w", err)
+	}
+	setFHIRHeaders(req, cfg, token)
+	resp, err := doRequest(httpClient, req)
+	if err != nil {
+		return fmt.Errorf("failed to read FHIR Flag %s: %w", flagID, err)
 	}
 	defer resp.Body.Close()
-
-	bodyBytes, readErr := io.ReadAll(resp.Body)
-	if readErr != nil {
-		log.Printf("Warning: failed to read response body after status %d for Flag creation: %v", resp.StatusCode, readErr)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("FHIR Flag %s read status %d", flagID, resp.StatusCode)
 	}
-
-	// Check response status code
-	if resp.StatusCode != http.StatusCreated { // 201 Created
-		errBody := string(bodyBytes)
-		if errBody == "" && readErr != nil {
-			errBody = fmt.Sprintf("(could not read body: %v)", readErr)
-		}
-		log.Printf("ERROR: FHIR Flag creation failed. Status: %d, Body: %s", resp.StatusCode, errBody)
-		return "", fmt.Errorf("FHIR API error creating Flag (status %d): %s", resp.StatusCode, errBody)
+	var flag map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&flag); err != nil {
+		return fmt.Errorf("failed to parse FHIR Flag %s: %w", flagID, err)
 	}
-
-	// Parse the response body to get the created resource ID
-	var createdFlag createdResource // Reusing the struct from observation.go
-	if err := json.Unmarshal(bodyBytes, &createdFlag); err != nil {
-		log.Printf("ERROR: Failed to unmarshal FHIR Flag response body: %s. Error: %v", string(bodyBytes), err)
-		return "", fmt.Errorf("failed to parse FHIR Flag response body: %w", err)
+	if flag["status"] != "active" {
+		return nil // Already resolved by someone else
 	}
 
-	if createdFlag.ID == "" {
-		log.Printf("ERROR: FHIR Flag created (201) but response did not contain an ID. Body: %s", string(bodyBytes))
-		return "", fmt.Errorf("FHIR Flag created but response missing ID")
+	flag["status"] = "inactive"
+	period, _ := flag["period"].(map[string]any)
+	if period == nil {
+		period = map[string]any{}
+	}
+	period["end"] = fhirDateTime(cfg, cfg.Now())
+	flag["period"] = period
+	flagBytes, err := json.Marshal(flag)
+	if err != nil {
+		return fmt.Errorf("failed to marshal FHIR Flag JSON: %w", err)
 	}
 
-	log.Printf("Successfully created FHIR Flag with ID: %s for Patient %s", createdFlag.ID, patientID)
-	return createdFlag.ID, nil
+	req, err = http.NewRequest(http.MethodPut, url, bytes.NewBuffer(flagBytes))
+	if err != nil {
+		return fmt.Errorf("failed to create FHIR Flag update request: %w", err)
+	}
+	setFHIRHeaders(req, cfg, token)
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	log.Printf("Sending PUT request to %s to resolve Flag", url)
+	putResp, err := doRequest(httpClient, req)
+	if err != nil {
+		return fmt.Errorf("failed to update FHIR Flag %s: %w", flagID, err)
+	}
+	defer putResp.Body.Close()
+	if !createSucceeded(putResp.StatusCode) {
+		body, _ := io.ReadAll(io.LimitReader(putResp.Body, 1024))
+		return fmt.Errorf("FHIR Flag %s update status %d: %s", flagID, putResp.StatusCode, body)
+	}
+	return nil
 }
@@ -0,0 +1,275 @@
+package fhir
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"example.com/epds-service/internal/config"
+)
+
+// fhirMessageHeader identifies the screening event and points at the resources it carries.
+type fhirMessageHeader struct {
+	ResourceType string            `json:"resourceType"`
+	EventCoding  fhirCoding        `json:"eventCoding"`
+	Source       fhirMessageSource `json:"source"`
+	Focus        []fhirReference   `json:"focus"`
+}
+
+type fhirMessageSource struct {
+	Name     string `json:"name"`
+	Endpoint string `json:"endpoint"`
+}
+
+type fhirMessageBundle struct {
+	ResourceType string             `json:"resourceType"`
+	Type         string             `json:"type"`
+	Timestamp    string             `json:"timestamp"`
+	Entry        []fhirMessageEntry `json:"entry"`
+}
+
+type fhirMessageEntry struct {
+	FullURL  string `json:"fullUrl"`
+	Resource any    `json:"resource"`
+}
+
+// ScreeningMessage is the content of one $process-message delivery (DELIVERY_MODE=message).
+type ScreeningMessage struct {
+	PatientID   string // The Group's ID when Observation.SubjectType is SubjectGroup
+	EncounterID string // Links the Flag to the visit; empty for a patient-scoped Flag
+	TotalScore  int
+	Q10Score    int
+	Observation ObservationOptions
+	// HighRisk adds the Flag and the provider alert Communication.
+	HighRisk bool
+	// SkipFlag leaves the Flag out of a high-risk message (NO_ENCOUNTER_BEHAVIOR=skip-flag).
+	SkipFlag bool
+	// NegativeScreen adds the routine negative-screen Communication (ignored when HighRisk).
+	NegativeScreen bool
+	Instrument     string // config.InstrumentNames; empty is the EPDS
+	Locale         string
+	FlagReason     string // Flag code text from the scoring webhook; empty uses the default text
+
+	// SkipCommunication leaves the Communication out (COMMUNICATION_DEBOUNCE_SECONDS).
+	SkipCommunication bool
+}
+
+// MessageResult holds the resources the server created from a message. Flag and
+// Communication are zero when the message did not include them or SideEffectsErr is set.
+type MessageResult struct {
+	Observation   Created
+	Flag          Created
+	Communication Created
+	// SideEffectsErr is why the Flag/Communication message failed. The Observation was
+	// still recorded; the caller decides whether to retry the side-effects.
+	SideEffectsErr error
+}
+
+// ProcessMessage delivers the submission as FHIR messages (POST {base}/$process-message),
+// so downstream receives the screen as a unit. The Observation travels alone in the first
+// message, whose failure fails the submission; the Flag and Communication follow in a
+// second, best-effort message, so a rejected side-effect never loses the score. Created
+// IDs are read from the response Bundles.
+func ProcessMessage(httpClient *http.Client, cfg *config.Config, token string, msg ScreeningMessage) (MessageResult, error) {
+	if _, err := buildReference(msg.Observation.subjectType(), msg.PatientID); err != nil {
+		return MessageResult{}, fmt.Errorf("cannot deliver screening message: %w", err)
+	}
+	// Use a default client if none is provided
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 15 * time.Second}
+	}
+
+	score := newMessageBundle(cfg)
+	score.add(newObservation(cfg, msg.PatientID, msg.TotalScore, msg.Observation))
+	result, err := postMessage(httpClient, cfg, token, msg.PatientID, score)
+	if err != nil {
+		return MessageResult{}, err
+	}
+	if result.Observation.ID == "" {
+		return MessageResult{}, fmt.Errorf("FHIR message processed but response missing Observation ID")
+	}
+
+	sideEffects := newMessageBundle(cfg)
+	if msg.HighRisk {
+		if !msg.SkipFlag {
+			sideEffects.add(newFlag(cfg, msg.PatientID, msg.EncounterID, msg.TotalScore, msg.Q10Score, msg.Instrument, msg.Locale, msg.FlagReason))
+		}
+		if !msg.SkipCommunication {
+			sideEffects.add(newCommunication(cfg, msg.PatientID, cfg.AlertProviderFHIRID, msg.TotalScore, msg.Q10Score, CommunicationOptions{Locale: msg.Locale, Instrument: msg.Instrument}))
+		}
+	} else if msg.NegativeScreen && !msg.SkipCommunication {
+		sideEffects.add(newCommunication(cfg, msg.PatientID, cfg.AlertProviderFHIRID, msg.TotalScore, msg.Q10Score, CommunicationOptions{NegativeScreen: true, Locale: msg.Locale, Instrument: msg.Instrument}))
+	}
+	if len(sideEffects.Entry) > 1 {
+		delivered, err := postMessage(httpClient, cfg, token, msg.PatientID, sideEffects)
+		if err != nil {
+			log.Printf("ERROR: Flag/Communication message failed after Observation %s was recorded: %v", result.Observation.ID, err)
+			result.SideEffectsErr = err
+		} else {
+			result.Flag, result.Communication = delivered.Flag, delivered.Communication
+		}
+	}
+
+	log.Printf("Successfully processed FHIR messages for Patient %s: Observation %s, Flag %s, Communication %s",
+		msg.PatientID, result.Observation.ID, result.Flag.ID, result.Communication.ID)
+	return result, nil
+}
+
+// newMessageBundle returns a message Bundle holding only its MessageHeader.
+func newMessageBundle(cfg *config.Config) *fhirMessageBundle {
+	header := &fhirMessageHeader{
+		ResourceType: "MessageHeader",
+		EventCoding: fhirCoding{
+			System:  "urn:cornell:epds:events",
+			Code:    "epds-screening",
+			Display: "EPDS screening result",
+		},
+		Source: fhirMessageSource{Name: "EPDS Service", Endpoint: "urn:cornell:epds-service"},
+	}
+	return &fhirMessageBundle{
+		ResourceType: "Bundle",
+		Type:         "message",
+		Timestamp:    fhirDateTime(cfg, cfg.Now()),
+		Entry:        []fhirMessageEntry{{FullURL: "urn:uuid:" + newUUID(), Resource: header}},
+	}
+}
+
+// add appends resource to the Bundle and to its MessageHeader's focus.
+func (b *fhirMessageBundle) add(resource any) {
+	fullURL := "urn:uuid:" + newUUID()
+	header := b.Entry[0].Resource.(*fhirMessageHeader)
+	header.Focus = append(header.Focus, fhirReference{Reference: fullURL})
+	b.Entry = append(b.Entry, fhirMessageEntry{FullURL: fullURL, Resource: resource})
+}
+
+// postMessage POSTs one message Bundle to $process-message and parses the response.
+func postMessage(httpClient *http.Client, cfg *config.Config, token, patientID string, bundle *fhirMessageBundle) (MessageResult, error) {
+	bundleBytes, err := marshalResource(cfg, bundle)
+	if err != nil {
+		return MessageResult{}, fmt.Errorf("failed to marshal FHIR message Bundle JSON: %w", err)
+	}
+
+	url := baseURL(cfg, token) + "/$process-message"
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(bundleBytes))
+	if err != nil {
+		return MessageResult{}, fmt.Errorf("failed to create FHIR $process-message request: %w", err)
+	}
+	req, cancel := withResourceTimeout(req, cfg, "Bundle")
+	defer cancel()
+	setFHIRHeaders(req, cfg, token)
+
+	log.Printf("Sending POST request to %s with %d resources for Patient %s", url, len(bundle.Entry)-1, patientID)
+	resp, err := doRequest(httpClient, req)
+	if err != nil {
+		return MessageResult{}, fmt.Errorf("failed to execute FHIR $process-message request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, readErr := io.ReadAll(resp.Body)
+	if readErr != nil {
+		log.Printf("Warning: failed to read $process-message response body after status %d: %v", resp.StatusCode, readErr)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		log.Printf("ERROR: FHIR $process-message failed. Status: %d, Body: %s", resp.StatusCode, string(bodyBytes))
+		return MessageResult{}, &CreateError{ResourceType: "Bundle", StatusCode: resp.StatusCode, Request: bundleBytes, Response: string(bodyBytes)}
+	}
+
+	result, err := parseMessageResponse(bodyBytes)
+	if err != nil {
+		log.Printf("ERROR: Failed to parse FHIR $process-message response body: %s. Error: %v", string(bodyBytes), err)
+		return MessageResult{}, err
+	}
+	return result, nil
+}
+
+// parseMessageResponse extracts the created resources from a $process-message response
+// Bundle. IDs come from each entry's resource or, when the server omits the body,
+// from entry.response.location (e.g. "Flag/123/_history/1").
+func parseMessageResponse(body []byte) (MessageResult, error) {
+	var reply struct {
+		Entry []struct {
+			Resource json.RawMessage `json:"resource"`
+			Response *struct {
+				Location string `json:"location"`
+			} `json:"response"`
+		} `json:"entry"`
+	}
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return MessageResult{}, fmt.Errorf("failed to parse FHIR message response Bundle: %w", err)
+	}
+
+	var result MessageResult
+	for _, entry := range reply.Entry {
+		var resource struct {
+			ResourceType string `json:"resourceType"`
+			ID           string `json:"id"`
+			Response     *struct {
+				Code string `json:"code"`
+			} `json:"response"`
+		}
+		if len(entry.Resource) > 0 {
+			if err := json.Unmarshal(entry.Resource, &resource); err != nil {
+				return MessageResult{}, fmt.Errorf("failed to parse FHIR message response entry: %w", err)
+			}
+		}
+		created := Created{ID: resource.ID, Resource: entry.Resource}
+		if resource.ResourceType == "" && entry.Response != nil {
+			if resourceType, id, ok := parseLocation(entry.Response.Location); ok {
+				resource.ResourceType, created = resourceType, Created{ID: id}
+			}
+		}
+
+		switch resource.ResourceType {
+		case "MessageHeader":
+			if resource.Response != nil && resource.Response.Code != "ok" {
+				return MessageResult{}, fmt.Errorf("FHIR message rejected with response code %q", resource.Response.Code)
+			}
+		case "Observation":
+			result.Observation = created
+		case "Flag":
+			result.Flag = created
+		case "Communication":
+			result.Communication = created
+		}
+	}
+	return result, nil
+}
+
+// parseLocation returns the resource type and ID of a response location, relative
+// ("Flag/123/_history/1") or absolute ("https://host/fhir/Flag/123/_history/1"): the
+// Type/id pair before _history, or the last two segments of an unversioned location.
+func parseLocation(location string) (resourceType string, id string, ok bool) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", "", false
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if i := slices.Index(segments, "_history"); i >= 0 {
+		segments = segments[:i]
+	}
+	if len(segments) < 2 {
+		return "", "", false
+	}
+	resourceType, id = segments[len(segments)-2], segments[len(segments)-1]
+	if resourceType == "" || !ValidID(id) {
+		return "", "", false
+	}
+	return resourceType, id, true
+}
+
+// newUUID returns a random (version 4) UUID for urn:uuid: entry URLs.
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
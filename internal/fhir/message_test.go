@@ -0,0 +1,47 @@
+package fhir
+
+import "testing"
+
+func TestParseLocation(t *testing.T) {
+	tests := []struct {
+		name         string
+		location     string
+		resourceType string
+		id           string
+		ok           bool
+	}{
+		{"relative", "Flag/123", "Flag", "123", true},
+		{"relative versioned", "Flag/123/_history/1", "Flag", "123", true},
+		{"absolute versioned", "https://host/fhir/Flag/123/_history/1", "Flag", "123", true},
+		{"absolute", "https://host/fhir/r4/Communication/c-1", "Communication", "c-1", true},
+		{"trailing slash", "Observation/obs.1/", "Observation", "obs.1", true},
+		{"empty", "", "", "", false},
+		{"type only", "Flag", "", "", false},
+		{"history only", "_history/1", "", "", false},
+		{"invalid id", "Flag/12%263", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resourceType, id, ok := parseLocation(tt.location)
+			if resourceType != tt.resourceType || id != tt.id || ok != tt.ok {
+				t.Errorf("parseLocation(%q) = %q, %q, %t; want %q, %q, %t", tt.location, resourceType, id, ok, tt.resourceType, tt.id, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseMessageResponseLocations(t *testing.T) {
+	body := []byte(`{"resourceType":"Bundle","entry":[
+		{"resource":{"resourceType":"MessageHeader","response":{"code":"ok"}}},
+		{"response":{"location":"https://host/fhir/Observation/obs-1/_history/1"}},
+		{"response":{"location":"Flag/flag-1/_history/2"}},
+		{"response":{"location":"https://host/fhir/Communication/comm-1"}}
+	]}`)
+	result, err := parseMessageResponse(body)
+	if err != nil {
+		t.Fatalf("parseMessageResponse error: %v", err)
+	}
+	if result.Observation.ID != "obs-1" || result.Flag.ID != "flag-1" || result.Communication.ID != "comm-1" {
+		t.Errorf("IDs = %q, %q, %q; want obs-1, flag-1, comm-1", result.Observation.ID, result.Flag.ID, result.Communication.ID)
+	}
+}
@@ -1,13 +1,10 @@
 package fhir
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
-	"log"
 	"net/http"
-	"time"
+	"net/url"
 
 	"example.com/epds-service/internal/config"
 )
@@ -16,12 +13,19 @@ import (
 // Based on Appendix A.1 of pdr.md.
 type fhirObservation struct {
 	ResourceType      string         `json:"resourceType"`
+	ID                string         `json:"id,omitempty"` // Only set for client-assigned IDs (PUT)
 	Status            string         `json:"status"`
 	Category          []fhirCategory `json:"category"`
 	Code              fhirCode       `json:"code"`
 	Subject           fhirReference  `json:"subject"`
+	Encounter         *fhirReference `json:"encounter,omitempty"`
 	EffectiveDateTime string         `json:"effectiveDateTime"`
-	ValueInteger      int            `json:"valueInteger"`
+	ValueInteger      *int           `json:"valueInteger,omitempty"`
+	DataAbsentReason  *fhirCategory  `json:"dataAbsentReason,omitempty"` // Why there is no value (unscored instruments)
+	Interpretation    []fhirCategory `json:"interpretation,omitempty"`   // H or N against the high-risk threshold
+	Method            *fhirCategory  `json:"method,omitempty"`
+	Note              []fhirNote     `json:"note,omitempty"` // The patient's free-text comments
+	Meta              *fhirMeta      `json:"meta,omitempty"` // Defined in flag.go
 }
 
 type fhirCategory struct {
@@ -29,10 +33,16 @@ type fhirCategory struct {
 	Text   string       `json:"text,omitempty"`
 }
 
+// fhirNote is an Annotation: a text note with the time it was made.
+type fhirNote struct {
+	Text string `json:"text"`
+	Time string `json:"time,omitempty"`
+}
+
 type fhirCoding struct {
 	System  string `json:"system"`
 	Code    string `json:"code"`
-	Display string `json:"display"`
+	Display string `json:"display,omitempty"`
 }
 
 type fhirCode struct {
@@ -50,12 +60,103 @@ type createdResource struct {
 	ID string `json:"id"`
 }
 
-// CreateObservation sends a POST request to the Oystehr FHIR API to create an Observation resource.
-// It returns the ID of the created Observation or an error.
-func CreateObservation(httpClient *http.Client, cfg *config.Config, token string, patientID string, totalScore int) (string, error) {
-	// Use a default client if none is provided
-	if httpClient == nil {
-		httpClient = &http.Client{Timeout: 15 * time.Second}
+// Created is returned by the Create* functions: the server-assigned ID plus the
+// resource representation the FHIR server returned.
+type Created struct {
+	ID       string
+	Resource json.RawMessage
+}
+
+// ValueInteger returns the returned Observation's valueInteger. It reports false when the
+// server returned no body or the resource has no integer value.
+func (c Created) ValueInteger() (int, bool) {
+	var obs struct {
+		ValueInteger *int `json:"valueInteger"`
+	}
+	if json.Unmarshal(c.Resource, &obs) != nil || obs.ValueInteger == nil {
+		return 0, false
+	}
+	return *obs.ValueInteger, true
+}
+
+// Observation subject types (ObservationOptions.SubjectType).
+const (
+	SubjectPatient = "Patient"
+	SubjectGroup   = "Group" // Aggregate screens, e.g. from group prenatal classes
+)
+
+// SubjectTypes lists the resource types an Observation may be recorded against.
+var SubjectTypes = []string{SubjectPatient, SubjectGroup}
+
+// ObservationOptions holds optional behavior for CreateObservation.
+type ObservationOptions struct {
+	// IfNoneExist enables FHIR conditional create: the server returns the existing
+	// EPDS Observation for this patient and day (200) instead of creating a duplicate.
+	IfNoneExist bool
+	// Source is the submission channel (e.g. portal, kiosk, clinician), recorded as a meta.tag.
+	Source string
+	// ClientResourceID, when set, creates the Observation with PUT /Observation/{id} so
+	// re-running an import with the same deterministic ID never duplicates. Takes precedence over IfNoneExist.
+	ClientResourceID string
+	// Instrument names the screening instrument (config.InstrumentNames) whose total-score
+	// code the Observation carries; empty is the EPDS.
+	Instrument string
+	// Method is how the screen was administered (e.g. self-administered), recorded as
+	// Observation.method in OBSERVATION_METHOD_SYSTEM. Omitted when empty.
+	Method string
+	// EncounterID ties the screen to the visit it was collected in (Observation.encounter).
+	EncounterID string
+	// Unscored records a screen of an instrument with no scoring rules: a preliminary
+	// Observation coded config.UnscoredInstrument(Instrument), with a dataAbsentReason instead of a value.
+	Unscored bool
+	// Comments is the patient's free-text "additional comments", recorded as an Observation.note.
+	// The caller sanitizes and bounds it (MAX_COMMENT_LENGTH).
+	Comments string
+	// HighRisk sets Observation.interpretation to H (high); otherwise it is N (normal).
+	// Unscored Observations carry no interpretation.
+	HighRisk bool
+	// SubjectType is the resource type of Observation.subject: SubjectPatient (when empty)
+	// or SubjectGroup, in which case the ID passed as the patient's is the Group's.
+	SubjectType string
+}
+
+// subjectType returns the Observation's subject resource type, defaulting to Patient.
+func (o ObservationOptions) subjectType() string {
+	if o.SubjectType == "" {
+		return SubjectPatient
+	}
+	return o.SubjectType
+}
+
+// CreateObservation sends a POST (or, with a client-assigned ID, PUT) request to the Oystehr FHIR API
+// to create an Observation resource.
+// It returns the created (or, for conditional creates, already existing) Observation or an error.
+func CreateObservation(httpClient *http.Client, cfg *config.Config, token string, patientID string, totalScore int, opts ObservationOptions) (Created, error) {
+	if _, err := buildReference(opts.subjectType(), patientID); err != nil {
+		return Created{}, fmt.Errorf("cannot create Observation: %w", err)
+	}
+	obs := newObservation(cfg, patientID, totalScore, opts)
+
+	create := createOptions{ID: opts.ClientResourceID, SubjectType: opts.subjectType()}
+	if opts.IfNoneExist {
+		code := obs.Code.Coding[0]
+		subject, _ := buildReference(opts.subjectType(), patientID) // Checked on entry
+		create.IfNoneExist = fmt.Sprintf("subject=%s&code=%s&date=%s",
+			url.QueryEscape(subject), url.QueryEscape(code.System+"|"+code.Code), fhirDate(cfg, cfg.Now()))
+	}
+	return createResourceWith(httpClient, cfg, token, "Observation", obs, patientID, create)
+}
+
+// observationInterpretationSystem is the v3 ObservationInterpretation code system.
+const observationInterpretationSystem = "http://terminology.hl7.org/CodeSystem/v3-ObservationInterpretation"
+
+// newObservation builds the total-score Observation payload (see CreateObservation).
+func newObservation(cfg *config.Config, patientID string, totalScore int, opts ObservationOptions) fhirObservation {
+	inst, ok := cfg.Instrument(opts.Instrument)
+	if opts.Unscored {
+		inst = config.UnscoredInstrument(opts.Instrument)
+	} else if !ok {
+		inst, _ = cfg.Instrument(config.InstrumentEPDS)
 	}
 
 	// Construct the FHIR Observation payload
@@ -68,78 +169,63 @@ func CreateObservation(httpClient *http.Client, cfg *config.Config, token string
 				Code:    "survey",
 				Display: "Survey",
 			}},
+			Text: cfg.ObservationCategoryText,
 		}},
 		Code: fhirCode{
 			Coding: []fhirCoding{{
-				System:  "http://loinc.org",
-				Code:    "99046-5",
-				Display: "Total score [EPDS]",
+				System:  inst.TotalCode.System,
+				Code:    inst.TotalCode.Code,
+				Display: inst.TotalCode.Display,
 			}},
-			Text: "EPDS Total Score",
+			Text: inst.Display + " Total Score",
 		},
-		Subject:           fhirReference{Reference: fmt.Sprintf("Patient/%s", patientID)},
-		EffectiveDateTime: time.Now().Format(time.RFC3339), // ISO8601 Format
-		ValueInteger:      totalScore,
+		Subject:           reference(cfg, opts.subjectType(), patientID),
+		EffectiveDateTime: fhirDateTime(cfg, cfg.Now()), // ISO8601 Format, clinic timezone
+		ValueInteger:      &totalScore,
+		ID:                opts.ClientResourceID,
 	}
-
-	obsBytes, err := json.Marshal(obs)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal FHIR Observation JSON: %w", err)
-	}
-
-	// Construct the request URL
-	url := cfg.OystehrFHIRBaseURL + "/Observation" // Assuming base URL does not end with /
-	// TODO: Consider adding a check/fix for trailing slash in base URL
-
-	// Create the HTTP request
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(obsBytes))
-	if err != nil {
-		return "", fmt.Errorf("failed to create FHIR Observation request: %w", err)
+	if opts.Unscored {
+		// The answers are in the QuestionnaireResponse; there is no meaningful total
+		obs.Status = "preliminary"
+		obs.Code.Text = inst.Display + " (unscored)"
+		obs.ValueInteger = nil
+		obs.DataAbsentReason = &fhirCategory{
+			Coding: []fhirCoding{{
+				System:  "http://terminology.hl7.org/CodeSystem/data-absent-reason",
+				Code:    "unsupported",
+				Display: "Unsupported",
+			}},
+			Text: "No scoring rules are configured for this instrument",
+		}
 	}
-
-	// Set required headers (as per Section 6.2)
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("x-zapehr-project-id", cfg.OystehrProjectID)
-	req.Header.Set("Content-Type", "application/fhir+json")
-	req.Header.Set("Accept", "application/fhir+json")
-
-	// Execute the request
-	log.Printf("Sending POST request to %s to create Observation for Patient %s", url, patientID)
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to execute FHIR Observation request: %w", err)
+	if !opts.Unscored {
+		interp := fhirCoding{System: observationInterpretationSystem, Code: "N", Display: "Normal"}
+		if opts.HighRisk {
+			interp = fhirCoding{System: observationInterpretationSystem, Code: "H", Display: "High"}
+		}
+		obs.Interpretation = []fhirCategory{{Coding: []fhirCoding{interp}}}
 	}
-	defer resp.Body.Close()
-
-	bodyBytes, readErr := io.ReadAll(resp.Body)
-	if readErr != nil {
-		log.Printf("Warning: failed to read response body after status %d: %v", resp.StatusCode, readErr)
-		// Continue processing status code, but body might be unavailable for error reporting
+	if opts.EncounterID != "" {
+		obs.Encounter = refPtr(reference(cfg, "Encounter", opts.EncounterID))
 	}
-
-	// Check response status code
-	if resp.StatusCode != http.StatusCreated { // 201 Created
-		errBody := string(bodyBytes)
-		if errBody == "" && readErr != nil {
-			errBody = fmt.Sprintf("(could not read body: %v)", readErr)
-		}
-		log.Printf("ERROR: FHIR Observation creation failed. Status: %d, Body: %s", resp.StatusCode, errBody)
-		return "", fmt.Errorf("FHIR API error creating Observation (status %d): %s", resp.StatusCode, errBody)
+	if opts.Comments != "" {
+		obs.Note = []fhirNote{{Text: opts.Comments, Time: obs.EffectiveDateTime}}
 	}
-
-	// Parse the response body to get the created resource ID
-	var createdObs createdResource
-	if err := json.Unmarshal(bodyBytes, &createdObs); err != nil {
-		// Log the body if unmarshalling fails, it might not be the expected format
-		log.Printf("ERROR: Failed to unmarshal FHIR Observation response body: %s. Error: %v", string(bodyBytes), err)
-		return "", fmt.Errorf("failed to parse FHIR Observation response body: %w", err)
+	if opts.Method != "" {
+		obs.Method = &fhirCategory{Coding: []fhirCoding{{System: cfg.ObservationMethodSystem, Code: opts.Method}}}
 	}
 
-	if createdObs.ID == "" {
-		log.Printf("ERROR: FHIR Observation created (201) but response did not contain an ID. Body: %s", string(bodyBytes))
-		return "", fmt.Errorf("FHIR Observation created but response missing ID")
+	// Tag the submission channel for reporting
+	if opts.Source != "" {
+		obs.Meta = &fhirMeta{
+			Tag: []fhirCoding{{
+				System:  "urn:cornell:epds:source",
+				Code:    opts.Source,
+				Display: "EPDS Submission Source",
+			}},
+		}
 	}
+	obs.Meta = withProgramTag(cfg, obs.Meta)
 
-	log.Printf("Successfully created FHIR Observation with ID: %s for Patient %s", createdObs.ID, patientID)
-	return createdObs.ID, nil
+	return obs
 }
@@ -0,0 +1,128 @@
+package fhir
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"example.com/epds-service/internal/config"
+)
+
+// ErrOrganizationMismatch is returned when the alert provider is not in the patient's
+// organization, or either has no organization on record to compare.
+var ErrOrganizationMismatch = errors.New("alert provider is not in the patient's organization")
+
+// CheckSameOrganization reports whether ALERT_PROVIDER_FHIR_ID belongs to the patient's
+// managingOrganization (ENFORCE_SAME_ORG), so a misconfigured provider never receives
+// another organization's alert. The provider's organizations are its own for an
+// Organization or PractitionerRole, and those of its active PractitionerRoles for a Practitioner.
+func CheckSameOrganization(httpClient *http.Client, cfg *config.Config, token, patientID string) error {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	patientRef, err := buildReference("Patient", patientID)
+	if err != nil {
+		return err
+	}
+
+	var patient struct {
+		ManagingOrganization *fhirReference `json:"managingOrganization"`
+	}
+	if err := readResource(httpClient, cfg, token, patientRef, &patient); err != nil {
+		return err
+	}
+	if patient.ManagingOrganization == nil || patient.ManagingOrganization.Reference == "" {
+		return fmt.Errorf("%w: %s has no managingOrganization", ErrOrganizationMismatch, patientRef)
+	}
+	patientOrg, err := buildReference("Organization", patient.ManagingOrganization.Reference)
+	if err != nil {
+		return fmt.Errorf("%w: %s managingOrganization %q", ErrOrganizationMismatch, patientRef, patient.ManagingOrganization.Reference)
+	}
+
+	providerOrgs, err := providerOrganizations(httpClient, cfg, token)
+	if err != nil {
+		return err
+	}
+	if len(providerOrgs) == 0 {
+		return fmt.Errorf("%w: %s has no organization", ErrOrganizationMismatch, cfg.AlertProviderFHIRID)
+	}
+	if !slices.Contains(providerOrgs, patientOrg) {
+		return fmt.Errorf("%w: %s is managed by %s, %s belongs to %s", ErrOrganizationMismatch,
+			patientRef, patientOrg, cfg.AlertProviderFHIRID, strings.Join(providerOrgs, ", "))
+	}
+	return nil
+}
+
+// providerOrganizations returns the Organization references ALERT_PROVIDER_FHIR_ID belongs to.
+func providerOrganizations(httpClient *http.Client, cfg *config.Config, token string) ([]string, error) {
+	resourceType, id, _ := strings.Cut(cfg.AlertProviderFHIRID, "/")
+	var refs []fhirReference
+	switch resourceType {
+	case "Organization":
+		refs = append(refs, fhirReference{Reference: cfg.AlertProviderFHIRID})
+	case "PractitionerRole":
+		var role struct {
+			Organization *fhirReference `json:"organization"`
+		}
+		if err := readResource(httpClient, cfg, token, cfg.AlertProviderFHIRID, &role); err != nil {
+			return nil, err
+		}
+		if role.Organization != nil {
+			refs = append(refs, *role.Organization)
+		}
+	case "Practitioner":
+		var roles bundle
+		query := fmt.Sprintf("PractitionerRole?practitioner=%s&active=true&_elements=organization", url.QueryEscape(id))
+		if err := readResource(httpClient, cfg, token, query, &roles); err != nil {
+			return nil, err
+		}
+		for _, entry := range roles.Entry {
+			var role struct {
+				Organization *fhirReference `json:"organization"`
+			}
+			if json.Unmarshal(entry.Resource, &role) == nil && role.Organization != nil {
+				refs = append(refs, *role.Organization)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("cannot resolve the organization of alert provider %s", cfg.AlertProviderFHIRID)
+	}
+
+	var orgs []string
+	for _, ref := range refs {
+		if org, err := buildReference("Organization", ref.Reference); err == nil && !slices.Contains(orgs, org) {
+			orgs = append(orgs, org)
+		}
+	}
+	return orgs, nil
+}
+
+// readResource GETs path (a reference such as Patient/123, or a search) relative to the
+// FHIR base URL and decodes the JSON response into v.
+func readResource(httpClient *http.Client, cfg *config.Config, token, path string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, baseURL(cfg, token)+"/"+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create %s request: %w", path, err)
+	}
+	setFHIRHeaders(req, cfg, token)
+
+	resp, err := doRequest(httpClient, req)
+	if err != nil {
+		return fmt.Errorf("%s read failed: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s read status %d: %s", path, resp.StatusCode, string(body))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("%s decode: %w", path, err)
+	}
+	return nil
+}
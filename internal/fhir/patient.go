@@ -0,0 +1,46 @@
+package fhir
+
+import (
+	"net/http"
+
+	"example.com/epds-service/internal/config"
+)
+
+// fhirPatient is a minimal provisional Patient created from a submission's identifier.
+type fhirPatient struct {
+	ResourceType string           `json:"resourceType"`
+	Active       bool             `json:"active"`
+	Identifier   []fhirIdentifier `json:"identifier"`
+	Meta         *fhirMeta        `json:"meta,omitempty"`
+}
+
+type fhirIdentifier struct {
+	Type   *fhirCategory `json:"type,omitempty"`
+	System string        `json:"system"`
+	Value  string        `json:"value"`
+}
+
+// CreatePatient creates a provisional Patient carrying only the given identifier, tagged
+// so registration staff can find and complete (or merge) it later. The identifier carries
+// PATIENT_IDENTIFIER_TYPE when configured, so later typed searches still find the Patient.
+// It returns the created Patient or an error.
+func CreatePatient(httpClient *http.Client, cfg *config.Config, token string, system string, value string) (Created, error) {
+	identifier := fhirIdentifier{System: system, Value: value}
+	if cfg.PatientIdentifierTypeCode != "" {
+		identifier.Type = &fhirCategory{Coding: []fhirCoding{{System: cfg.PatientIdentifierTypeSystem, Code: cfg.PatientIdentifierTypeCode}}}
+	}
+	patient := fhirPatient{
+		ResourceType: "Patient",
+		Active:       true,
+		Identifier:   []fhirIdentifier{identifier},
+		Meta: &fhirMeta{
+			Tag: []fhirCoding{{
+				System:  "urn:cornell:epds:tags",
+				Code:    "provisional-patient",
+				Display: "Provisional Patient created from EPDS submission",
+			}},
+		},
+	}
+
+	return createResource(httpClient, cfg, token, "Patient", patient, system+"|"+value)
+}
@@ -0,0 +1,50 @@
+package fhir
+
+import (
+	"fmt"
+	"net/http"
+
+	"example.com/epds-service/internal/config"
+)
+
+// fhirProvenance records who submitted an EPDS screen (medico-legal audit trail).
+type fhirProvenance struct {
+	ResourceType string                `json:"resourceType"`
+	Target       []fhirReference       `json:"target"`
+	Recorded     string                `json:"recorded"`
+	Agent        []fhirProvenanceAgent `json:"agent"`
+}
+
+type fhirProvenanceAgent struct {
+	Type fhirCode      `json:"type"`
+	Who  fhirReference `json:"who"`
+}
+
+// CreateProvenance creates a Provenance targeting the EPDS Observation with submittedBy
+// (a "Practitioner/{id}" or "Device/{id}" reference) as the author agent.
+// It returns the created Provenance or an error.
+func CreateProvenance(httpClient *http.Client, cfg *config.Config, token string, patientID string, observationID string, submittedBy string) (Created, error) {
+	if _, err := buildReference("Patient", patientID); err != nil {
+		return Created{}, fmt.Errorf("cannot create Provenance: %w", err)
+	}
+	if _, err := buildReference("Observation", observationID); err != nil {
+		return Created{}, fmt.Errorf("cannot create Provenance: %w", err)
+	}
+	prov := fhirProvenance{
+		ResourceType: "Provenance",
+		Target:       []fhirReference{reference(cfg, "Observation", observationID)},
+		Recorded:     fhirDateTime(cfg, cfg.Now()),
+		Agent: []fhirProvenanceAgent{{
+			Type: fhirCode{
+				Coding: []fhirCoding{{
+					System:  "http://terminology.hl7.org/CodeSystem/provenance-participant-type",
+					Code:    "author",
+					Display: "Author",
+				}},
+			},
+			Who: styledReference(cfg, submittedBy),
+		}},
+	}
+
+	return createResource(httpClient, cfg, token, "Provenance", prov, patientID)
+}
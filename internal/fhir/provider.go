@@ -0,0 +1,60 @@
+package fhir
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"example.com/epds-service/internal/config"
+)
+
+// ErrProviderNotFound is returned when ALERT_PROVIDER_FHIR_ID no longer resolves.
+var ErrProviderNotFound = errors.New("alert provider not found")
+
+// ErrProviderInactive is returned when the alert provider exists but has active=false.
+var ErrProviderInactive = errors.New("alert provider is inactive")
+
+// CheckAlertProvider reads ALERT_PROVIDER_FHIR_ID (e.g. Practitioner/123) and reports
+// whether alerts can still be delivered to it. A decommissioned provider means alerts
+// are created but never seen, so readiness should fail.
+func CheckAlertProvider(httpClient *http.Client, cfg *config.Config, token string) error {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	u := fmt.Sprintf("%s/%s", baseURL(cfg, token), cfg.AlertProviderFHIRID)
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create alert provider request: %w", err)
+	}
+	setFHIRHeaders(req, cfg, token)
+
+	resp, err := doRequest(httpClient, req)
+	if err != nil {
+		return fmt.Errorf("alert provider read failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusGone:
+		return fmt.Errorf("%w: %s (status %d)", ErrProviderNotFound, cfg.AlertProviderFHIRID, resp.StatusCode)
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("alert provider read status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var provider struct {
+		Active *bool `json:"active"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&provider); err != nil {
+		return fmt.Errorf("alert provider decode: %w", err)
+	}
+	if provider.Active != nil && !*provider.Active {
+		return fmt.Errorf("%w: %s", ErrProviderInactive, cfg.AlertProviderFHIRID)
+	}
+	return nil
+}
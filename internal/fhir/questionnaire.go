@@ -0,0 +1,67 @@
+package fhir
+
+import (
+	"fmt"
+	"net/http"
+
+	"example.com/epds-service/internal/config"
+)
+
+// fhirQuestionnaireResponse records the individual answers behind the total-score Observation.
+type fhirQuestionnaireResponse struct {
+	ResourceType string                  `json:"resourceType"`
+	Status       string                  `json:"status"`
+	Subject      fhirReference           `json:"subject"`
+	Authored     string                  `json:"authored"`
+	Item         []fhirQuestionnaireItem `json:"item"`
+	Meta         *fhirMeta               `json:"meta,omitempty"` // Defined in flag.go
+}
+
+type fhirQuestionnaireItem struct {
+	LinkID string                    `json:"linkId"`
+	Code   []fhirCoding              `json:"code,omitempty"`
+	Text   string                    `json:"text,omitempty"`
+	Answer []fhirQuestionnaireAnswer `json:"answer"`
+}
+
+type fhirQuestionnaireAnswer struct {
+	ValueInteger int `json:"valueInteger"`
+}
+
+// CreateQuestionnaireResponse records answers (q1 first) as a completed QuestionnaireResponse.
+// Each item has linkId qN and carries the instrument's coding for that question (QUESTION_CODES
+// for the EPDS); items of an unscored instrument (RECORD_UNSCORED_INSTRUMENTS) have no coding.
+// It returns the created QuestionnaireResponse or an error.
+func CreateQuestionnaireResponse(httpClient *http.Client, cfg *config.Config, token string, patientID string, instrument string, answers []int) (Created, error) {
+	if _, err := buildReference("Patient", patientID); err != nil {
+		return Created{}, fmt.Errorf("cannot create QuestionnaireResponse: %w", err)
+	}
+	inst, ok := cfg.Instrument(instrument)
+	if !ok && cfg.RecordUnscoredInstruments {
+		inst = config.UnscoredInstrument(instrument)
+	} else if !ok {
+		return Created{}, fmt.Errorf("unknown instrument %q", instrument)
+	}
+
+	qr := fhirQuestionnaireResponse{
+		ResourceType: "QuestionnaireResponse",
+		Status:       "completed",
+		Subject:      reference(cfg, "Patient", patientID),
+		Authored:     fhirDateTime(cfg, cfg.Now()),
+		Meta:         withProgramTag(cfg, nil),
+	}
+	for i, value := range answers {
+		item := fhirQuestionnaireItem{
+			LinkID: fmt.Sprintf("q%d", i+1),
+			Answer: []fhirQuestionnaireAnswer{{ValueInteger: value}},
+		}
+		if i < len(inst.ItemCodes) {
+			code := inst.ItemCodes[i]
+			item.Code = []fhirCoding{{System: code.System, Code: code.Code, Display: code.Display}}
+			item.Text = code.Display
+		}
+		qr.Item = append(qr.Item, item)
+	}
+
+	return createResource(httpClient, cfg, token, "QuestionnaireResponse", qr, patientID)
+}
@@ -0,0 +1,280 @@
+package fhir
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"example.com/epds-service/internal/auth"
+	"example.com/epds-service/internal/config"
+)
+
+// setFHIRHeaders sets the headers every Oystehr FHIR request needs. The project ID
+// comes from config, or from the access token's claims when config leaves it blank;
+// tenant-routed servers (FHIR_URL_TEMPLATE) may have none.
+// EXTRA_FHIR_HEADERS are applied first so they can never replace the required ones.
+func setFHIRHeaders(req *http.Request, cfg *config.Config, token string) {
+	for name, values := range cfg.ExtraFHIRHeaders {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if id := projectID(cfg, token); id != "" { // Optional with FHIR_URL_TEMPLATE
+		req.Header.Set("x-zapehr-project-id", id)
+	}
+	req.Header.Set("Accept", fhirMediaType(cfg))
+	req.Header.Set("Accept-Encoding", "gzip")
+	if req.Body != nil {
+		req.Header.Set("Content-Type", fhirMediaType(cfg))
+	}
+}
+
+// doRequest executes a FHIR request. Because setFHIRHeaders advertises gzip explicitly,
+// net/http leaves compressed bodies alone; doRequest decompresses them so callers can
+// read and decode resp.Body as plain JSON.
+func doRequest(httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil || !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return resp, err
+	}
+
+	zr, err := gzip.NewReader(resp.Body)
+	switch {
+	case errors.Is(err, io.EOF): // Empty body (e.g. 204) despite the header
+		resp.Body.Close()
+		resp.Body = http.NoBody
+	case err != nil:
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to decompress gzip response: %w", err)
+	default:
+		resp.Body = gzipBody{Reader: zr, body: resp.Body}
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// gzipBody reads decompressed data and closes the underlying response body.
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b gzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
+
+// baseURL returns the FHIR base URL for token. It is OYSTEHR_FHIR_BASE_URL, or FHIR_URL_TEMPLATE
+// filled in at startup when FHIR_TENANT is set; otherwise the template is filled with the
+// tenant from the token's FHIR_TENANT_CLAIM.
+func baseURL(cfg *config.Config, token string) string {
+	if cfg.OystehrFHIRBaseURL != "" {
+		return cfg.OystehrFHIRBaseURL
+	}
+	return cfg.TenantURL(auth.ProjectIDFromToken(token, cfg.FHIRTenantClaim))
+}
+
+// projectID returns the configured project ID, falling back to the token claim.
+func projectID(cfg *config.Config, token string) string {
+	if cfg.OystehrProjectID != "" {
+		return cfg.OystehrProjectID
+	}
+	return auth.ProjectIDFromToken(token, cfg.OystehrProjectIDClaim)
+}
+
+// IDPattern is the FHIR id grammar. Ids are pasted into search URLs, so anything else
+// (such as "123&_tag=...") must be rejected rather than sent.
+const IDPattern = `^[A-Za-z0-9\-.]{1,64}$`
+
+var idPattern = regexp.MustCompile(IDPattern)
+
+// ValidID reports whether id is a valid FHIR resource id (see IDPattern).
+func ValidID(id string) bool {
+	return idPattern.MatchString(id)
+}
+
+// buildReference returns the relative reference "{resourceType}/{id}". An id that already
+// names the type, such as "Patient/123" or an absolute ".../Patient/123", is not prefixed
+// twice. An empty id, or one that is not a valid FHIR id once the prefix is removed
+// (another type, a version, query syntax), is an error.
+func buildReference(resourceType, id string) (string, error) {
+	id = strings.TrimSpace(id)
+	prefix := resourceType + "/"
+	if i := strings.LastIndex(id, prefix); i == 0 || (i > 0 && id[i-1] == '/') {
+		id = id[i+len(prefix):]
+	}
+	if id == "" {
+		return "", fmt.Errorf("empty %s id", resourceType)
+	}
+	if !ValidID(id) {
+		return "", fmt.Errorf("invalid %s id %q", resourceType, id)
+	}
+	return prefix + id, nil
+}
+
+// reference builds a reference to resourceType/id (see buildReference) in the configured
+// REFERENCE_STYLE. The create functions check their required ids with buildReference before
+// building anything, so an invalid id here is a bug; it is logged and referenced as given.
+func reference(cfg *config.Config, resourceType, id string) fhirReference {
+	ref, err := buildReference(resourceType, id)
+	if err != nil {
+		log.Printf("ERROR: building %s reference: %v", resourceType, err)
+		ref = resourceType + "/" + id
+	}
+	return styledReference(cfg, ref)
+}
+
+// styledReference applies REFERENCE_STYLE to an already-formed relative reference such
+// as "Practitioner/123". With the absolute style it is prefixed with the FHIR base URL;
+// references that are already absolute are returned unchanged.
+func styledReference(cfg *config.Config, ref string) fhirReference {
+	if cfg.ReferenceStyle == config.ReferenceStyleAbsolute && !strings.Contains(ref, "://") {
+		ref = strings.TrimRight(cfg.OystehrFHIRBaseURL, "/") + "/" + ref
+	}
+	return fhirReference{Reference: ref}
+}
+
+// refPtr returns a pointer to ref, for optional reference fields.
+func refPtr(ref fhirReference) *fhirReference {
+	return &ref
+}
+
+// CreateError is returned when the FHIR server rejects a create. It keeps the exact
+// payload sent and the response body so DEBUG_ECHO can hand them back to the caller.
+type CreateError struct {
+	ResourceType string
+	StatusCode   int
+	Request      []byte // JSON payload that was POSTed (or PUT)
+	Response     string // Response body, or a note when it could not be read
+}
+
+func (e *CreateError) Error() string {
+	return fmt.Sprintf("FHIR API error creating %s (status %d): %s", e.ResourceType, e.StatusCode, e.Response)
+}
+
+// createSucceeded reports whether a create's response status is a success. Servers
+// should answer 201 Created, but some return 200 OK with the new resource; either is
+// accepted, provided the body carries the resource ID (checked by the callers).
+func createSucceeded(status int) bool {
+	return status == http.StatusCreated || status == http.StatusOK
+}
+
+// withResourceTimeout bounds req, retries included, by the timeout configured for
+// resourceType. Callers defer the returned cancel until the response body is read.
+func withResourceTimeout(req *http.Request, cfg *config.Config, resourceType string) (*http.Request, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(req.Context(), cfg.ResourceTimeout(resourceType))
+	return req.WithContext(ctx), cancel
+}
+
+// createOptions varies how createResourceWith sends a resource.
+type createOptions struct {
+	// ID, when set, PUTs the resource to {base}/{resourceType}/{ID} (a client-assigned ID)
+	// instead of POSTing it.
+	ID string
+	// IfNoneExist is the search query sent as If-None-Exist, making a POST a conditional create.
+	IfNoneExist string
+	// SubjectType names the resource the subject ID belongs to in logs; Patient when empty.
+	SubjectType string
+}
+
+// createResource POSTs resource to {base}/{resourceType} and returns the created resource.
+// It follows the same request, logging, and error conventions as the hand-written Create* functions.
+func createResource(httpClient *http.Client, cfg *config.Config, token string, resourceType string, resource any, patientID string) (Created, error) {
+	return createResourceWith(httpClient, cfg, token, resourceType, resource, patientID, createOptions{})
+}
+
+// createResourceWith is createResource with a client-assigned ID (PUT) or a conditional
+// create. Either may answer 200 OK with a resource that already existed, which is logged
+// as such and returned like a created one.
+func createResourceWith(httpClient *http.Client, cfg *config.Config, token string, resourceType string, resource any, subjectID string, opts createOptions) (Created, error) {
+	// Use a default client if none is provided
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 15 * time.Second}
+	}
+	subjectType := opts.SubjectType
+	if subjectType == "" {
+		subjectType = "Patient"
+	}
+
+	resourceBytes, err := marshalResource(cfg, resource)
+	if err != nil {
+		return Created{}, fmt.Errorf("failed to marshal FHIR %s JSON: %w", resourceType, err)
+	}
+
+	// Construct the request URL
+	url := baseURL(cfg, token) + "/" + resourceType
+	method := http.MethodPost
+	if opts.ID != "" {
+		url += "/" + opts.ID
+		method = http.MethodPut
+	}
+
+	// Create the HTTP request
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(resourceBytes))
+	if err != nil {
+		return Created{}, fmt.Errorf("failed to create FHIR %s request: %w", resourceType, err)
+	}
+	req, cancel := withResourceTimeout(req, cfg, resourceType)
+	defer cancel()
+	setFHIRHeaders(req, cfg, token)
+	if opts.IfNoneExist != "" && method == http.MethodPost {
+		req.Header.Set("If-None-Exist", opts.IfNoneExist)
+	}
+
+	// Execute the request
+	log.Printf("Sending %s request to %s to create %s for %s %s", method, url, resourceType, subjectType, subjectID)
+	resp, err := doRequest(httpClient, req)
+	if err != nil {
+		return Created{}, fmt.Errorf("failed to execute FHIR %s request: %w", resourceType, err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, readErr := io.ReadAll(resp.Body)
+	if readErr != nil {
+		log.Printf("Warning: failed to read response body after status %d for %s creation: %v", resp.StatusCode, resourceType, readErr)
+	}
+
+	// Check response status code. A conditional create that matched an existing
+	// resource, or a PUT that updated one, returns 200 OK instead of 201 Created.
+	existing := (req.Header.Get("If-None-Exist") != "" || method == http.MethodPut) && resp.StatusCode == http.StatusOK
+	if !createSucceeded(resp.StatusCode) {
+		errBody := string(bodyBytes)
+		if errBody == "" && readErr != nil {
+			errBody = fmt.Sprintf("(could not read body: %v)", readErr)
+		}
+		log.Printf("ERROR: FHIR %s creation failed. Status: %d, Body: %s", resourceType, resp.StatusCode, errBody)
+		return Created{}, &CreateError{ResourceType: resourceType, StatusCode: resp.StatusCode, Request: resourceBytes, Response: errBody}
+	}
+
+	// Parse the response body to get the created resource ID
+	var created createdResource
+	if err := json.Unmarshal(bodyBytes, &created); err != nil {
+		log.Printf("ERROR: Failed to unmarshal FHIR %s response body: %s. Error: %v", resourceType, string(bodyBytes), err)
+		return Created{}, fmt.Errorf("failed to parse FHIR %s response body: %w", resourceType, err)
+	}
+
+	if created.ID == "" {
+		log.Printf("ERROR: FHIR %s created (%d) but response did not contain an ID. Body: %s", resourceType, resp.StatusCode, string(bodyBytes))
+		return Created{}, fmt.Errorf("FHIR %s created but response missing ID", resourceType)
+	}
+
+	if existing {
+		log.Printf("FHIR %s %s already existed for %s %s (status 200)", resourceType, created.ID, subjectType, subjectID)
+	} else {
+		log.Printf("Successfully created FHIR %s with ID: %s for %s %s", resourceType, created.ID, subjectType, subjectID)
+	}
+	return Created{ID: created.ID, Resource: bodyBytes}, nil
+}
@@ -0,0 +1,163 @@
+package fhir
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"example.com/epds-service/internal/config"
+)
+
+func TestBuildReference(t *testing.T) {
+	tests := []struct {
+		name         string
+		resourceType string
+		id           string
+		want         string
+		wantErr      bool
+	}{
+		{"bare id", "Patient", "123", "Patient/123", false},
+		{"bare id with dash and dot", "Encounter", "enc-1.2", "Encounter/enc-1.2", false},
+		{"surrounding whitespace", "Patient", "  123 ", "Patient/123", false},
+		{"already prefixed", "Patient", "Patient/123", "Patient/123", false},
+		{"absolute reference", "Patient", "https://fhir.example.com/r4/Patient/123", "Patient/123", false},
+		{"64 characters", "Patient", strings.Repeat("a", 64), "Patient/" + strings.Repeat("a", 64), false},
+		{"empty", "Patient", "", "", true},
+		{"whitespace only", "Patient", "   ", "", true},
+		{"prefix only", "Patient", "Patient/", "", true},
+		{"other resource type", "Patient", "Encounter/123", "", true},
+		{"versioned", "Patient", "Patient/123/_history/2", "", true},
+		{"type name inside id", "Patient", "XPatient/123", "", true},
+		{"query injection", "Patient", "123&_tag=x", "", true},
+		{"escaped characters", "Patient", "12%263", "", true},
+		{"65 characters", "Patient", strings.Repeat("a", 65), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildReference(tt.resourceType, tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildReference(%q, %q) error = %v, wantErr %t", tt.resourceType, tt.id, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("buildReference(%q, %q) = %q, want %q", tt.resourceType, tt.id, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateResourceWith(t *testing.T) {
+	tests := []struct {
+		name            string
+		opts            createOptions
+		status          int
+		body            string
+		wantMethod      string
+		wantPath        string
+		wantIfNoneExist string
+		wantID          string
+		wantStatus      int // CreateError.StatusCode, 0 when no CreateError is expected
+		wantErr         bool
+	}{
+		{"POST created", createOptions{}, http.StatusCreated, `{"id":"obs-1"}`, http.MethodPost, "/Observation", "", "obs-1", 0, false},
+		{"POST answered 200", createOptions{}, http.StatusOK, `{"id":"obs-1"}`, http.MethodPost, "/Observation", "", "obs-1", 0, false},
+		{"PUT with client ID", createOptions{ID: "client-1"}, http.StatusOK, `{"id":"client-1"}`, http.MethodPut, "/Observation/client-1", "", "client-1", 0, false},
+		{"conditional create matched", createOptions{IfNoneExist: "subject=Patient%2F1"}, http.StatusOK, `{"id":"obs-0"}`, http.MethodPost, "/Observation", "subject=Patient%2F1", "obs-0", 0, false},
+		{"PUT sends no If-None-Exist", createOptions{ID: "client-1", IfNoneExist: "subject=Patient%2F1"}, http.StatusCreated, `{"id":"client-1"}`, http.MethodPut, "/Observation/client-1", "", "client-1", 0, false},
+		{"server error", createOptions{}, http.StatusInternalServerError, `{"issue":[]}`, http.MethodPost, "/Observation", "", "", http.StatusInternalServerError, true},
+		{"missing ID", createOptions{}, http.StatusCreated, `{}`, http.MethodPost, "/Observation", "", "", 0, true},
+		{"malformed body", createOptions{}, http.StatusCreated, `not json`, http.MethodPost, "/Observation", "", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotMethod, gotPath, gotIfNoneExist string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotMethod, gotPath, gotIfNoneExist = r.Method, r.URL.Path, r.Header.Get("If-None-Exist")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+			cfg := &config.Config{OystehrFHIRBaseURL: server.URL, OystehrProjectID: "project"}
+
+			got, err := createResourceWith(server.Client(), cfg, "token", "Observation", map[string]string{"resourceType": "Observation"}, "1", tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("createResourceWith error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if gotMethod != tt.wantMethod || gotPath != tt.wantPath || gotIfNoneExist != tt.wantIfNoneExist {
+				t.Errorf("request = %s %s (If-None-Exist %q), want %s %s (%q)", gotMethod, gotPath, gotIfNoneExist, tt.wantMethod, tt.wantPath, tt.wantIfNoneExist)
+			}
+			if got.ID != tt.wantID {
+				t.Errorf("ID = %q, want %q", got.ID, tt.wantID)
+			}
+			var createErr *CreateError
+			if errors.As(err, &createErr) != (tt.wantStatus != 0) || (createErr != nil && createErr.StatusCode != tt.wantStatus) {
+				t.Errorf("error = %v, want a CreateError with status %d", err, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestCreatesAcceptOKWithID(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr bool
+	}{
+		{"201 Created", http.StatusCreated, `{"id":"new-1"}`, false},
+		{"200 OK", http.StatusOK, `{"id":"new-1"}`, false},
+		{"200 OK without an ID", http.StatusOK, `{"resourceType":"OperationOutcome"}`, true},
+		{"202 Accepted", http.StatusAccepted, `{"id":"new-1"}`, true},
+	}
+	creates := map[string]func(cfg *config.Config, client *http.Client) (Created, error){
+		"Observation": func(cfg *config.Config, client *http.Client) (Created, error) {
+			return CreateObservation(client, cfg, "token", "p1", 14, ObservationOptions{})
+		},
+		"Flag": func(cfg *config.Config, client *http.Client) (Created, error) {
+			return CreateFlag(client, cfg, "token", "p1", "", 14, 0, "epds", "", "")
+		},
+		"Communication": func(cfg *config.Config, client *http.Client) (Created, error) {
+			return CreateCommunication(client, cfg, "token", "p1", "Practitioner/provider-1", 14, 0, CommunicationOptions{})
+		},
+	}
+	for resourceType, create := range creates {
+		for _, tt := range tests {
+			t.Run(resourceType+"/"+tt.name, func(t *testing.T) {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(tt.status)
+					w.Write([]byte(tt.body))
+				}))
+				defer server.Close()
+
+				got, err := create(testConfig(t, server.URL), server.Client())
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Create%s error = %v, wantErr %t", resourceType, err, tt.wantErr)
+				}
+				if !tt.wantErr && got.ID != "new-1" {
+					t.Errorf("ID = %q, want new-1", got.ID)
+				}
+			})
+		}
+	}
+}
+
+// testConfig loads the configuration with the required settings, pointed at baseURL.
+func testConfig(t *testing.T, baseURL string) *config.Config {
+	t.Helper()
+	for name, value := range map[string]string{
+		"OYSTEHR_FHIR_BASE_URL":     baseURL,
+		"OYSTEHR_AUTH_URL":          baseURL + "/token",
+		"OYSTEHR_PROJECT_ID":        "project",
+		"OYSTEHR_M2M_CLIENT_ID":     "client",
+		"OYSTEHR_M2M_CLIENT_SECRET": "secret",
+		"ALERT_PROVIDER_FHIR_ID":    "Practitioner/provider-1",
+	} {
+		t.Setenv(name, value)
+	}
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	return cfg
+}
@@ -2,55 +2,120 @@ package fhir
 
 import (
     "encoding/json"
+    "errors"
     "fmt"
+    "log"
     "net/http"
+    "net/url"
+    "strconv"
+    "strings"
     "time"
 
     "example.com/epds-service/internal/config"
 )
 
 type bundle struct {
+    Link []struct {
+        Relation string `json:"relation"`
+        URL      string `json:"url"`
+    } `json:"link"`
     Entry []struct {
         Resource json.RawMessage `json:"resource"`
     } `json:"entry"`
 }
 type fhirID struct{ ID string `json:"id"` }
 
+// next returns the bundle's next-page link, or "" on the last page.
+func (b bundle) next() string {
+    for _, l := range b.Link {
+        if l.Relation == "next" { return l.URL }
+    }
+    return ""
+}
+
+// decodeEntries decodes each entry's resource into a T. A malformed entry is logged and
+// skipped so one bad record cannot fail a whole search; it is an error only when the
+// bundle has entries and none of them decodes.
+func decodeEntries[T any](b bundle, what string) ([]T, error) {
+    var out []T
+    for i, e := range b.Entry {
+        var v T
+        if err := json.Unmarshal(e.Resource, &v); err != nil { log.Printf("WARN: skipping malformed %s entry %d: %v", what, i, err); continue }
+        out = append(out, v)
+    }
+    if len(out) == 0 && len(b.Entry) > 0 { return nil, fmt.Errorf("%s search returned %d entries and none could be parsed", what, len(b.Entry)) }
+    return out, nil
+}
+
+// firstID returns the ID of the first entry that decodes and has one.
+func firstID(b bundle, what string) (string, error) {
+    entries, err := decodeEntries[fhirID](b, what)
+    if err != nil { return "", err }
+    for _, e := range entries {
+        if e.ID != "" { return e.ID, nil }
+    }
+    return "", fmt.Errorf("%s id missing", what)
+}
+
+// ErrPatientNotFound is returned when an identifier matches no Patient.
+var ErrPatientNotFound = errors.New("patient not found")
+
+// ErrAmbiguousPatient is returned when an identifier matches more than one distinct Patient.
+var ErrAmbiguousPatient = errors.New("identifier matches multiple patients")
+
+// ErrEncounterMismatch is returned when an Encounter's subject is not the expected Patient.
+var ErrEncounterMismatch = errors.New("encounter belongs to another patient")
+
+// IdentifierType narrows an identifier search to one identifier type, e.g.
+// http://terminology.hl7.org/CodeSystem/v2-0203|MR for medical record numbers.
+type IdentifierType struct { System, Code string }
+
 // GET /Patient?identifier={system}|{value}
 func FindPatientIDByIdentifier(httpClient *http.Client, cfg *config.Config, token, system, value string) (string, error) {
+    return FindPatientIDByIdentifierOfType(httpClient, cfg, token, system, value, IdentifierType{})
+}
+
+// GET /Patient?identifier={system}|{value}&identifier:of-type={type.system}|{type.code}|{value}
+// The of-type qualifier is only added when idType.Code is set.
+func FindPatientIDByIdentifierOfType(httpClient *http.Client, cfg *config.Config, token, system, value string, idType IdentifierType) (string, error) {
     if httpClient == nil { httpClient = &http.Client{Timeout: 10 * time.Second} }
-    u := fmt.Sprintf("%s/Patient?identifier=%s|%s", cfg.OystehrFHIRBaseURL, system, value)
+    u := fmt.Sprintf("%s/Patient?identifier=%s", baseURL(cfg, token), url.QueryEscape(system+"|"+value))
+    if idType.Code != "" { u += "&identifier:of-type=" + url.QueryEscape(idType.System+"|"+idType.Code+"|"+value) }
     req, _ := http.NewRequest(http.MethodGet, u, nil)
-    req.Header.Set("Authorization", "Bearer "+token)
-    req.Header.Set("x-zapehr-project-id", cfg.OystehrProjectID)
-    req.Header.Set("Accept", "application/fhir+json")
+    setFHIRHeaders(req, cfg, token)
 
-    resp, err := httpClient.Do(req)
+    resp, err := doRequest(httpClient, req)
     if err != nil { return "", fmt.Errorf("patient search failed: %w", err) }
     defer resp.Body.Close()
     if resp.StatusCode != http.StatusOK { return "", fmt.Errorf("patient search status %d", resp.StatusCode) }
 
     var b bundle
     if err := json.NewDecoder(resp.Body).Decode(&b); err != nil { return "", fmt.Errorf("patient bundle decode: %w", err) }
-    if len(b.Entry) == 0 { return "", fmt.Errorf("patient not found for %s|%s", system, value) }
+    if len(b.Entry) == 0 { return "", fmt.Errorf("%w for %s|%s", ErrPatientNotFound, system, value) }
 
-    var p fhirID
-    if err := json.Unmarshal(b.Entry[0].Resource, &p); err != nil { return "", fmt.Errorf("patient id parse: %w", err) }
-    if p.ID == "" { return "", fmt.Errorf("patient id missing") }
-    return p.ID, nil
+    // Every usable entry must resolve to the same Patient; otherwise we cannot safely pick one.
+    patients, err := decodeEntries[fhirID](b, "patient")
+    if err != nil { return "", err }
+    var id string
+    for _, p := range patients {
+        if p.ID == "" { log.Printf("WARN: skipping patient search entry without an id"); continue }
+        if id != "" && p.ID != id { return "", fmt.Errorf("%w: %s|%s (%s, %s)", ErrAmbiguousPatient, system, value, id, p.ID) }
+        id = p.ID
+    }
+    if id == "" { return "", fmt.Errorf("patient id missing") }
+    return id, nil
 }
 
 // GET /Encounter?appointment=Appointment/{id}&_sort=-date&_count=1
 func FindEncounterByAppointment(httpClient *http.Client, cfg *config.Config, token, appointmentID string) (string, error) {
     if httpClient == nil { httpClient = &http.Client{Timeout: 10 * time.Second} }
-    u := fmt.Sprintf("%s/Encounter?appointment=Appointment/%s&_sort=-date&_count=1",
-        cfg.OystehrFHIRBaseURL, appointmentID)
+    appt, err := buildReference("Appointment", appointmentID)
+    if err != nil { return "", err }
+    u := fmt.Sprintf("%s/Encounter?appointment=%s&_sort=-date&_count=1", baseURL(cfg, token), url.QueryEscape(appt))
     req, _ := http.NewRequest(http.MethodGet, u, nil)
-    req.Header.Set("Authorization", "Bearer "+token)
-    req.Header.Set("x-zapehr-project-id", cfg.OystehrProjectID)
-    req.Header.Set("Accept", "application/fhir+json")
+    setFHIRHeaders(req, cfg, token)
 
-    resp, err := httpClient.Do(req)
+    resp, err := doRequest(httpClient, req)
     if err != nil { return "", fmt.Errorf("encounter search by appointment failed: %w", err) }
     defer resp.Body.Close()
     if resp.StatusCode != http.StatusOK { return "", fmt.Errorf("encounter search by appointment status %d", resp.StatusCode) }
@@ -58,24 +123,47 @@ func FindEncounterByAppointment(httpClient *http.Client, cfg *config.Config, tok
     var b bundle
     if err := json.NewDecoder(resp.Body).Decode(&b); err != nil { return "", fmt.Errorf("encounter bundle decode: %w", err) }
     if len(b.Entry) == 0 { return "", fmt.Errorf("no encounter found for appointment %s", appointmentID) }
+    return firstID(b, "encounter")
+}
+
+// GET /Encounter/{id}, checking that its subject is Patient/{patientID}
+func VerifyEncounterSubject(httpClient *http.Client, cfg *config.Config, token, encounterID, patientID string) error {
+    if httpClient == nil { httpClient = &http.Client{Timeout: 10 * time.Second} }
+    u := fmt.Sprintf("%s/Encounter/%s", baseURL(cfg, token), url.PathEscape(encounterID))
+    req, _ := http.NewRequest(http.MethodGet, u, nil)
+    setFHIRHeaders(req, cfg, token)
 
-    var e fhirID
-    if err := json.Unmarshal(b.Entry[0].Resource, &e); err != nil { return "", fmt.Errorf("encounter id parse: %w", err) }
-    if e.ID == "" { return "", fmt.Errorf("encounter id missing") }
-    return e.ID, nil
+    resp, err := doRequest(httpClient, req)
+    if err != nil { return fmt.Errorf("encounter read failed: %w", err) }
+    defer resp.Body.Close()
+    if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone { return fmt.Errorf("encounter %s not found", encounterID) }
+    if resp.StatusCode != http.StatusOK { return fmt.Errorf("encounter read status %d", resp.StatusCode) }
+
+    var e struct {
+        ResourceType string        `json:"resourceType"`
+        Subject      fhirReference `json:"subject"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&e); err != nil { return fmt.Errorf("encounter decode: %w", err) }
+    if e.ResourceType != "Encounter" { return fmt.Errorf("encounter %s not found", encounterID) }
+    // The subject may be relative or absolute (REFERENCE_STYLE), or not a Patient at all
+    got, _ := buildReference("Patient", e.Subject.Reference)
+    want, err := buildReference("Patient", patientID)
+    if err != nil { return err }
+    if got != want { return fmt.Errorf("%w: Encounter/%s subject is %q", ErrEncounterMismatch, encounterID, e.Subject.Reference) }
+    return nil
 }
 
-// GET /Encounter?subject=Patient/{id}&status=planned,arrived,in-progress&_sort=-date&_count=1
+// GET /Encounter?subject=Patient/{id}&status=planned,arrived,in-progress&_sort=-date&_count=1 (no "arrived" on R5)
 func FindActiveEncounterID(httpClient *http.Client, cfg *config.Config, token, patientID string) (string, error) {
     if httpClient == nil { httpClient = &http.Client{Timeout: 10 * time.Second} }
-    u := fmt.Sprintf("%s/Encounter?subject=Patient/%s&status=planned,arrived,in-progress&_sort=-date&_count=1",
-        cfg.OystehrFHIRBaseURL, patientID)
+    subject, err := buildReference("Patient", patientID)
+    if err != nil { return "", err }
+    u := fmt.Sprintf("%s/Encounter?subject=%s&status=%s&_sort=-date&_count=1",
+        baseURL(cfg, token), url.QueryEscape(subject), encounterActiveStatuses(cfg))
     req, _ := http.NewRequest(http.MethodGet, u, nil)
-    req.Header.Set("Authorization", "Bearer "+token)
-    req.Header.Set("x-zapehr-project-id", cfg.OystehrProjectID)
-    req.Header.Set("Accept", "application/fhir+json")
+    setFHIRHeaders(req, cfg, token)
 
-    resp, err := httpClient.Do(req)
+    resp, err := doRequest(httpClient, req)
     if err != nil { return "", fmt.Errorf("encounter search failed: %w", err) }
     defer resp.Body.Close()
     if resp.StatusCode != http.StatusOK { return "", fmt.Errorf("encounter search status %d", resp.StatusCode) }
@@ -83,9 +171,286 @@ func FindActiveEncounterID(httpClient *http.Client, cfg *config.Config, token, p
     var b bundle
     if err := json.NewDecoder(resp.Body).Decode(&b); err != nil { return "", fmt.Errorf("encounter bundle decode: %w", err) }
     if len(b.Entry) == 0 { return "", fmt.Errorf("no active encounter found for patient %s", patientID) }
+    return firstID(b, "encounter")
+}
+
+// SearchOptions trims search results to the fields a caller needs (FHIR _summary/_elements),
+// which matters when polling many patients. The zero value returns full resources.
+type SearchOptions struct {
+    Summary  string   // _summary value, e.g. "true", "data" or "count"
+    Elements []string // _elements, e.g. valueInteger, effectiveDateTime
+}
+
+// apply appends the options to search URL u (which must already contain a query).
+func (o SearchOptions) apply(u string) string {
+    if o.Summary != "" { u += "&_summary=" + url.QueryEscape(o.Summary) }
+    if len(o.Elements) > 0 { u += "&_elements=" + url.QueryEscape(strings.Join(o.Elements, ",")) }
+    return u
+}
+
+// GET /Observation?subject=Patient/{id}&code={system}|{code}&_sort=-date&_count=1[&_summary=..][&_elements=..]
+// FindLatestObservation returns the patient's most recent Observation with the total-score code
+// (an instrument's TotalCode), or nil if there is none.
+func FindLatestObservation(httpClient *http.Client, cfg *config.Config, token, patientID string, code config.ItemCode, opts SearchOptions) (json.RawMessage, error) {
+    if httpClient == nil { httpClient = &http.Client{Timeout: 10 * time.Second} }
+    subject, err := buildReference("Patient", patientID)
+    if err != nil { return nil, err }
+    u := opts.apply(fmt.Sprintf("%s/Observation?subject=%s&code=%s&_sort=-date&_count=1",
+        baseURL(cfg, token), url.QueryEscape(subject), url.QueryEscape(code.System+"|"+code.Code)))
+    req, _ := http.NewRequest(http.MethodGet, u, nil)
+    setFHIRHeaders(req, cfg, token)
+
+    resp, err := doRequest(httpClient, req)
+    if err != nil { return nil, fmt.Errorf("observation search failed: %w", err) }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK { return nil, fmt.Errorf("observation search status %d", resp.StatusCode) }
+
+    var b bundle
+    if err := json.NewDecoder(resp.Body).Decode(&b); err != nil { return nil, fmt.Errorf("observation bundle decode: %w", err) }
+    if len(b.Entry) == 0 { return nil, nil }
+    return b.Entry[0].Resource, nil
+}
+
+// ErrInvalidPageURL is returned when a continuation URL is not a Flag search on the FHIR base URL.
+var ErrInvalidPageURL = errors.New("invalid page URL")
+
+// ActiveFlag summarizes one active high-risk Flag created by this service.
+type ActiveFlag struct {
+    FlagID      string `json:"flagId"`
+    PatientID   string `json:"patientId"`
+    Text        string `json:"text"`                 // Flag.code.text, e.g. "High EPDS Score (18) or Q10 Risk (0) indicated."
+    Severity    string `json:"severity,omitempty"`   // SeverityTagSystem tag; absent when the band was not mapped
+    Instrument  string `json:"instrument,omitempty"` // InstrumentTagSystem tag; absent on Flags raised before it was recorded
+    CreatedDate string `json:"createdDate"`          // meta.lastUpdated; the service never updates active Flags
+}
+
+// SeverityUnrated selects Flags without a severity tag in FlagQuery.Severity.
+const SeverityUnrated = "unrated"
+
+// FlagQuery narrows and orders the first page of an active Flag search.
+type FlagQuery struct {
+    Count    int    // _count; 0 leaves the page size to the server
+    Offset   int    // _offset: matching Flags to skip
+    Sort     string // FHIR _sort; default -_lastUpdated (newest first)
+    Severity string // Only Flags tagged with this severity, or SeverityUnrated; "" for all
+}
+
+// url builds the search for q under base.
+func (q FlagQuery) url(base string) string {
+    v := url.Values{"status": {"active"}, "_tag": {"urn:cornell:epds:tags|epds-high-risk"}, "_sort": {q.Sort}}
+    if q.Sort == "" { v.Set("_sort", "-_lastUpdated") }
+    if q.Count > 0 { v.Set("_count", strconv.Itoa(q.Count)) }
+    if q.Offset > 0 { v.Set("_offset", strconv.Itoa(q.Offset)) }
+    switch q.Severity {
+    case "":
+    case SeverityUnrated:
+        for severity := range config.FlagPriorityCodes { v.Add("_tag:not", SeverityTagSystem+"|"+severity) }
+    default:
+        v.Add("_tag", SeverityTagSystem+"|"+q.Severity)
+    }
+    return base + "/Flag?" + v.Encode()
+}
+
+// FlagPage is one page of active Flags. Next is the server's next-page URL, "" on the last page.
+type FlagPage struct {
+    Flags []ActiveFlag
+    Next  string
+}
 
-    var e fhirID
-    if err := json.Unmarshal(b.Entry[0].Resource, &e); err != nil { return "", fmt.Errorf("encounter id parse: %w", err) }
-    if e.ID == "" { return "", fmt.Errorf("encounter id missing") }
-    return e.ID, nil
-}
\ No newline at end of file
+// GET /Flag?status=active&_tag=urn:cornell:epds:tags|epds-high-risk&_sort=-_lastUpdated[&_count=][&_offset=]
+// FindActiveHighRiskFlags lists the active Flags carrying this service's meta.tag, as narrowed
+// and ordered by query. A non-empty pageURL continues from a previous FlagPage.Next instead.
+func FindActiveHighRiskFlags(httpClient *http.Client, cfg *config.Config, token string, query FlagQuery, pageURL string) (FlagPage, error) {
+    if httpClient == nil { httpClient = &http.Client{Timeout: 10 * time.Second} }
+    u := pageURL
+    if u == "" {
+        u = query.url(baseURL(cfg, token))
+    } else if !strings.HasPrefix(u, baseURL(cfg, token)+"/Flag?") {
+        return FlagPage{}, ErrInvalidPageURL // Never send the token anywhere else
+    }
+    req, _ := http.NewRequest(http.MethodGet, u, nil)
+    setFHIRHeaders(req, cfg, token)
+
+    resp, err := doRequest(httpClient, req)
+    if err != nil { return FlagPage{}, fmt.Errorf("flag search failed: %w", err) }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK { return FlagPage{}, fmt.Errorf("flag search status %d", resp.StatusCode) }
+
+    var b bundle
+    if err := json.NewDecoder(resp.Body).Decode(&b); err != nil { return FlagPage{}, fmt.Errorf("flag bundle decode: %w", err) }
+    flags, err := decodeEntries[struct {
+        ID      string        `json:"id"`
+        Subject fhirReference `json:"subject"`
+        Code    struct{ Text string `json:"text"` } `json:"code"`
+        Meta    struct {
+            LastUpdated string       `json:"lastUpdated"`
+            Tag         []fhirCoding `json:"tag"`
+        } `json:"meta"`
+    }](b, "flag")
+    if err != nil { return FlagPage{}, err }
+
+    page := FlagPage{Flags: []ActiveFlag{}, Next: b.next()}
+    for _, f := range flags {
+        // Subject may be relative or absolute (REFERENCE_STYLE); the ID follows "Patient/"
+        _, patientID, _ := strings.Cut(f.Subject.Reference, "Patient/")
+        flag := ActiveFlag{FlagID: f.ID, PatientID: patientID, Text: f.Code.Text, CreatedDate: f.Meta.LastUpdated}
+        for _, tag := range f.Meta.Tag {
+            if tag.System == SeverityTagSystem { flag.Severity = tag.Code }
+            if tag.System == InstrumentTagSystem { flag.Instrument = tag.Code }
+        }
+        page.Flags = append(page.Flags, flag)
+    }
+    return page, nil
+}
+
+// GET /Flag?status=active&_tag=urn:cornell:epds:tags|epds-high-risk&_lastUpdated=lt{before}&_count=100, following next links
+// FindStaleHighRiskFlags lists every active Flag from this service not updated since before.
+// All pages are read first, so callers may change the Flags without shifting the paging.
+func FindStaleHighRiskFlags(httpClient *http.Client, cfg *config.Config, token string, before time.Time) ([]ActiveFlag, error) {
+    u := fmt.Sprintf("%s/Flag?status=active&_tag=%s&_lastUpdated=lt%s&_count=100",
+        baseURL(cfg, token), url.QueryEscape("urn:cornell:epds:tags|epds-high-risk"), url.QueryEscape(before.UTC().Format(time.RFC3339)))
+    var found []ActiveFlag
+    for u != "" {
+        page, err := FindActiveHighRiskFlags(httpClient, cfg, token, FlagQuery{}, u)
+        if err != nil { return nil, err }
+        found = append(found, page.Flags...)
+        u = page.Next
+    }
+    return found, nil
+}
+
+// ScoreObservation is one total-score Observation found by FindScoreObservations.
+type ScoreObservation struct {
+    ID          string
+    PatientID   string
+    EncounterID string // Empty when the screen was not linked to a visit
+    Effective   string // effectiveDateTime as recorded
+    Total       int
+}
+
+// GET /Observation?code={system}|{code}&date=ge{from}&date=le{to}&_count=100, following next links
+// FindScoreObservations returns every Observation with the total-score code effective from
+// one date through another (YYYY-MM-DD, both inclusive).
+func FindScoreObservations(httpClient *http.Client, cfg *config.Config, token string, code config.ItemCode, from, to string) ([]ScoreObservation, error) {
+    if httpClient == nil { httpClient = &http.Client{Timeout: 10 * time.Second} }
+    u := fmt.Sprintf("%s/Observation?code=%s&date=ge%s&date=le%s&_count=100",
+        baseURL(cfg, token), url.QueryEscape(code.System+"|"+code.Code), url.QueryEscape(from), url.QueryEscape(to))
+    var found []ScoreObservation
+    for u != "" {
+        if !strings.HasPrefix(u, baseURL(cfg, token)+"/Observation?") { return nil, ErrInvalidPageURL }
+        req, _ := http.NewRequest(http.MethodGet, u, nil)
+        setFHIRHeaders(req, cfg, token)
+
+        resp, err := doRequest(httpClient, req)
+        if err != nil { return nil, fmt.Errorf("observation search failed: %w", err) }
+        var b bundle
+        if resp.StatusCode != http.StatusOK { resp.Body.Close(); return nil, fmt.Errorf("observation search status %d", resp.StatusCode) }
+        err = json.NewDecoder(resp.Body).Decode(&b)
+        resp.Body.Close()
+        if err != nil { return nil, fmt.Errorf("observation bundle decode: %w", err) }
+        entries, err := decodeEntries[struct {
+            ID                string         `json:"id"`
+            Subject           fhirReference  `json:"subject"`
+            Encounter         *fhirReference `json:"encounter"`
+            EffectiveDateTime string         `json:"effectiveDateTime"`
+            ValueInteger      *int           `json:"valueInteger"`
+        }](b, "observation")
+        if err != nil { return nil, err }
+
+        for _, e := range entries {
+            if e.ValueInteger == nil { continue } // Not a score we recorded
+            obs := ScoreObservation{ID: e.ID, Effective: e.EffectiveDateTime, Total: *e.ValueInteger}
+            _, obs.PatientID, _ = strings.Cut(e.Subject.Reference, "Patient/")
+            if e.Encounter != nil { _, obs.EncounterID, _ = strings.Cut(e.Encounter.Reference, "Encounter/") }
+            found = append(found, obs)
+        }
+        u = b.next()
+    }
+    return found, nil
+}
+
+// GET /Flag?subject=Patient/{id}&status=active&_tag=urn:cornell:epds:tags|epds-high-risk[&encounter=Encounter/{id}]&_count=1
+// HasActiveHighRiskFlag reports whether the patient has an active Flag from this service,
+// for the given Encounter when encounterID is set.
+func HasActiveHighRiskFlag(httpClient *http.Client, cfg *config.Config, token, patientID, encounterID string) (bool, error) {
+    if httpClient == nil { httpClient = &http.Client{Timeout: 10 * time.Second} }
+    subject, err := buildReference("Patient", patientID)
+    if err != nil { return false, err }
+    u := fmt.Sprintf("%s/Flag?subject=%s&status=active&_tag=%s&_count=1",
+        baseURL(cfg, token), url.QueryEscape(subject), url.QueryEscape("urn:cornell:epds:tags|epds-high-risk"))
+    if encounterID != "" {
+        enc, err := buildReference("Encounter", encounterID)
+        if err != nil { return false, err }
+        u += "&encounter=" + url.QueryEscape(enc)
+    }
+    req, _ := http.NewRequest(http.MethodGet, u, nil)
+    setFHIRHeaders(req, cfg, token)
+
+    resp, err := doRequest(httpClient, req)
+    if err != nil { return false, fmt.Errorf("flag search failed: %w", err) }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK { return false, fmt.Errorf("flag search status %d", resp.StatusCode) }
+
+    var b bundle
+    if err := json.NewDecoder(resp.Body).Decode(&b); err != nil { return false, fmt.Errorf("flag bundle decode: %w", err) }
+    return len(b.Entry) > 0, nil
+}
+
+// GET /Communication?subject=Patient/{id}&_tag=urn:cornell:epds:tags|{kind}&sent=ge{since}&_count=1
+// HasRecentCommunication reports whether the patient has a Communication from this service of
+// the same kind (high-risk alert or negative screen) sent since the given time. Communications in
+// preparation have no sent date, so with COMMUNICATION_STATUS=preparation _lastUpdated is used.
+func HasRecentCommunication(httpClient *http.Client, cfg *config.Config, token, patientID string, negativeScreen bool, since time.Time) (bool, error) {
+    if httpClient == nil { httpClient = &http.Client{Timeout: 10 * time.Second} }
+    subject, err := buildReference("Patient", patientID)
+    if err != nil { return false, err }
+    param := "sent"
+    if cfg.CommunicationStatus == config.CommunicationStatusPreparation { param = "_lastUpdated" }
+    u := fmt.Sprintf("%s/Communication?subject=%s&_tag=%s&%s=ge%s&_count=1", baseURL(cfg, token), url.QueryEscape(subject),
+        url.QueryEscape("urn:cornell:epds:tags|"+communicationTag(negativeScreen)), param, url.QueryEscape(fhirDateTime(cfg, since)))
+    req, _ := http.NewRequest(http.MethodGet, u, nil)
+    setFHIRHeaders(req, cfg, token)
+
+    resp, err := doRequest(httpClient, req)
+    if err != nil { return false, fmt.Errorf("communication search failed: %w", err) }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK { return false, fmt.Errorf("communication search status %d", resp.StatusCode) }
+
+    var b bundle
+    if err := json.NewDecoder(resp.Body).Decode(&b); err != nil { return false, fmt.Errorf("communication bundle decode: %w", err) }
+    return len(b.Entry) > 0, nil
+}
+
+// GET /QuestionnaireResponse?subject=Patient/{id}&authored={date}&_sort=-authored&_count=1
+// FindQuestionnaireAnswers returns the answers (q1 first) of the patient's latest
+// QuestionnaireResponse authored on date (YYYY-MM-DD), or nil if there is none.
+func FindQuestionnaireAnswers(httpClient *http.Client, cfg *config.Config, token, patientID, date string) ([]int, error) {
+    if httpClient == nil { httpClient = &http.Client{Timeout: 10 * time.Second} }
+    subject, err := buildReference("Patient", patientID)
+    if err != nil { return nil, err }
+    u := fmt.Sprintf("%s/QuestionnaireResponse?subject=%s&authored=%s&_sort=-authored&_count=1",
+        baseURL(cfg, token), url.QueryEscape(subject), url.QueryEscape(date))
+    req, _ := http.NewRequest(http.MethodGet, u, nil)
+    setFHIRHeaders(req, cfg, token)
+
+    resp, err := doRequest(httpClient, req)
+    if err != nil { return nil, fmt.Errorf("questionnaire response search failed: %w", err) }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK { return nil, fmt.Errorf("questionnaire response search status %d", resp.StatusCode) }
+
+    var b bundle
+    if err := json.NewDecoder(resp.Body).Decode(&b); err != nil { return nil, fmt.Errorf("questionnaire response bundle decode: %w", err) }
+    if len(b.Entry) == 0 { return nil, nil }
+    responses, err := decodeEntries[fhirQuestionnaireResponse](b, "questionnaire response")
+    if err != nil { return nil, err }
+    qr := responses[0]
+    answers := make([]int, len(qr.Item))
+    for _, item := range qr.Item {
+        var n int
+        if _, err := fmt.Sscanf(item.LinkID, "q%d", &n); err != nil || n < 1 || n > len(answers) || len(item.Answer) == 0 {
+            return nil, fmt.Errorf("questionnaire response item %q is not a scored answer", item.LinkID)
+        }
+        answers[n-1] = item.Answer[0].ValueInteger
+    }
+    return answers, nil
+}
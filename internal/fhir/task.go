@@ -0,0 +1,134 @@
+package fhir
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"example.com/epds-service/internal/config"
+)
+
+// fhirTask represents the follow-up Task created for high-risk screens.
+type fhirTask struct {
+	ResourceType string          `json:"resourceType"`
+	Status       string          `json:"status"`
+	Intent       string          `json:"intent"`
+	Priority     string          `json:"priority"`
+	Code         fhirCode        `json:"code"`
+	Description  string          `json:"description"`
+	For          fhirReference   `json:"for"`
+	Encounter    *fhirReference  `json:"encounter,omitempty"`
+	Focus        *fhirReference  `json:"focus,omitempty"`
+	Owner        *fhirReference  `json:"owner,omitempty"`
+	AuthoredOn   string          `json:"authoredOn"`
+	Restriction  fhirRestriction `json:"restriction"`
+}
+
+type fhirRestriction struct {
+	Period fhirPeriod `json:"period"` // period.end carries the follow-up due date
+}
+
+// CreateFollowUpTask sends a POST request to the Oystehr FHIR API to create a follow-up Task
+// for a high-risk screen, due at the given time and owned by the alert provider.
+// It returns the created Task or an error.
+func CreateFollowUpTask(httpClient *http.Client, cfg *config.Config, token string, patientID string, encounterID string, observationID string, due time.Time, selfHarm bool) (Created, error) {
+	if _, err := buildReference("Patient", patientID); err != nil {
+		return Created{}, fmt.Errorf("cannot create Task: %w", err)
+	}
+	// Use a default client if none is provided
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 15 * time.Second}
+	}
+
+	reason := "elevated EPDS total score"
+	priority := "urgent"
+	if selfHarm {
+		reason = "EPDS Q10 self-harm response"
+		priority = "asap"
+	}
+
+	// Construct the FHIR Task payload
+	task := fhirTask{
+		ResourceType: "Task",
+		Status:       "requested",
+		Intent:       "order",
+		Priority:     priority,
+		Code: fhirCode{
+			Coding: []fhirCoding{},
+			Text:   "EPDS high-risk follow-up",
+		},
+		Description: fmt.Sprintf("Follow up with patient after %s.", reason),
+		For:         reference(cfg, "Patient", patientID),
+		Owner:       refPtr(styledReference(cfg, cfg.AlertProviderFHIRID)),
+		AuthoredOn:  fhirDateTime(cfg, cfg.Now()),
+		Restriction: fhirRestriction{Period: fhirPeriod{End: fhirDateTime(cfg, due)}},
+	}
+	if encounterID != "" {
+		task.Encounter = refPtr(reference(cfg, "Encounter", encounterID))
+	}
+	if observationID != "" {
+		task.Focus = refPtr(reference(cfg, "Observation", observationID))
+	}
+
+	taskBytes, err := marshalResource(cfg, task)
+	if err != nil {
+		return Created{}, fmt.Errorf("failed to marshal FHIR Task JSON: %w", err)
+	}
+
+	// Construct the request URL
+	url := baseURL(cfg, token) + "/Task"
+
+	// Create the HTTP request
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(taskBytes))
+	if err != nil {
+		return Created{}, fmt.Errorf("failed to create FHIR Task request: %w", err)
+	}
+
+	req, cancel := withResourceTimeout(req, cfg, "Task")
+	defer cancel()
+
+	// Set required headers
+	setFHIRHeaders(req, cfg, token)
+
+	// Execute the request
+	log.Printf("Sending POST request to %s to create follow-up Task for Patient %s (due %s)", url, patientID, due.Format(time.RFC3339))
+	resp, err := doRequest(httpClient, req)
+	if err != nil {
+		return Created{}, fmt.Errorf("failed to execute FHIR Task request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, readErr := io.ReadAll(resp.Body)
+	if readErr != nil {
+		log.Printf("Warning: failed to read response body after status %d for Task creation: %v", resp.StatusCode, readErr)
+	}
+
+	// Check response status code
+	if !createSucceeded(resp.StatusCode) {
+		errBody := string(bodyBytes)
+		if errBody == "" && readErr != nil {
+			errBody = fmt.Sprintf("(could not read body: %v)", readErr)
+		}
+		log.Printf("ERROR: FHIR Task creation failed. Status: %d, Body: %s", resp.StatusCode, errBody)
+		return Created{}, &CreateError{ResourceType: "Task", StatusCode: resp.StatusCode, Request: taskBytes, Response: errBody}
+	}
+
+	// Parse the response body to get the created resource ID
+	var createdTask createdResource
+	if err := json.Unmarshal(bodyBytes, &createdTask); err != nil {
+		log.Printf("ERROR: Failed to unmarshal FHIR Task response body: %s. Error: %v", string(bodyBytes), err)
+		return Created{}, fmt.Errorf("failed to parse FHIR Task response body: %w", err)
+	}
+
+	if createdTask.ID == "" {
+		log.Printf("ERROR: FHIR Task created (%d) but response did not contain an ID. Body: %s", resp.StatusCode, string(bodyBytes))
+		return Created{}, fmt.Errorf("FHIR Task created but response missing ID")
+	}
+
+	log.Printf("Successfully created FHIR Task with ID: %s for Patient %s", createdTask.ID, patientID)
+	return Created{ID: createdTask.ID, Resource: bodyBytes}, nil
+}
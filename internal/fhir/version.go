@@ -0,0 +1,109 @@
+package fhir
+
+import (
+	"encoding/json"
+
+	"example.com/epds-service/internal/config"
+)
+
+// Resource builders produce R4 shapes. marshalResource serializes them for the configured
+// FHIR_VERSION, so the R4/R5 differences are kept here rather than in every builder.
+// Elements whose shape and value sets are the same in both versions (Observation, Flag,
+// Task, QuestionnaireResponse, Provenance, Patient, and Communication.status) pass through.
+
+// marshalResource encodes resource as JSON for cfg.FHIRVersion.
+func marshalResource(cfg *config.Config, resource any) ([]byte, error) {
+	b, err := json.Marshal(resource)
+	if err != nil || cfg.FHIRVersion != config.FHIRVersionR5 {
+		return b, err
+	}
+	var generic map[string]any
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return nil, err
+	}
+	toR5(generic)
+	return json.Marshal(generic)
+}
+
+// toR5 rewrites an R4 resource, decoded as generic JSON, in place into its R5 shape.
+func toR5(resource map[string]any) {
+	resourceType, _ := resource["resourceType"].(string)
+	switch resourceType {
+	case "Bundle":
+		// Convert the resources carried by a message or transaction Bundle
+		for _, entry := range objects(resource["entry"]) {
+			if inner, ok := entry["resource"].(map[string]any); ok {
+				toR5(inner)
+			}
+		}
+
+	case "Communication":
+		// R5 drops payload.contentString; free text moves to contentCodeableConcept.text
+		for _, payload := range objects(resource["payload"]) {
+			if text, ok := payload["contentString"]; ok {
+				delete(payload, "contentString")
+				payload["contentCodeableConcept"] = map[string]any{"text": text}
+			}
+		}
+
+	case "Encounter":
+		// class becomes a list of CodeableConcept, and period is renamed actualPeriod
+		if class, ok := resource["class"].(map[string]any); ok {
+			resource["class"] = []any{map[string]any{"coding": []any{class}}}
+		}
+		rename(resource, "period", "actualPeriod")
+
+	case "MessageHeader":
+		// source.endpoint is split into endpointUrl and endpointReference
+		if source, ok := resource["source"].(map[string]any); ok {
+			rename(source, "endpoint", "endpointUrl")
+		}
+
+	case "DocumentReference":
+		// context is now the list of related encounters; context.related moves to the top level
+		if context, ok := resource["context"].(map[string]any); ok {
+			delete(resource, "context")
+			if related, ok := context["related"]; ok {
+				resource["related"] = related
+			}
+		}
+	}
+}
+
+// rename moves m[from] to m[to] when present.
+func rename(m map[string]any, from, to string) {
+	if v, ok := m[from]; ok {
+		delete(m, from)
+		m[to] = v
+	}
+}
+
+// objects returns the JSON objects in a generic JSON array, skipping other values.
+func objects(v any) []map[string]any {
+	items, _ := v.([]any)
+	var out []map[string]any
+	for _, item := range items {
+		if obj, ok := item.(map[string]any); ok {
+			out = append(out, obj)
+		}
+	}
+	return out
+}
+
+// encounterActiveStatuses is the Encounter status search for a visit still under way.
+// R5 folded "arrived" into "in-progress".
+func encounterActiveStatuses(cfg *config.Config) string {
+	if cfg.FHIRVersion == config.FHIRVersionR5 {
+		return "planned,in-progress"
+	}
+	return "planned,arrived,in-progress"
+}
+
+// fhirMediaType is the Accept/Content-Type for FHIR requests. R5 requests name the
+// version so a multi-version server does not apply its R4 default.
+func fhirMediaType(cfg *config.Config) string {
+	if cfg.FHIRVersion == config.FHIRVersionR5 {
+		return "application/fhir+json; fhirVersion=5.0"
+	}
+	return "application/fhir+json"
+}
@@ -0,0 +1,216 @@
+// Package metrics is a minimal, dependency-free implementation of Prometheus
+// counters, gauges and histograms exposed in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// collector is anything that can write itself in the Prometheus text format.
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry holds a set of metrics to expose.
+type Registry struct {
+	mutex      sync.Mutex
+	collectors map[string]collector
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+// DefaultRegistry is the registry used by the New* constructors and Handler.
+var DefaultRegistry = NewRegistry()
+
+// register adds c, panicking on duplicate names (a programming error).
+func (r *Registry) register(c collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, exists := r.collectors[c.name()]; exists {
+		panic(fmt.Sprintf("metrics: duplicate metric %q", c.name()))
+	}
+	r.collectors[c.name()] = c
+}
+
+// Write writes all metrics, sorted by name, in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) {
+	r.mutex.Lock()
+	names := make([]string, 0, len(r.collectors))
+	for n := range r.collectors {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	collectors := make([]collector, 0, len(names))
+	for _, n := range names {
+		collectors = append(collectors, r.collectors[n])
+	}
+	r.mutex.Unlock()
+
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// Handler serves the default registry for Prometheus scraping.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		DefaultRegistry.Write(w)
+	})
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	metricName string
+	help       string
+	mutex      sync.Mutex
+	value      float64
+}
+
+// NewCounter creates and registers a counter in the default registry.
+func NewCounter(name, help string) *Counter {
+	c := &Counter{metricName: name, help: help}
+	DefaultRegistry.register(c)
+	return c
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add increments the counter by v (which must not be negative).
+func (c *Counter) Add(v float64) {
+	c.mutex.Lock()
+	c.value += v
+	c.mutex.Unlock()
+}
+
+// Value returns the current count.
+func (c *Counter) Value() float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.value
+}
+
+func (c *Counter) name() string { return c.metricName }
+
+func (c *Counter) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %s\n", c.metricName, c.help, c.metricName, c.metricName, formatFloat(c.Value()))
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	metricName string
+	help       string
+	buckets    []float64 // Sorted upper bounds, excluding +Inf
+
+	mutex  sync.Mutex
+	counts []uint64 // Per-bucket (non-cumulative) counts; last entry is +Inf
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates and registers a histogram with the given bucket upper bounds.
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	h := &Histogram{metricName: name, help: help, buckets: b, counts: make([]uint64, len(b)+1)}
+	DefaultRegistry.register(h)
+	return h
+}
+
+// Observe records one value.
+func (h *Histogram) Observe(v float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	i := sort.SearchFloat64s(h.buckets, v) // first bucket with upper bound >= v
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+func (h *Histogram) name() string { return h.metricName }
+
+func (h *Histogram) write(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.metricName, h.help, h.metricName)
+	var cumulative uint64
+	for i, upper := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.metricName, formatFloat(upper), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.metricName, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", h.metricName, formatFloat(h.sum), h.metricName, h.count)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	metricName string
+	help       string
+	mutex      sync.Mutex
+	value      float64
+}
+
+// NewGauge creates and registers a gauge in the default registry.
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{metricName: name, help: help}
+	DefaultRegistry.register(g)
+	return g
+}
+
+// Add changes the gauge by v, which may be negative.
+func (g *Gauge) Add(v float64) {
+	g.mutex.Lock()
+	g.value += v
+	g.mutex.Unlock()
+}
+
+// Value returns the current value.
+func (g *Gauge) Value() float64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.value
+}
+
+func (g *Gauge) name() string { return g.metricName }
+
+func (g *Gauge) write(w io.Writer) {
+	writeGauge(w, g.metricName, g.help, g.Value())
+}
+
+// GaugeFunc is a gauge whose value is computed when metrics are scraped.
+type GaugeFunc struct {
+	metricName string
+	help       string
+	fn         func() float64
+}
+
+// NewGaugeFunc creates and registers a gauge reporting fn() in the default registry.
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{metricName: name, help: help, fn: fn}
+	DefaultRegistry.register(g)
+	return g
+}
+
+func (g *GaugeFunc) name() string { return g.metricName }
+
+func (g *GaugeFunc) write(w io.Writer) {
+	writeGauge(w, g.metricName, g.help, g.fn())
+}
+
+func writeGauge(w io.Writer, name, help string, v float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, formatFloat(v))
+}
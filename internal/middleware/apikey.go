@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+)
+
+// APIKeyHeader is the request header carrying the client's API key.
+const APIKeyHeader = "X-API-Key"
+
+// APIKeyAuth gates handlers behind a static set of API keys. It is intentionally
+// simple so it can later be swapped for JWT/OAuth middleware with the same shape.
+type APIKeyAuth struct {
+	keys [][]byte
+}
+
+// NewAPIKeyAuth creates an API-key gate accepting any of keys.
+func NewAPIKeyAuth(keys []string) *APIKeyAuth {
+	a := &APIKeyAuth{}
+	for _, k := range keys {
+		a.keys = append(a.keys, []byte(k))
+	}
+	return a
+}
+
+// valid reports whether key matches a configured key, in constant time per key.
+func (a *APIKeyAuth) valid(key string) bool {
+	match := 0
+	for _, k := range a.keys {
+		match |= subtle.ConstantTimeCompare([]byte(key), k)
+	}
+	return match == 1
+}
+
+// Middleware rejects requests with a missing or unknown X-API-Key with 401 Unauthorized.
+func (a *APIKeyAuth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(APIKeyHeader)
+		if key == "" {
+			log.Printf("Rejected request for %s from %s: missing %s", r.URL.Path, r.RemoteAddr, APIKeyHeader)
+			writeJSONError(w, "missing API key", http.StatusUnauthorized)
+			return
+		}
+		if !a.valid(key) {
+			log.Printf("Rejected request for %s from %s: invalid API key", r.URL.Path, r.RemoteAddr)
+			writeJSONError(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// APIKeyOrClientIP keys requests by API key when present (hashed, so raw keys never
+// sit in memory maps or logs), falling back to the client IP. Only use it behind
+// APIKeyAuth: an unchecked header lets a client pick a fresh bucket per request.
+func APIKeyOrClientIP(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(sum[:8])
+	}
+	return ClientIP(r)
+}
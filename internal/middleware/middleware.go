@@ -0,0 +1,20 @@
+// Package middleware provides reusable http.Handler wrappers for the service's endpoints.
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// errorResponse mirrors the service's JSON error body.
+type errorResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// writeJSONError sends a JSON error body with the given status code.
+func writeJSONError(w http.ResponseWriter, message string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(errorResponse{Status: "error", Message: message})
+}
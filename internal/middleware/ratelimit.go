@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// KeyFunc extracts the identity a request is rate limited by.
+type KeyFunc func(r *http.Request) string
+
+// ClientIP keys requests by the remote IP address (without port).
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// RateLimiter is a per-key token-bucket limiter. Each key may burst up to Burst
+// requests and then refills at the configured rate.
+type RateLimiter struct {
+	ratePerSec float64
+	burst      float64
+	keyFunc    KeyFunc
+
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewRateLimiter creates a limiter allowing perMinute requests per minute per key,
+// with bursts of up to burst requests. keyFunc defaults to ClientIP when nil.
+func NewRateLimiter(perMinute int, burst int, keyFunc KeyFunc) *RateLimiter {
+	if keyFunc == nil {
+		keyFunc = ClientIP
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		ratePerSec: float64(perMinute) / 60,
+		burst:      float64(burst),
+		keyFunc:    keyFunc,
+		buckets:    make(map[string]*bucket),
+		lastSweep:  time.Now(),
+	}
+}
+
+// allow consumes a token for key. When no token is available it returns false and
+// how long until one will be.
+func (l *RateLimiter) allow(key string) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, lastSeen: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.lastSeen).Seconds()*l.ratePerSec)
+	b.lastSeen = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.ratePerSec * float64(time.Second))
+	return false, wait
+}
+
+// sweep drops buckets that have been idle long enough to be full again.
+// Caller must hold the mutex.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	idle := time.Duration(l.burst / l.ratePerSec * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > idle {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Middleware rejects requests over the limit with 429 Too Many Requests and a Retry-After header.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := l.keyFunc(r)
+		if ok, wait := l.allow(key); !ok {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			log.Printf("Rate limit exceeded for %s on %s; retry after %ds", key, r.URL.Path, retryAfter)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			writeJSONError(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
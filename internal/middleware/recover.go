@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"example.com/epds-service/internal/metrics"
+)
+
+// RequestIDHeader carries the ID that ties a request to its log lines. A client-supplied
+// value is kept; otherwise Recover generates one. It is echoed on every response.
+const RequestIDHeader = "X-Request-ID"
+
+var panicsRecovered = metrics.NewCounter(
+	"epds_http_panics_total",
+	"Requests whose handler panicked and were answered with 500 by the recovery middleware.",
+)
+
+// Recover keeps a panicking handler from taking down the server: it logs the panic with
+// the request ID and stack and answers 500 with a JSON error body (when nothing has been
+// written yet). http.ErrAbortHandler is re-raised so net/http can abort the response as intended.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		rw := &headerTrackingWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			panicsRecovered.Inc()
+			log.Printf("PANIC: request %s %s %s: %v\n%s", id, r.Method, r.URL.Path, v, debug.Stack())
+			if rw.wroteHeader {
+				return // The client already has a status; the truncated body is the best we can do
+			}
+			writeJSONError(w, "Internal server error (request "+id+")", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// headerTrackingWriter records whether the handler has started the response.
+type headerTrackingWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *headerTrackingWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headerTrackingWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *headerTrackingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// newRequestID returns 16 random hex characters.
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,160 @@
+// Package schema implements the subset of JSON Schema (draft 2020-12) the service uses to
+// describe and validate request bodies: type, properties, required, items, minItems,
+// maxItems, minimum, maximum, minLength, pattern and enum.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Draft is the $schema URI of the JSON Schema dialect these schemas follow.
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON Schema document (or subschema). It marshals to standard JSON Schema.
+type Schema struct {
+	Schema      string             `json:"$schema,omitempty"`
+	Title       string             `json:"title,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	MinItems    *int               `json:"minItems,omitempty"`
+	MaxItems    *int               `json:"maxItems,omitempty"`
+	Minimum     *int               `json:"minimum,omitempty"`
+	Maximum     *int               `json:"maximum,omitempty"`
+	MinLength   *int               `json:"minLength,omitempty"`
+	MaxLength   *int               `json:"maxLength,omitempty"`
+	Pattern     string             `json:"pattern,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+}
+
+// FieldError describes one validation failure. Field is a path such as "scores[3]".
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Int returns a pointer to n, for the optional numeric keywords.
+func Int(n int) *int {
+	return &n
+}
+
+// Validate checks v (as decoded by encoding/json with UseNumber) against s and returns
+// every failure found, ordered by field. An empty result means v is valid.
+func (s *Schema) Validate(v any) []FieldError {
+	var errs []FieldError
+	s.validate("", v, &errs)
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	return errs
+}
+
+func (s *Schema) validate(path string, v any, errs *[]FieldError) {
+	fail := func(format string, args ...any) {
+		field := path
+		if field == "" {
+			field = "(body)"
+		}
+		*errs = append(*errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.Type != "" && !hasType(v, s.Type) {
+		fail("must be of type %s", s.Type)
+		return
+	}
+
+	switch val := v.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := val[name]; !ok {
+				*errs = append(*errs, FieldError{Field: join(path, name), Message: "is required"})
+			}
+		}
+		for name, sub := range s.Properties {
+			if pv, ok := val[name]; ok {
+				sub.validate(join(path, name), pv, errs)
+			}
+		}
+	case []any:
+		if s.MinItems != nil && len(val) < *s.MinItems {
+			fail("must contain at least %d items, got %d", *s.MinItems, len(val))
+		}
+		if s.MaxItems != nil && len(val) > *s.MaxItems {
+			fail("must contain at most %d items, got %d", *s.MaxItems, len(val))
+		}
+		if s.Items != nil {
+			for i, item := range val {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case json.Number:
+		n, err := val.Float64()
+		if err != nil {
+			fail("must be a number")
+			return
+		}
+		if s.Minimum != nil && n < float64(*s.Minimum) {
+			fail("must be >= %d", *s.Minimum)
+		}
+		if s.Maximum != nil && n > float64(*s.Maximum) {
+			fail("must be <= %d", *s.Maximum)
+		}
+	case string:
+		if s.MinLength != nil && len([]rune(val)) < *s.MinLength {
+			fail("must be at least %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && len([]rune(val)) > *s.MaxLength {
+			fail("must be at most %d characters, got %d", *s.MaxLength, len([]rune(val)))
+		}
+		if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(val) {
+			fail("must match pattern %s", s.Pattern)
+		}
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, val) {
+			fail("must be one of %s", strings.Join(s.Enum, ", "))
+		}
+	}
+}
+
+// hasType reports whether v is an instance of the JSON Schema type t.
+func hasType(v any, t string) bool {
+	switch t {
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "null":
+		return v == nil
+	case "number":
+		_, ok := v.(json.Number)
+		return ok
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := n.Int64()
+		return err == nil
+	}
+	return false
+}
+
+// join appends a property name to a field path.
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,233 @@
+package scoring
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NumQuestions is the number of items on the EPDS questionnaire, the longest supported
+// instrument.
+const NumQuestions = 10
+
+// Q10Unanswered is Result.Q10 for a screen whose self-harm item was not answered
+// (see ScoreWithoutQ10).
+const Q10Unanswered = -1
+
+// Rules holds the per-item answer bounds and the thresholds used to decide
+// whether a screen is high risk.
+type Rules struct {
+	Questions         int    // Number of items; NumQuestions when zero
+	MinAnswer         int    // Lowest valid score for a single item
+	MaxAnswer         int    // Highest valid score for a single item
+	HighRiskThreshold int    // Total score compared against HighRiskOperator to decide high risk
+	HighRiskOperator  string // OperatorGTE (total >= threshold) or OperatorGT (total > threshold)
+	SelfHarmItem      int    // 1-based self-harm item (Q10 on the EPDS, item 9 on the PHQ-9); the last item when zero
+	Q10Threshold      int    // Self-harm item score at or above which a screen is high risk
+	Bands             []Band
+}
+
+// Comparison operators for Rules.HighRiskOperator. Published EPDS cutoffs differ on
+// whether the threshold itself is high risk.
+const (
+	OperatorGTE = "gte"
+	OperatorGT  = "gt"
+)
+
+// Band is one severity band of the total score, used to interpret a result.
+// Bands are ordered by ascending Max; a total above the last Max falls in the last band.
+type Band struct {
+	Max   int    // Highest total score in the band (inclusive)
+	Code  string // Machine-readable interpretation, e.g. "moderate"
+	Label string // Human-readable description
+}
+
+// DefaultBands returns the standard EPDS interpretation: 0-9, 10-12 and 13+.
+func DefaultBands() []Band {
+	return []Band{
+		{Max: 9, Code: "low", Label: "Depression not likely"},
+		{Max: 12, Code: "moderate", Label: "Possible depression"},
+		{Max: 30, Code: "high", Label: "Probable depression"},
+	}
+}
+
+// DefaultRules returns the standard EPDS scale (0..3 per item) and cutoffs (total >= 13 OR Q10 >= 1).
+func DefaultRules() Rules {
+	return Rules{
+		Questions:         NumQuestions,
+		MinAnswer:         0,
+		MaxAnswer:         3,
+		HighRiskThreshold: 13,
+		HighRiskOperator:  OperatorGTE,
+		SelfHarmItem:      NumQuestions,
+		Q10Threshold:      1,
+		Bands:             DefaultBands(),
+	}
+}
+
+// PHQ9Bands returns the standard PHQ-9 severity bands: 0-4, 5-9, 10-14, 15-19 and 20+.
+func PHQ9Bands() []Band {
+	return []Band{
+		{Max: 4, Code: "minimal", Label: "Minimal depression"},
+		{Max: 9, Code: "mild", Label: "Mild depression"},
+		{Max: 14, Code: "moderate", Label: "Moderate depression"},
+		{Max: 19, Code: "moderately-severe", Label: "Moderately severe depression"},
+		{Max: 27, Code: "severe", Label: "Severe depression"},
+	}
+}
+
+// PHQ9Rules returns the PHQ-9 scale (9 items, 0..3 each) with the usual positive-screen
+// cutoff (total >= 10) and any answer above 0 to item 9 (thoughts of self-harm).
+func PHQ9Rules() Rules {
+	return Rules{
+		Questions:         9,
+		MinAnswer:         0,
+		MaxAnswer:         3,
+		HighRiskThreshold: 10,
+		HighRiskOperator:  OperatorGTE,
+		SelfHarmItem:      9,
+		Q10Threshold:      1,
+		Bands:             PHQ9Bands(),
+	}
+}
+
+// NumItems returns the number of items answers must contain.
+func (r Rules) NumItems() int {
+	if r.Questions == 0 {
+		return NumQuestions
+	}
+	return r.Questions
+}
+
+// SelfHarmIndex returns the 0-based index of the self-harm item in answers.
+func (r Rules) SelfHarmIndex() int {
+	if r.SelfHarmItem == 0 {
+		return r.NumItems() - 1
+	}
+	return r.SelfHarmItem - 1
+}
+
+// MaxScore returns the highest possible total score.
+func (r Rules) MaxScore() int {
+	return r.NumItems() * r.MaxAnswer
+}
+
+// TotalHighRisk reports whether total alone makes a screen high risk.
+func (r Rules) TotalHighRisk(total int) bool {
+	if r.HighRiskOperator == OperatorGT {
+		return total > r.HighRiskThreshold
+	}
+	return total >= r.HighRiskThreshold
+}
+
+// SelfHarmPositive reports whether a self-harm item score alone triggers the alert. An
+// unanswered item (Q10Unanswered) counts, as in ScoreWithoutQ10.
+func (r Rules) SelfHarmPositive(q10 int) bool {
+	return q10 == Q10Unanswered || q10 >= r.Q10Threshold
+}
+
+// Interpret returns the band containing total.
+func (r Rules) Interpret(total int) Band {
+	for _, b := range r.Bands {
+		if total <= b.Max {
+			return b
+		}
+	}
+	if len(r.Bands) == 0 {
+		return Band{}
+	}
+	return r.Bands[len(r.Bands)-1]
+}
+
+// Result is the outcome of scoring one set of answers.
+type Result struct {
+	Total    int
+	Q10      int // Score of the self-harm item (Q10 on the EPDS)
+	HighRisk bool
+	SelfHarm bool // The self-harm item met its threshold, regardless of the total
+	Band     Band // Severity band of the total score (independent of Q10)
+}
+
+// Score sums the answers and applies the high-risk rules.
+// answers must contain rules.NumItems() already-validated item scores.
+func Score(answers []int, rules Rules) Result {
+	total := 0
+	for _, a := range answers {
+		total += a
+	}
+	q10 := answers[rules.SelfHarmIndex()]
+	selfHarm := q10 >= rules.Q10Threshold
+	return Result{
+		Total:    total,
+		Q10:      q10,
+		HighRisk: rules.TotalHighRisk(total) || selfHarm,
+		SelfHarm: selfHarm,
+		Band:     rules.Interpret(total),
+	}
+}
+
+// ScoreWithoutQ10 scores a screen whose self-harm item (Q10 on the EPDS) was not answered.
+// answers holds the other items with a 0 in place of the self-harm item. The missing item is treated
+// conservatively: the screen is high risk with self-harm assumed, so a clinician reviews
+// it, and Q10 is reported as Q10Unanswered.
+func ScoreWithoutQ10(answers []int, rules Rules) Result {
+	result := Score(answers, rules)
+	result.Q10 = Q10Unanswered
+	result.HighRisk = true
+	result.SelfHarm = true
+	return result
+}
+
+// ParseAnswers parses a comma-separated list of item scores (e.g. "1,2,0,...")
+// and validates the count and per-item range against rules.
+func ParseAnswers(s string, rules Rules) ([]int, error) {
+	parts := strings.Split(strings.TrimSpace(s), ",")
+	if len(parts) != rules.NumItems() {
+		return nil, fmt.Errorf("expected %d answers, got %d", rules.NumItems(), len(parts))
+	}
+	answers := make([]int, len(parts))
+	for i, p := range parts {
+		v, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return nil, fmt.Errorf("q%d must be an integer: %q", i+1, p)
+		}
+		if v < rules.MinAnswer || v > rules.MaxAnswer {
+			return nil, fmt.Errorf("q%d score must be between %d and %d", i+1, rules.MinAnswer, rules.MaxAnswer)
+		}
+		answers[i] = v
+	}
+	return answers, nil
+}
+
+// ParseBands parses a comma-separated list of "max:code[:label]" bands
+// (e.g. "9:low:Depression not likely,12:moderate,30:high"). Max values must ascend.
+func ParseBands(s string) ([]Band, error) {
+	var bands []Band
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 3)
+		if len(parts) < 2 || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("band %q must have the form max:code[:label]", item)
+		}
+		max, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, fmt.Errorf("band %q: max must be an integer", item)
+		}
+		if len(bands) > 0 && max <= bands[len(bands)-1].Max {
+			return nil, fmt.Errorf("band %q: max values must be in ascending order", item)
+		}
+		b := Band{Max: max, Code: strings.TrimSpace(parts[1])}
+		b.Label = b.Code
+		if len(parts) == 3 && strings.TrimSpace(parts[2]) != "" {
+			b.Label = strings.TrimSpace(parts[2])
+		}
+		bands = append(bands, b)
+	}
+	if len(bands) == 0 {
+		return nil, fmt.Errorf("at least one band is required")
+	}
+	return bands, nil
+}
@@ -0,0 +1,37 @@
+package scoring
+
+import "testing"
+
+func TestHighRiskOperatorAtThreshold(t *testing.T) {
+	tests := []struct {
+		name     string
+		operator string
+		answers  []int // Q10 is 0, so only the total decides
+		want     bool
+	}{
+		{"gte, one below", OperatorGTE, []int{3, 3, 3, 3, 0, 0, 0, 0, 0, 0}, false},
+		{"gte, exactly at threshold", OperatorGTE, []int{3, 3, 3, 3, 1, 0, 0, 0, 0, 0}, true},
+		{"gte, one above", OperatorGTE, []int{3, 3, 3, 3, 2, 0, 0, 0, 0, 0}, true},
+		{"gt, one below", OperatorGT, []int{3, 3, 3, 3, 0, 0, 0, 0, 0, 0}, false},
+		{"gt, exactly at threshold", OperatorGT, []int{3, 3, 3, 3, 1, 0, 0, 0, 0, 0}, false},
+		{"gt, one above", OperatorGT, []int{3, 3, 3, 3, 2, 0, 0, 0, 0, 0}, true},
+		{"unset operator is gte", "", []int{3, 3, 3, 3, 1, 0, 0, 0, 0, 0}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := DefaultRules()
+			rules.HighRiskOperator = tt.operator
+
+			result := Score(tt.answers, rules)
+			if result.HighRisk != tt.want {
+				t.Errorf("Score(total %d) HighRisk = %t, want %t", result.Total, result.HighRisk, tt.want)
+			}
+			if result.SelfHarm {
+				t.Errorf("Score(total %d) SelfHarm = true with Q10 = 0", result.Total)
+			}
+			if got := rules.TotalHighRisk(result.Total); got != tt.want {
+				t.Errorf("TotalHighRisk(%d) = %t, want %t", result.Total, got, tt.want)
+			}
+		})
+	}
+}
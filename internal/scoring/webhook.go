@@ -0,0 +1,72 @@
+package scoring
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// WebhookRequest is the body POSTed to an external scoring service (SCORING_WEBHOOK_URL).
+type WebhookRequest struct {
+	Instrument string `json:"instrument"` // Instrument name, e.g. "epds" or "phq9"
+	Answers    []*int `json:"answers"`    // Item scores, q1 first; null for an unanswered item
+}
+
+// WebhookResult is the external scoring service's determination for a screen.
+type WebhookResult struct {
+	Total      int    // Total score
+	HighRisk   bool   // Whether the screen needs a Flag and provider alert
+	FlagReason string // Optional Flag text explaining the determination
+}
+
+// Webhook scores screens with an external clinical-rules engine instead of Rules.
+type Webhook struct {
+	URL    string
+	Client *http.Client // Should carry a timeout; http.DefaultClient when nil
+}
+
+// Score POSTs the answers to the webhook and returns its determination. The response
+// must be 200 with a JSON body carrying at least total and highRisk; anything else is an
+// error, and the caller decides whether to fall back to local scoring.
+func (w *Webhook) Score(ctx context.Context, instrument string, answers []*int) (WebhookResult, error) {
+	body, err := json.Marshal(WebhookRequest{Instrument: instrument, Answers: answers})
+	if err != nil {
+		return WebhookResult{}, fmt.Errorf("failed to marshal scoring request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return WebhookResult{}, fmt.Errorf("failed to create scoring request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return WebhookResult{}, fmt.Errorf("scoring webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return WebhookResult{}, fmt.Errorf("scoring webhook returned status %d: %s", resp.StatusCode, msg)
+	}
+
+	var out struct {
+		Total      *int   `json:"total"`
+		HighRisk   *bool  `json:"highRisk"`
+		FlagReason string `json:"flagReason"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return WebhookResult{}, fmt.Errorf("invalid scoring webhook response: %w", err)
+	}
+	if out.Total == nil || out.HighRisk == nil {
+		return WebhookResult{}, fmt.Errorf("scoring webhook response must include total and highRisk")
+	}
+	return WebhookResult{Total: *out.Total, HighRisk: *out.HighRisk, FlagReason: out.FlagReason}, nil
+}
@@ -0,0 +1,122 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the upstream while its circuit is open.
+var ErrCircuitOpen = errors.New("upstream circuit breaker is open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuit tracks the health of a single upstream host.
+type circuit struct {
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// breakerTransport fails fast once an upstream host has produced threshold consecutive
+// failures (network errors or 5xx). After cooldown a single probe request is let through
+// (half-open); its success closes the circuit and its failure re-opens it.
+type breakerTransport struct {
+	base      http.RoundTripper
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mutex    sync.Mutex
+	circuits map[string]*circuit
+}
+
+func newBreakerTransport(base http.RoundTripper, threshold int, cooldown time.Duration, now func() time.Time) *breakerTransport {
+	return &breakerTransport{
+		base:      base,
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       now,
+		circuits:  make(map[string]*circuit),
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if err := t.allow(host); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	t.record(host, err != nil || resp.StatusCode >= 500)
+	return resp, err
+}
+
+// allow reports whether a request to host may proceed, moving an expired open circuit to half-open.
+func (t *breakerTransport) allow(host string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	c := t.circuits[host]
+	if c == nil {
+		c = &circuit{}
+		t.circuits[host] = c
+	}
+
+	switch c.state {
+	case breakerOpen:
+		if t.now().Sub(c.openedAt) < t.cooldown {
+			return fmt.Errorf("%w for %s", ErrCircuitOpen, host)
+		}
+		log.Printf("Circuit breaker for %s is half-open; sending probe request", host)
+		c.state = breakerHalfOpen
+		c.probing = true
+	case breakerHalfOpen:
+		// Only the single probe may be in flight
+		if c.probing {
+			return fmt.Errorf("%w for %s", ErrCircuitOpen, host)
+		}
+		c.probing = true
+	}
+	return nil
+}
+
+// record updates the circuit for host with the outcome of a request.
+func (t *breakerTransport) record(host string, failed bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	c := t.circuits[host]
+	c.probing = false
+	if !failed {
+		if c.state != breakerClosed {
+			log.Printf("Circuit breaker for %s closed; upstream recovered", host)
+		}
+		c.state = breakerClosed
+		c.failures = 0
+		return
+	}
+
+	c.failures++
+	if c.state == breakerHalfOpen || c.failures >= t.threshold {
+		if c.state != breakerOpen {
+			log.Printf("ERROR: Circuit breaker for %s opened after %d consecutive failures; failing fast for %s", host, c.failures, t.cooldown)
+		}
+		c.state = breakerOpen
+		c.openedAt = t.now()
+	}
+}
@@ -0,0 +1,64 @@
+package transport
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+)
+
+// loggedCreates are the request path endings whose bodies WithPayloadLog logs: the
+// Observation/Flag/Communication creates, or the message Bundle carrying them.
+var loggedCreates = map[string]bool{"Observation": true, "Flag": true, "Communication": true, "$process-message": true}
+
+// patientReference matches relative and absolute Patient references in a payload.
+var patientReference = regexp.MustCompile(`Patient/[^"\s/?]+`)
+
+// payloadLogTransport logs outgoing create payloads with patient identifiers redacted.
+type payloadLogTransport struct {
+	base      http.RoundTripper
+	patientID *regexp.Regexp // The bare patient ID as a whole word; nil when unknown
+}
+
+// WithPayloadLog returns a copy of client that logs, at DEBUG, the JSON body of each
+// Observation, Flag and Communication it creates (DEBUG_PAYLOAD_SAMPLE_RATE). Patient
+// references and every occurrence of patientID, such as in alert text, are redacted.
+func WithPayloadLog(client *http.Client, patientID string) *http.Client {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t := &payloadLogTransport{base: base}
+	if patientID != "" {
+		t.patientID = regexp.MustCompile(`\b` + regexp.QuoteMeta(patientID) + `\b`)
+	}
+	c := *client
+	c.Transport = t
+	return &c
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *payloadLogTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resource := path.Base(req.URL.Path)
+	if req.Method == http.MethodPost && loggedCreates[resource] && req.GetBody != nil {
+		// Read a copy so the request body is left for the base transport
+		if body, err := req.GetBody(); err == nil {
+			payload, err := io.ReadAll(body)
+			body.Close()
+			if err == nil {
+				log.Printf("DEBUG: sampled %s payload: %s", resource, t.redact(string(payload)))
+			}
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// redact removes patient identifiers from payload.
+func (t *payloadLogTransport) redact(payload string) string {
+	payload = patientReference.ReplaceAllString(payload, "Patient/[redacted]")
+	if t.patientID != nil {
+		payload = t.patientID.ReplaceAllString(payload, "[redacted]")
+	}
+	return payload
+}
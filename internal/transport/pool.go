@@ -0,0 +1,113 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+
+	"example.com/epds-service/internal/metrics"
+)
+
+// Connection pool metrics for the shared outbound transport, for sizing
+// HTTP_MAX_IDLE_CONNS_PER_HOST against Oystehr's connection limits.
+var (
+	poolOpenConns = metrics.NewGauge(
+		"epds_upstream_connections_open",
+		"Outbound connections (to Oystehr auth, FHIR or the proxy) currently open.",
+	)
+	poolInUse = metrics.NewGauge(
+		"epds_upstream_requests_in_flight",
+		"Outbound requests holding a connection until their response body is closed.",
+	)
+	poolNewConns = metrics.NewCounter(
+		"epds_upstream_connections_new_total",
+		"Outbound requests that had to open a new connection.",
+	)
+	poolReusedConns = metrics.NewCounter(
+		"epds_upstream_connections_reused_total",
+		"Outbound requests served on a pooled connection.",
+	)
+	_ = metrics.NewGaugeFunc(
+		"epds_upstream_connections_idle",
+		"Open outbound connections not serving a request (approximate; HTTP/2 connections carry several requests).",
+		func() float64 { return max(poolOpenConns.Value()-poolInUse.Value(), 0) },
+	)
+)
+
+// instrumentPool makes base count the connections it opens and closes.
+func instrumentPool(base *http.Transport) {
+	dial := base.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	base.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		poolOpenConns.Add(1)
+		return &countedConn{Conn: conn}, nil
+	}
+}
+
+// countedConn decrements the open-connection gauge once when closed.
+type countedConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { poolOpenConns.Add(-1) })
+	return c.Conn.Close()
+}
+
+// poolTraceTransport records, per request, whether a pooled connection was reused
+// and holds the in-flight gauge until the response body is closed.
+type poolTraceTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *poolTraceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var holding atomic.Bool
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if !holding.CompareAndSwap(false, true) {
+				return
+			}
+			if info.Reused {
+				poolReusedConns.Inc()
+			} else {
+				poolNewConns.Inc()
+			}
+			poolInUse.Add(1)
+		},
+	}
+	resp, err := t.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	release := sync.OnceFunc(func() {
+		if holding.Load() {
+			poolInUse.Add(-1)
+		}
+	})
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releasingBody calls release when the response body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	b.release()
+	return b.ReadCloser.Close()
+}
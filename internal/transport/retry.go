@@ -0,0 +1,129 @@
+package transport
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errNoRewind = errors.New("cannot retry request: body is not rewindable")
+
+// RetryBudget bounds the total number of retries across every outbound call made
+// while handling one submission, so a partial outage can't multiply latency per call.
+type RetryBudget struct {
+	mutex     sync.Mutex
+	remaining int
+}
+
+// NewRetryBudget creates a budget allowing up to max retries in total.
+func NewRetryBudget(max int) *RetryBudget {
+	return &RetryBudget{remaining: max}
+}
+
+// take consumes one retry, reporting false when the budget is exhausted.
+func (b *RetryBudget) take() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.remaining <= 0 {
+		return false
+	}
+	b.remaining--
+	return true
+}
+
+// retryTransport retries transient failures (network errors, 429, 502, 503, 504)
+// with exponential backoff, drawing every retry from a shared RetryBudget. Requests that
+// are not idempotent (creates) are only retried when they never reached the server,
+// since a timeout after the server committed the write would otherwise duplicate it.
+type retryTransport struct {
+	base   http.RoundTripper
+	budget *RetryBudget
+}
+
+// WithRetryBudget returns a copy of client whose requests are retried on transient
+// failures while budget lasts. The underlying transport (and its connection pool) is shared.
+func WithRetryBudget(client *http.Client, budget *RetryBudget) *http.Client {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	c := *client
+	c.Transport = &retryTransport{base: base, budget: budget}
+	return &c
+}
+
+// RoundTrip implements http.RoundTripper. Each attempt sends a clone of req with a
+// fresh body, so the caller's request is never modified.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := 200 * time.Millisecond
+	safe := idempotent(req)
+	for attempt := 0; ; attempt++ {
+		// Note whether the request was written, to tell "never sent" apart from "no answer"
+		var wrote atomic.Bool
+		ctx := httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+			WroteHeaders: func() { wrote.Store(true) },
+		})
+		attemptReq := req.Clone(ctx)
+		if attempt > 0 && req.Body != nil {
+			// Rewind the body for the retry; requests built from bytes buffers support this
+			if req.GetBody == nil {
+				return nil, errNoRewind
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(attemptReq)
+		if !retryable(resp, err) || (!safe && wrote.Load()) || req.Context().Err() != nil || !t.budget.take() {
+			return resp, err
+		}
+
+		if err != nil {
+			log.Printf("Retrying %s %s after error (attempt %d): %v", req.Method, req.URL.Path, attempt+1, err)
+		} else {
+			log.Printf("Retrying %s %s after status %d (attempt %d)", req.Method, req.URL.Path, resp.StatusCode, attempt+1)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		if backoff < 2*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// idempotent reports whether req can safely be sent twice: reads, PUTs (updates and
+// client-assigned IDs) and conditional creates, which the server resolves to one resource.
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	case http.MethodPost:
+		return req.Header.Get("If-None-Exist") != ""
+	}
+	return false
+}
+
+// retryable reports whether a response/error is worth retrying.
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		// An open circuit will not close within our backoff window
+		return !errors.Is(err, ErrCircuitOpen)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
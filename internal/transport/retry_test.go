@@ -0,0 +1,85 @@
+package transport
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// countingTransport counts the attempts that reach the base transport.
+type countingTransport struct {
+	base     http.RoundTripper
+	attempts atomic.Int32
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.attempts.Add(1)
+	return t.base.RoundTrip(req)
+}
+
+// hangUp reads the request and drops the connection without answering, like a
+// server that committed the write and then timed out.
+func hangUp(w http.ResponseWriter, r *http.Request) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err == nil {
+		conn.Close()
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(hangUp))
+	defer server.Close()
+
+	// A port nothing listens on: the request never reaches a server
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedURL := "http://" + listener.Addr().String()
+	listener.Close()
+
+	tests := []struct {
+		name         string
+		method       string
+		url          string
+		ifNoneExist  bool
+		wantAttempts int32
+	}{
+		{"GET is retried", http.MethodGet, server.URL, false, 3},
+		{"PUT is retried", http.MethodPut, server.URL, false, 3},
+		{"POST that reached the server is not retried", http.MethodPost, server.URL, false, 1},
+		{"conditional POST is retried", http.MethodPost, server.URL, true, 3},
+		{"POST that was never sent is retried", http.MethodPost, closedURL, false, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := &countingTransport{base: &http.Transport{DisableKeepAlives: true}}
+			rt := &retryTransport{base: base, budget: NewRetryBudget(2)}
+
+			body := bytes.NewReader([]byte(`{"resourceType":"Observation"}`))
+			req, err := http.NewRequest(tt.method, tt.url+"/Observation", body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.ifNoneExist {
+				req.Header.Set("If-None-Exist", "identifier=x")
+			}
+			originalBody := req.Body
+
+			resp, err := rt.RoundTrip(req)
+			if err == nil {
+				resp.Body.Close()
+				t.Fatalf("RoundTrip succeeded, want an error")
+			}
+			if got := base.attempts.Load(); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+			if req.Body != originalBody {
+				t.Errorf("RoundTrip replaced the caller's request body")
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"example.com/epds-service/internal/auth"
+)
+
+// tokenTransport retries a request once with a fresh access token when the server
+// answers 401, e.g. because the cached token expired early by the server's clock.
+// Later requests still carrying the rejected token are sent with the fresh one.
+type tokenTransport struct {
+	base   http.RoundTripper
+	tokens auth.TokenProvider
+
+	mutex    sync.Mutex
+	rejected string // Token the server answered 401 to
+	fresh    string // Token that replaced it
+}
+
+// WithTokenRefresh returns a copy of client that reports 401 responses to tokens and
+// retries the request once with a newly fetched token.
+func WithTokenRefresh(client *http.Client, tokens auth.TokenProvider) *http.Client {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	c := *client
+	c.Transport = &tokenTransport{base: base, tokens: tokens}
+	return &c
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	t.mutex.Lock()
+	if token == t.rejected {
+		req = withToken(req, t.fresh)
+		token = t.fresh
+	}
+	t.mutex.Unlock()
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || (req.Body != nil && req.GetBody == nil) {
+		return resp, err
+	}
+
+	t.tokens.Reject(token)
+	fresh, tokenErr := t.tokens.GetAuthToken()
+	if tokenErr != nil {
+		log.Printf("ERROR: Token refresh after 401 failed: %v", tokenErr)
+		return resp, nil
+	}
+	if fresh == token {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	t.mutex.Lock()
+	t.rejected, t.fresh = token, fresh
+	t.mutex.Unlock()
+
+	retry := withToken(req, fresh)
+	if req.Body != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("Retrying %s %s with a refreshed token after 401", req.Method, req.URL.Path)
+	return t.base.RoundTrip(retry)
+}
+
+// withToken returns a copy of req authorized with token.
+func withToken(req *http.Request, token string) *http.Request {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
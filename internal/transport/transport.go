@@ -0,0 +1,85 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"example.com/epds-service/internal/config"
+)
+
+// NewHTTPClient builds the shared outbound HTTP client used for both Oystehr auth and FHIR calls.
+// If OYSTEHR_PROXY_URL is configured all traffic goes through it; otherwise the standard
+// HTTPS_PROXY/HTTP_PROXY/NO_PROXY environment variables are honored. Each upstream host is
+// guarded by a circuit breaker unless CIRCUIT_BREAKER_THRESHOLD is 0. The client timeout is
+// the longest FHIR timeout; creates apply their own per-resource deadlines. The connection
+// pool is sized by HTTP_MAX_IDLE_CONNS_PER_HOST and HTTP_IDLE_CONN_TIMEOUT_SECONDS, and its
+// activity is exported at /metrics.
+func NewHTTPClient(cfg *config.Config) (*http.Client, error) {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	base.MaxIdleConns = max(base.MaxIdleConns, cfg.MaxIdleConnsPerHost)
+	base.IdleConnTimeout = cfg.IdleConnTimeout
+	instrumentPool(base)
+
+	if cfg.OystehrProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.OystehrProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OYSTEHR_PROXY_URL: %w", err)
+		}
+		base.Proxy = http.ProxyURL(proxyURL)
+		log.Printf("Outbound requests will use proxy %s", proxyURL.Redacted())
+	} else {
+		base.Proxy = http.ProxyFromEnvironment
+	}
+
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	base.TLSClientConfig = tlsConfig
+
+	var rt http.RoundTripper = &poolTraceTransport{base: base}
+	if cfg.CircuitBreakerThreshold > 0 {
+		rt = newBreakerTransport(rt, cfg.CircuitBreakerThreshold, cfg.CircuitBreakerCooldown, cfg.Now)
+	}
+
+	return &http.Client{
+		Transport: rt,
+		Timeout:   cfg.MaxFHIRTimeout(),
+	}, nil
+}
+
+// newTLSConfig builds the TLS settings for outbound calls: no version below
+// OYSTEHR_TLS_MIN_VERSION, optionally trusting an extra CA bundle (OYSTEHR_CA_BUNDLE)
+// on top of the system roots.
+func newTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: cfg.TLSMinVersion}
+
+	if cfg.OystehrCABundle != "" {
+		pemBytes, err := os.ReadFile(cfg.OystehrCABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read OYSTEHR_CA_BUNDLE: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pemBytes) {
+			return nil, fmt.Errorf("OYSTEHR_CA_BUNDLE %s contains no valid PEM certificates", cfg.OystehrCABundle)
+		}
+		tlsConfig.RootCAs = pool
+		log.Printf("Trusting additional CA certificates from %s", cfg.OystehrCABundle)
+	}
+
+	if cfg.OystehrInsecureSkipVerify {
+		log.Println("WARNING: *** OYSTEHR_INSECURE_SKIP_VERIFY is enabled - TLS certificates are NOT verified. NEVER use this outside local development. ***")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,68 @@
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"example.com/epds-service/internal/auth"
+	"example.com/epds-service/internal/config"
+)
+
+func TestNewHTTPClientUsesProxy(t *testing.T) {
+	// The proxy answers for the upstream hosts, which do not resolve
+	var mu sync.Mutex
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.Method+" "+r.URL.String())
+		mu.Unlock()
+		if r.URL.Path == "/token" {
+			w.Write([]byte(`{"access_token":"proxied-token","token_type":"Bearer","expires_in":3600}`))
+			return
+		}
+		w.Write([]byte(`{"resourceType":"Bundle"}`))
+	}))
+	defer proxy.Close()
+
+	cfg := &config.Config{
+		OystehrProxyURL:    proxy.URL,
+		OystehrAuthURL:     "http://auth.example.invalid/token",
+		OystehrFHIRBaseURL: "http://fhir.example.invalid/r4",
+		OystehrProjectID:   "project",
+	}
+	client, err := NewHTTPClient(cfg)
+	if err != nil {
+		t.Fatalf("NewHTTPClient error: %v", err)
+	}
+
+	// Auth and FHIR calls share the client, so both go through the proxy
+	token, err := auth.NewAuthenticator(cfg, client).GetAuthToken()
+	if err != nil || token != "proxied-token" {
+		t.Fatalf("GetAuthToken = %q, %v; want the proxied token", token, err)
+	}
+	resp, err := client.Get(cfg.OystehrFHIRBaseURL + "/Patient?_count=1")
+	if err != nil {
+		t.Fatalf("FHIR request error: %v", err)
+	}
+	resp.Body.Close()
+
+	want := []string{"POST http://auth.example.invalid/token", "GET http://fhir.example.invalid/r4/Patient?_count=1"}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(proxied) != len(want) {
+		t.Fatalf("proxy saw %v, want %v", proxied, want)
+	}
+	for i := range want {
+		if proxied[i] != want[i] {
+			t.Errorf("proxied request %d = %q, want %q", i, proxied[i], want[i])
+		}
+	}
+}
+
+func TestNewHTTPClientRejectsInvalidProxyURL(t *testing.T) {
+	if _, err := NewHTTPClient(&config.Config{OystehrProxyURL: "://no-scheme"}); err == nil {
+		t.Error("NewHTTPClient accepted an invalid OYSTEHR_PROXY_URL")
+	}
+}
@@ -51,9 +51,47 @@ echo "curl -X POST $BASE_URL$ENDPOINT \\"
 echo "  -d \"q1=1&q2=1&q3=1&q4=1&q5=1&q6=1&q7=1&q8=1&q9=1&q10=1\""
 echo ""
 
+# Test F: patientId and identifier that disagree
+echo "Test F: patientId and identifier resolving to different patients (should return 409)"
+echo "curl -X POST $BASE_URL$ENDPOINT \\"
+echo "  -d \"patientId=<PATIENT_UUID>\" \\"
+echo "  -d \"patientIdentifierSystem=http://hospital.example/mrn\" \\"
+echo "  -d \"patientIdentifierValue=<MRN_OF_ANOTHER_PATIENT>\" \\"
+echo "  -d \"q1=1&q2=1&q3=1&q4=1&q5=1&q6=1&q7=1&q8=1&q9=1&q10=0\""
+echo ""
+
+# Test G: High-risk threshold boundary (offline, no server needed)
+echo "Test G: Total exactly at HIGH_RISK_THRESHOLD (13) with Q10=0, under both operators"
+echo "HIGH_RISK_OPERATOR=gte ./epds-service -score -answers 3,3,3,3,1,0,0,0,0,0"
+echo "HIGH_RISK_OPERATOR=gt ./epds-service -score -answers 3,3,3,3,1,0,0,0,0,0"
+echo ""
+
+# Test H: Concurrent token refresh (start the service fresh so no token is cached)
+echo "Test H: 100 concurrent submissions right after startup share a single Oystehr token fetch"
+echo "RATE_LIMIT_PER_MINUTE=0 ./epds-service 2> epds.log &"
+echo "seq 100 | xargs -P100 -I{} curl -s -o /dev/null -w '%{http_code}\\n' -X POST $BASE_URL$ENDPOINT \\"
+echo "  -d \"patientId=<PATIENT_UUID>&q1=0&q2=0&q3=0&q4=0&q5=0&q6=0&q7=0&q8=0&q9=0&q10=0\" | sort | uniq -c"
+echo "grep -c 'Fetching new Oystehr token' epds.log"
+echo "(Covered without a live server by: go test -race ./internal/auth -run Concurrent)"
+echo ""
+
+# Test I: Self-harm path - total below 13 but Q10 positive must still alert
+echo "Test I: Low total (8) with Q10=2 still creates Flag and Communication (self-harm path)"
+echo "Replace <PATIENT_UUID> and <ENCOUNTER_UUID> with actual values:"
+echo "curl -s -X POST $BASE_URL$ENDPOINT -H \"Accept: application/fhir+json\" \\"
+echo "  -d \"patientId=<PATIENT_UUID>&encounterId=<ENCOUNTER_UUID>\" \\"
+echo "  -d \"q1=1&q2=1&q3=1&q4=1&q5=1&q6=1&q7=0&q8=0&q9=0&q10=2\" \\"
+echo "  | grep -o '\"resourceType\":\"[A-Za-z]*\"\\|Q10 Risk ([0-9])\\|Q10 Score: [0-9]'"
+echo "(Covered without a live server by: go test ./cmd/epds-service -run SelfHarm)"
+echo ""
+
 echo "Expected behaviors:"
 echo "- Test A: Should find active encounter automatically"
 echo "- Test B: Should resolve patient ID from identifier"
 echo "- Test C: Should use explicit encounter ID"  
 echo "- Test D: Should create Observation but no Flag (low risk)"
-echo "- Test E: Should return error about missing patient info"
\ No newline at end of file
+echo "- Test E: Should return error about missing patient info"
+echo "- Test F: Should return 409 because patientId and identifier disagree"
+echo "- Test G: gte prints highRisk=true, gt prints highRisk=false (total=13 q10=0 for both)"
+echo "- Test H: Every request returns 200 and the log shows exactly 1 token fetch"
+echo "- Test I: Bundle lists Observation, Flag (Q10 Risk (2)) and Communication (Q10 Score: 2)"